@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -13,6 +14,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/kubeshark/kubeshark/config"
 	"github.com/kubeshark/kubeshark/config/configStructs"
+	"github.com/kubeshark/kubeshark/internal/scripting"
 	"github.com/kubeshark/kubeshark/kubernetes"
 	"github.com/kubeshark/kubeshark/utils"
 	"github.com/rs/zerolog/log"
@@ -22,12 +24,124 @@ import (
 var consoleCmd = &cobra.Command{
 	Use:   "console",
 	Short: "Stream the scripting console logs into shell",
+	Long: `Stream the records scripts write with console.log, debug, info, warn and error. Filter them by
+minimum level and by script title, and print them as coloured text or as JSON lines.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		runConsole()
+		filter, err := consoleFilterFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		runConsole(filter)
 		return nil
 	},
 }
 
+// consoleFilter selects the console records to print, and how. The zero value prints every
+// record as text.
+type consoleFilter struct {
+	level   scripting.LogLevel
+	scripts []string
+	json    bool
+}
+
+// consoleFilterFromFlags reads the filter of the console command's flags
+func consoleFilterFromFlags(cmd *cobra.Command) (filter consoleFilter, err error) {
+	level, _ := cmd.Flags().GetString("level")
+	if filter.level, err = scripting.ParseLogLevel(level); err != nil {
+		return
+	}
+
+	filter.scripts, _ = cmd.Flags().GetStringSlice("script")
+
+	output, _ := cmd.Flags().GetString("output")
+	switch output {
+	case "text":
+	case "json":
+		filter.json = true
+	default:
+		err = fmt.Errorf("unknown output %q, expected text or json", output)
+	}
+
+	return
+}
+
+// selects reports whether the filter selects a record
+func (f consoleFilter) selects(record scripting.ConsoleRecord) bool {
+	if !record.Level.AtLeast(f.level) {
+		return false
+	}
+	if len(f.scripts) == 0 {
+		return true
+	}
+
+	for _, script := range f.scripts {
+		if script == record.Script {
+			return true
+		}
+	}
+
+	return false
+}
+
+// consoleLevelColors colour the levels of the text output
+var consoleLevelColors = map[scripting.LogLevel]string{
+	scripting.LogDebug: utils.Blue,
+	scripting.LogInfo:  utils.Green,
+	scripting.LogWarn:  utils.Yellow,
+	scripting.LogError: utils.Red,
+}
+
+// printConsoleMessage prints a message of the console stream if the filter selects it.
+// Messages that aren't records, from workers predating them, are errors if they are marked
+// :ERROR] and info otherwise.
+func printConsoleMessage(msg string, filter consoleFilter) {
+	record, ok := scripting.ParseConsoleRecord(msg)
+	if !ok {
+		record = scripting.ConsoleRecord{Level: scripting.LogInfo, Message: msg}
+		if strings.Contains(msg, ":ERROR]") {
+			record.Level = scripting.LogError
+		}
+	}
+	if !filter.selects(record) {
+		return
+	}
+
+	out := os.Stdout
+	if record.Level == scripting.LogError {
+		out = os.Stderr
+	}
+
+	if filter.json {
+		data, err := json.Marshal(record)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+		fmt.Fprintln(out, string(data))
+		return
+	}
+
+	if !ok {
+		if record.Level == scripting.LogError {
+			msg = fmt.Sprintf(utils.Red, msg)
+		}
+		fmt.Fprintln(out, msg)
+		return
+	}
+
+	text := record.Message
+	if fields := record.FieldsText(); fields != "" {
+		text += " " + fields
+	}
+	fmt.Fprintf(out, "%s %s %s %s\n",
+		record.Time.Local().Format("15:04:05.000"),
+		fmt.Sprintf(utils.Cyan, "["+record.Script+"]"),
+		fmt.Sprintf(consoleLevelColors[record.Level], fmt.Sprintf("%-5s", strings.ToUpper(string(record.Level)))),
+		text,
+	)
+}
+
 func init() {
 	rootCmd.AddCommand(consoleCmd)
 
@@ -39,9 +153,12 @@ func init() {
 	consoleCmd.Flags().Uint16(configStructs.ProxyFrontPortLabel, defaultTapConfig.Proxy.Front.Port, "Provide a custom port for the Kubeshark")
 	consoleCmd.Flags().String(configStructs.ProxyHostLabel, defaultTapConfig.Proxy.Host, "Provide a custom host for the Kubeshark")
 	consoleCmd.Flags().StringP(configStructs.ReleaseNamespaceLabel, "s", defaultTapConfig.Release.Namespace, "Release namespace of Kubeshark")
+	consoleCmd.Flags().String("level", string(scripting.LogDebug), "Print the records of this level and above: debug, info, warn or error")
+	consoleCmd.Flags().StringSlice("script", nil, "Print only the records of these script titles")
+	consoleCmd.Flags().StringP("output", "o", "text", "Print the records as coloured text or as JSON lines: text or json")
 }
 
-func runConsoleWithoutProxy() {
+func runConsoleWithoutProxy(filter consoleFilter) {
 	log.Info().Msg("Starting scripting console ...")
 	time.Sleep(5 * time.Second)
 	hubUrl := kubernetes.GetHubUrl()
@@ -87,12 +204,9 @@ func runConsoleWithoutProxy() {
 					break // Break to reconnect
 				}
 
-				msg := string(message)
-				if strings.Contains(msg, ":ERROR]") {
-					msg = fmt.Sprintf(utils.Red, msg)
-					fmt.Fprintln(os.Stderr, msg)
-				} else {
-					fmt.Fprintln(os.Stdout, msg)
+				// A message may hold several records, one per line
+				for _, msg := range strings.Split(strings.TrimRight(string(message), "\n"), "\n") {
+					printConsoleMessage(msg, filter)
 				}
 			}
 		}()
@@ -121,8 +235,8 @@ func runConsoleWithoutProxy() {
 	}
 }
 
-func runConsole() {
-	go runConsoleWithoutProxy()
+func runConsole(filter consoleFilter) {
+	go runConsoleWithoutProxy(filter)
 
 	// Create interrupt channel and setup signal handling once
 	interrupt := make(chan os.Signal, 1)
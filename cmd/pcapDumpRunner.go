@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/kubeshark/gopacket/pcapgo"
+	"github.com/kubeshark/kubeshark/internal/worker"
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -236,7 +237,11 @@ func mergePCAPs(outputFile string, inputFiles []string) error {
 			// Read packet data
 			data, ci, err := reader.ReadPacketData()
 			if err != nil {
-				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if errors.Is(err, io.ErrUnexpectedEOF) {
+					log.Warn().Str("file", file.Name()).Msg("PCAP file ends with a truncated packet, the packet was skipped")
 					break
 				}
 				mergingErrs = append(mergingErrs, fmt.Errorf("error reading packet from file %s: %w", file.Name(), err))
@@ -329,6 +334,12 @@ func copyPcapFiles(clientset *kubernetes.Clientset, config *rest.Config, destDir
 		return nil
 	}
 
+	copiedFiles = verifyPcapFiles(copiedFiles)
+	if len(copiedFiles) == 0 {
+		log.Info().Msg("No valid pcaps to merge")
+		return nil
+	}
+
 	// Generate a temporary filename for the merged file
 	tempMergedFile := copiedFiles[0] + "_temp"
 
@@ -362,6 +373,38 @@ func copyPcapFiles(clientset *kubernetes.Clientset, config *rest.Config, destDir
 	return nil
 }
 
+// verifyPcapFiles repairs truncated PCAP files before they are merged and reports what
+// was repaired. Files that can't be repaired are left in place and excluded from the merge.
+func verifyPcapFiles(files []string) []string {
+	var valid []string
+	var repaired, skipped int
+
+	for _, file := range files {
+		v, err := worker.RepairPcap(file)
+		if err != nil && v.Size == 0 {
+			log.Debug().Msgf("Skipped empty file: %s", file)
+			continue
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("Skipping PCAP file %s, it can't be merged", file)
+			skipped++
+			continue
+		}
+
+		if !v.Intact() {
+			log.Info().Msgf("Repaired PCAP file %s: %s, truncated %d bytes after %d packets", file, v.Reason, v.Size-v.ValidSize, v.Records)
+			repaired++
+		}
+		valid = append(valid, file)
+	}
+
+	if repaired > 0 || skipped > 0 {
+		log.Info().Msgf("Verified %d PCAP files: %d repaired, %d skipped", len(files), repaired, skipped)
+	}
+
+	return valid
+}
+
 func getClusterID(clientset *kubernetes.Clientset) (string, error) {
 	namespace, err := clientset.CoreV1().Namespaces().Get(context.TODO(), "kube-system", metav1.GetOptions{})
 	if err != nil {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/kubeshark/kubeshark/config"
 	"github.com/kubeshark/kubeshark/config/configStructs"
+	"github.com/kubeshark/kubeshark/internal/scripting"
 	"github.com/kubeshark/kubeshark/kubernetes"
 	"github.com/kubeshark/kubeshark/misc"
 	"github.com/rs/zerolog/log"
@@ -119,6 +121,7 @@ func createScript(provider *kubernetes.Provider, script misc.ConfigMapScript) (i
 
 		_, err = kubernetes.SetConfig(provider, kubernetes.CONFIG_SCRIPTING_SCRIPTS, string(data))
 		if err == nil {
+			recordScriptVersion(provider, script)
 			return index, nil
 		}
 
@@ -155,6 +158,7 @@ func updateScript(provider *kubernetes.Provider, index int64, script misc.Config
 		return
 	}
 
+	recordScriptVersion(provider, script)
 	return
 }
 
@@ -184,6 +188,79 @@ func deleteScript(provider *kubernetes.Provider, index int64) (err error) {
 	return
 }
 
+// libraryDirs returns the library folders next to the `scripting.source` and `scripting.sources` folders
+func libraryDirs() []string {
+	sources := config.Config.Scripting.Sources
+	if config.Config.Scripting.Source != "" {
+		sources = append([]string{config.Config.Scripting.Source}, sources...)
+	}
+
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		dir := filepath.Join(filepath.Dir(filepath.Clean(source)), scripting.LibraryDir)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// readLibraries reads the modules of the library folders by name. A module of a later
+// folder replaces a module of the same name.
+func readLibraries() (map[string]string, error) {
+	libraries := make(map[string]string)
+	for _, dir := range libraryDirs() {
+		modules, err := scripting.LoadLibraries(dir)
+		if err != nil {
+			return nil, err
+		}
+		for name, code := range modules {
+			libraries[name] = code
+		}
+	}
+
+	return libraries, nil
+}
+
+// isLibraryFile reports whether a path lies in one of the library folders
+func isLibraryFile(path string) bool {
+	for _, dir := range libraryDirs() {
+		if relative, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(relative, "..") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// syncLibraries uploads the library modules, so the scripts can require them
+func syncLibraries(provider *kubernetes.Provider) error {
+	libraries, err := readLibraries()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(libraries)
+	if err != nil {
+		return err
+	}
+
+	_, err = kubernetes.SetConfig(provider, kubernetes.CONFIG_SCRIPTING_LIBRARIES, string(data))
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("modules", len(libraries)).Msg("Synchronized library modules with ConfigMap.")
+	return nil
+}
+
 func watchScripts(ctx context.Context, provider *kubernetes.Provider, block bool) {
 	files := make(map[string]int64)
 
@@ -193,7 +270,16 @@ func watchScripts(ctx context.Context, provider *kubernetes.Provider, block bool
 		return
 	}
 
+	// The libraries go first, so the scripts find the modules they require
+	if err := syncLibraries(provider); err != nil {
+		log.Error().Err(err).Msg("Failed to upload library modules")
+	}
+
 	for _, script := range scripts {
+		if !lintScript(script) {
+			continue
+		}
+
 		index, err := createScript(provider, script.ConfigMap())
 		if err != nil {
 			log.Error().Err(err).Send()
@@ -243,6 +329,12 @@ func watchScripts(ctx context.Context, provider *kubernetes.Provider, block bool
 					log.Info().Str("file", event.Name).Msg("Ignoring file")
 					continue
 				}
+				if isLibraryFile(event.Name) {
+					if err := syncLibraries(provider); err != nil {
+						log.Error().Err(err).Msg("Failed to upload library modules")
+					}
+					continue
+				}
 				switch event.Op {
 				case fsnotify.Create:
 					script, err := misc.ReadScriptFile(event.Name)
@@ -250,6 +342,9 @@ func watchScripts(ctx context.Context, provider *kubernetes.Provider, block bool
 						log.Error().Err(err).Send()
 						continue
 					}
+					if !lintScript(script) {
+						continue
+					}
 
 					index, err := createScript(provider, script.ConfigMap())
 					if err != nil {
@@ -260,12 +355,26 @@ func watchScripts(ctx context.Context, provider *kubernetes.Provider, block bool
 					files[script.Path] = index
 
 				case fsnotify.Write:
-					index := files[event.Name]
 					script, err := misc.ReadScriptFile(event.Name)
 					if err != nil {
 						log.Error().Err(err).Send()
 						continue
 					}
+					if !lintScript(script) {
+						continue
+					}
+
+					// A script that failed the lint before isn't in the ConfigMap yet
+					index, ok := files[event.Name]
+					if !ok {
+						index, err = createScript(provider, script.ConfigMap())
+						if err != nil {
+							log.Error().Err(err).Send()
+							continue
+						}
+						files[event.Name] = index
+						continue
+					}
 
 					err = updateScript(provider, index, script.ConfigMap())
 					if err != nil {
@@ -274,12 +383,16 @@ func watchScripts(ctx context.Context, provider *kubernetes.Provider, block bool
 					}
 
 				case fsnotify.Rename:
-					index := files[event.Name]
+					index, ok := files[event.Name]
+					if !ok {
+						continue
+					}
 					err := deleteScript(provider, index)
 					if err != nil {
 						log.Error().Err(err).Send()
 						continue
 					}
+					delete(files, event.Name)
 
 				default:
 					// pass
@@ -305,7 +418,20 @@ func watchScripts(ctx context.Context, provider *kubernetes.Provider, block bool
 		}
 	}
 
-	log.Info().Str("folder", config.Config.Scripting.Source).Interface("folders", config.Config.Scripting.Sources).Msg("Watching scripts against changes:")
+	// Watch the library folders and their subfolders, the watcher isn't recursive
+	for _, dir := range libraryDirs() {
+		err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+			if err != nil || !entry.IsDir() {
+				return err
+			}
+			return watcher.Add(path)
+		})
+		if err != nil {
+			log.Error().Err(err).Send()
+		}
+	}
+
+	log.Info().Str("folder", config.Config.Scripting.Source).Interface("folders", config.Config.Scripting.Sources).Strs("libraries", libraryDirs()).Msg("Watching scripts against changes:")
 
 	if block {
 		<-ctx.Done()
@@ -371,7 +497,15 @@ func runScriptsSync(provider *kubernetes.Provider) {
 		return
 	}
 
+	if err := syncLibraries(provider); err != nil {
+		log.Error().Err(err).Msg("Failed to upload library modules")
+	}
+
 	for _, script := range scripts {
+		if !lintScript(script) {
+			continue
+		}
+
 		index, err := createScript(provider, script.ConfigMap())
 		if err != nil {
 			log.Error().Err(err).Send()
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/kubeshark/kubeshark/config"
+	"github.com/kubeshark/kubeshark/internal/scripting"
+	"github.com/kubeshark/kubeshark/kubernetes"
+	"github.com/kubeshark/kubeshark/misc"
+	"github.com/kubeshark/kubeshark/utils"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var scriptsHistoryCmd = &cobra.Command{
+	Use:   "history <title>",
+	Short: "List the versions of a script recorded in the cluster",
+	Long: `List the versions of a script recorded each time it was uploaded, with their hash, author and
+time. The version currently in the cluster is marked with *. Up to ` + "`scripting.historyLimit`" + ` versions
+are kept per script.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runScriptsHistory(args[0])
+	},
+}
+
+func init() {
+	scriptsCmd.AddCommand(scriptsHistoryCmd)
+}
+
+func runScriptsHistory(title string) error {
+	kubernetesProvider, err := getKubernetesProviderForCli(false, false)
+	if err != nil {
+		return err
+	}
+
+	history, err := getScriptHistory(kubernetesProvider)
+	if err != nil {
+		return err
+	}
+	versions := history[title]
+	if len(versions) == 0 {
+		return fmt.Errorf("the script %q has no recorded versions", title)
+	}
+
+	current := ""
+	if _, script, ok := findScript(kubernetesProvider, title); ok {
+		current = scripting.HashScript(script.Code)
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		marker := " "
+		// A rolled back script matches older versions too, only the latest is current
+		if version.Hash == current {
+			marker = fmt.Sprintf(utils.Green, "*")
+			current = ""
+		}
+		fmt.Printf("%s %4d  %s  %-24s  %s\n", marker, version.Version, version.ShortHash(), version.Author, version.Time.Local().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// getScriptHistory returns the versions of the scripts recorded in the ConfigMap
+func getScriptHistory(provider *kubernetes.Provider) (scripting.ScriptHistory, error) {
+	data, err := kubernetes.GetConfig(provider, kubernetes.CONFIG_SCRIPTING_HISTORY)
+	if err != nil {
+		return nil, err
+	}
+
+	return scripting.ParseScriptHistory(data)
+}
+
+// recordScriptVersion adds the code of an uploaded script to its history, unless it is the
+// latest version already. The oldest versions are dropped to keep the history under
+// scripting.MaxScriptHistorySize. A failure is logged, the upload itself succeeded.
+func recordScriptVersion(provider *kubernetes.Provider, script misc.ConfigMapScript) {
+	var version scripting.ScriptVersion
+	var recorded bool
+	var dropped int
+	err := kubernetes.UpdateConfig(provider, kubernetes.CONFIG_SCRIPTING_HISTORY, func(data string) (string, error) {
+		history, err := scripting.ParseScriptHistory(data)
+		if err != nil {
+			return "", err
+		}
+
+		version, recorded = history.Record(script.Title, script.Code, scriptAuthor(), time.Now(), config.Config.Scripting.HistoryLimit)
+		if !recorded {
+			return data, nil
+		}
+
+		dropped, err = history.Trim(scripting.MaxScriptHistorySize)
+		if err != nil {
+			return "", err
+		}
+
+		encoded, err := json.Marshal(history)
+		return string(encoded), err
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("title", script.Title).Msg("Failed to record the script version")
+		return
+	}
+	if !recorded {
+		return
+	}
+
+	if dropped > 0 {
+		log.Info().Int("versions", dropped).Msg("Dropped the oldest script versions to keep the history within its size limit")
+	}
+	log.Info().Str("title", script.Title).Int("version", version.Version).Str("hash", version.ShortHash()).Msg("Recorded script version")
+}
+
+// scriptAuthor returns who uploads the scripts, as user@host
+func scriptAuthor() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+
+	return name
+}
+
+// findScript returns the index and the entry of the titled script in the ConfigMap
+func findScript(provider *kubernetes.Provider, title string) (int64, misc.ConfigMapScript, bool) {
+	scripts, err := kubernetes.ConfigGetScripts(provider)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 0, misc.ConfigMapScript{}, false
+	}
+
+	for index, script := range scripts {
+		if script.Title == title {
+			return index, script, true
+		}
+	}
+
+	return 0, misc.ConfigMapScript{}, false
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kubeshark/kubeshark/config"
+	"github.com/kubeshark/kubeshark/internal/scripting"
+	"github.com/kubeshark/kubeshark/misc"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var scriptsLintCmd = &cobra.Command{
+	Use:   "lint [script...]",
+	Short: "Check the scripts in `scripting.source` and/or `scripting.sources` for problems before they are uploaded",
+	Long: `Check the scripts for ES6 syntax the scripting engine can't run, missing title comments, unknown
+hook names, calls to bindings that don't exist or are disabled by the config, and loops that never
+exit. Pass script file names or titles to check only those.
+
+The exit code is 1 when a script has errors. Warnings don't change the exit code.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		os.Exit(runScriptsLint(args))
+		return nil
+	},
+}
+
+func init() {
+	scriptsCmd.AddCommand(scriptsLintCmd)
+}
+
+func runScriptsLint(filter []string) int {
+	scripts, err := config.Config.Scripting.GetScripts()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return 1
+	}
+
+	code := 0
+	for _, script := range scripts {
+		if !scriptSelected(filter, script.Path, script.Title) {
+			continue
+		}
+
+		findings := scripting.LintScript(script.Path, script.Code, scriptLintOptions())
+		for _, finding := range findings {
+			fmt.Println(finding)
+		}
+		if scripting.HasLintErrors(findings) {
+			code = 1
+		}
+	}
+
+	return code
+}
+
+// lintScript logs the findings of a script and reports whether it can be uploaded
+func lintScript(script *misc.Script) bool {
+	findings := scripting.LintScript(script.Path, script.Code, scriptLintOptions())
+	for _, finding := range findings {
+		if finding.Severity == scripting.LintError {
+			log.Error().Str("script", script.Path).Msg(finding.String())
+		} else {
+			log.Warn().Str("script", script.Path).Msg(finding.String())
+		}
+	}
+
+	if scripting.HasLintErrors(findings) {
+		log.Error().Str("script", script.Path).Msg("Not uploading the script until its lint errors are fixed.")
+		return false
+	}
+
+	return true
+}
+
+// scriptLintOptions describes the scripting runtime configured for the cluster
+func scriptLintOptions() scripting.LintOptions {
+	return scripting.LintOptions{
+		AllowFileSystem: config.Config.Scripting.AllowFileSystem,
+		AllowNetwork:    config.Config.Scripting.AllowNetwork,
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var scriptsRollbackCmd = &cobra.Command{
+	Use:   "rollback <title>",
+	Short: "Restore a version of a script from its history in the cluster",
+	Long: `Restore the code of a recorded version of a script, see ` + "`scripts history`" + `. The restored
+code is recorded as a new version. The local script file isn't changed, so a later edit of the
+file uploads it again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		version, _ := cmd.Flags().GetInt("to")
+		return runScriptsRollback(args[0], version)
+	},
+}
+
+func init() {
+	scriptsCmd.AddCommand(scriptsRollbackCmd)
+
+	scriptsRollbackCmd.Flags().Int("to", 0, "The version to restore")
+	if err := scriptsRollbackCmd.MarkFlagRequired("to"); err != nil {
+		log.Debug().Err(err).Send()
+	}
+}
+
+func runScriptsRollback(title string, to int) error {
+	kubernetesProvider, err := getKubernetesProviderForCli(false, false)
+	if err != nil {
+		return err
+	}
+
+	history, err := getScriptHistory(kubernetesProvider)
+	if err != nil {
+		return err
+	}
+	version, ok := history.Version(title, to)
+	if !ok {
+		return fmt.Errorf("version %d isn't in the history of the script %q", to, title)
+	}
+
+	index, script, ok := findScript(kubernetesProvider, title)
+	if !ok {
+		return fmt.Errorf("the script %q isn't in the cluster", title)
+	}
+
+	script.Code = version.Code
+	if err := updateScript(kubernetesProvider, index, script); err != nil {
+		return err
+	}
+
+	log.Info().Str("title", title).Int("version", to).Str("hash", version.ShortHash()).Msg("Rolled back script")
+	return nil
+}
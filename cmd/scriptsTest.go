@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubeshark/kubeshark/config"
+	"github.com/kubeshark/kubeshark/internal/scripting"
+	"github.com/kubeshark/kubeshark/utils"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of `scripts test`
+const (
+	scriptsTestPassed = 0
+	scriptsTestFailed = 1
+	scriptsTestError  = 2
+)
+
+var scriptsTestCmd = &cobra.Command{
+	Use:   "test [script...]",
+	Short: "Run the scripts in `scripting.source` and/or `scripting.sources` locally against the cases of their .spec.json files",
+	Long: `Run the scripts locally with mocked bindings. Each script is tested against the cases of its
+sidecar spec, name.spec.json next to name.js, which feed JSON fixtures to its hooks. Scripts
+without a spec are skipped. Pass script file names or titles to test only those.
+
+The exit code is 0 when all cases pass, 1 when a case fails and 2 when a script or spec can't be loaded.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetInt("timeout")
+		os.Exit(runScriptsTest(args, timeout))
+		return nil
+	},
+}
+
+func init() {
+	scriptsCmd.AddCommand(scriptsTestCmd)
+
+	scriptsTestCmd.Flags().Int("timeout", 5000, "Timeout of each script execution and hook call in milliseconds")
+}
+
+func runScriptsTest(filter []string, timeoutMs int) int {
+	scripts, err := config.Config.Scripting.GetScripts()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return scriptsTestError
+	}
+	if len(scripts) == 0 {
+		log.Error().Msg("No scripts found in the `scripting.source` and `scripting.sources` folders.")
+		return scriptsTestError
+	}
+
+	libraries, err := readLibraries()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return scriptsTestError
+	}
+
+	code := scriptsTestPassed
+	var passed, failed, skipped int
+	for _, script := range scripts {
+		if !scriptSelected(filter, script.Path, script.Title) {
+			continue
+		}
+
+		specPath := scripting.SpecPath(script.Path)
+		spec, err := scripting.LoadTestSpec(specPath)
+		if errors.Is(err, os.ErrNotExist) {
+			log.Debug().Str("script", script.Path).Msg("Skipping script without a spec.")
+			skipped++
+			continue
+		}
+		if err != nil {
+			log.Error().Str("script", script.Path).Err(err).Msg("Failed to load spec.")
+			code = scriptsTestError
+			continue
+		}
+
+		results, err := scripting.RunScriptTests(script.Title, script.Code, spec, libraries, timeoutMs)
+		if err != nil {
+			log.Error().Str("script", script.Path).Err(err).Msg("Failed to run script.")
+			code = scriptsTestError
+			continue
+		}
+
+		for _, result := range results {
+			if result.Passed() {
+				passed++
+				fmt.Printf("%s %s: %s\n", fmt.Sprintf(utils.Green, "PASS"), script.Path, result.Case)
+				continue
+			}
+
+			failed++
+			fmt.Printf("%s %s: %s\n", fmt.Sprintf(utils.Red, "FAIL"), script.Path, result.Case)
+			for _, failure := range result.Failures {
+				fmt.Printf("    %s\n", failure)
+			}
+			if result.Output != "" {
+				fmt.Printf("    output:\n        %s\n", strings.ReplaceAll(strings.TrimSpace(result.Output), "\n", "\n        "))
+			}
+			if code == scriptsTestPassed {
+				code = scriptsTestFailed
+			}
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed, %d scripts skipped\n", passed, failed, skipped)
+
+	return code
+}
+
+// scriptSelected reports whether a script is selected by the file names or titles given
+// on the command line. No filter selects all scripts.
+func scriptSelected(filter []string, path string, title string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	for _, selected := range filter {
+		if selected == title || selected == filepath.Base(path) || filepath.Clean(selected) == filepath.Clean(path) {
+			return true
+		}
+	}
+
+	return false
+}
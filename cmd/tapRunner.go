@@ -430,7 +430,7 @@ func postFrontStarted(ctx context.Context, kubernetesProvider *kubernetes.Provid
 	}
 
 	if config.Config.Scripting.Console {
-		go runConsoleWithoutProxy()
+		go runConsoleWithoutProxy(consoleFilter{})
 	}
 }
 
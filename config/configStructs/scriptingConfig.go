@@ -1,53 +1,5 @@
 package configStructs
-package configStructs
-
-// ScriptingConfig represents configuration for the scripting system
-type ScriptingConfig struct {
-	// Enabled indicates whether scripting is enabled
-	Enabled bool `yaml:"enabled" json:"enabled" default:"true"`
-	
-	// Timeout is the maximum execution time for scripts in milliseconds
-	Timeout int `yaml:"timeout" json:"timeout" default:"5000"`
-	
-	// MaxMemory is the maximum memory usage for scripts in megabytes
-	MaxMemory int `yaml:"maxMemory" json:"maxMemory" default:"100"`
-	
-	// AllowFileSystem indicates whether scripts can access the file system
-	AllowFileSystem bool `yaml:"allowFileSystem" json:"allowFileSystem" default:"false"`
-	
-	// AllowNetwork indicates whether scripts can access the network
-	AllowNetwork bool `yaml:"allowNetwork" json:"allowNetwork" default:"false"`
-}
 
-// ScriptingPermissions represents user permissions for scripting
-type ScriptingPermissions struct {
-	// CanSave indicates whether the user can save scripts
-	CanSave bool `yaml:"canSave" json:"canSave" default:"false"`
-	
-	// CanActivate indicates whether the user can activate scripts
-	CanActivate bool `yaml:"canActivate" json:"canActivate" default:"false"`
-	
-	// CanDelete indicates whether the user can delete scripts
-	CanDelete bool `yaml:"canDelete" json:"canDelete" default:"false"`
-}
-// ScriptingConfig defines configuration options for the scripting subsystem
-type ScriptingConfig struct {
-	// Enabled determines if scripting functionality is available
-	Enabled bool `yaml:"enabled" json:"enabled" default:"false"`
-	
-	// RetentionLimit sets the maximum duration (in seconds) that scripts can retain PCAP files
-	// Setting to 0 means no limit
-	RetentionLimit int `yaml:"retentionLimit" json:"retentionLimit" default:"3600"`
-	
-	// ScriptsDir is the directory where scripts are stored
-	ScriptsDir string `yaml:"scriptsDir" json:"scriptsDir" default:"scripts"`
-	
-	// MaxConcurrency is the maximum number of scripts that can run concurrently
-	MaxConcurrency int `yaml:"maxConcurrency" json:"maxConcurrency" default:"10"`
-	
-	// Timeout is the maximum execution time for a script in milliseconds
-	Timeout int `yaml:"timeout" json:"timeout" default:"5000"`
-}
 import (
 	"fmt"
 	"io/fs"
@@ -66,6 +18,25 @@ type ScriptingConfig struct {
 	WatchScripts bool                   `yaml:"watchScripts" json:"watchScripts" default:"true"`
 	Active       []string               `yaml:"active" json:"active" default:"[]"`
 	Console      bool                   `yaml:"console" json:"console" default:"true"`
+	HistoryLimit int                    `yaml:"historyLimit" json:"historyLimit" default:"10"`
+	Enabled      bool                   `yaml:"enabled" json:"enabled" default:"true"`
+	// Timeout and QueueTimeout are in milliseconds
+	Timeout          int      `yaml:"timeout" json:"timeout" default:"5000"`
+	MaxConcurrency   int      `yaml:"maxConcurrency" json:"maxConcurrency" default:"10"`
+	QueueTimeout     int      `yaml:"queueTimeout" json:"queueTimeout" default:"10000"`
+	AllowFileSystem  bool     `yaml:"allowFileSystem" json:"allowFileSystem" default:"false"`
+	FileSystemRoot   string   `yaml:"fileSystemRoot" json:"fileSystemRoot" default:"scripts-data"`
+	FileSystemQuota  int64    `yaml:"fileSystemQuota" json:"fileSystemQuota" default:"1073741824"`
+	AllowNetwork     bool     `yaml:"allowNetwork" json:"allowNetwork" default:"false"`
+	NetworkAllowlist []string `yaml:"networkAllowlist" json:"networkAllowlist" default:"[]"`
+	MaxResponseSize  int64    `yaml:"maxResponseSize" json:"maxResponseSize" default:"1048576"`
+	StoreDir         string   `yaml:"storeDir" json:"storeDir" default:"scripts-store"`
+	StoreQuota       int64    `yaml:"storeQuota" json:"storeQuota" default:"1048576"`
+	MaxOperations    int64    `yaml:"maxOperations" json:"maxOperations" default:"0"`
+	MaxMemory        int64    `yaml:"maxMemory" json:"maxMemory" default:"104857600"`
+	MaxOutput        int64    `yaml:"maxOutput" json:"maxOutput" default:"1048576"`
+	MaxBindingCalls  int64    `yaml:"maxBindingCalls" json:"maxBindingCalls" default:"0"`
+	MaxRetentions    int64    `yaml:"maxRetentions" json:"maxRetentions" default:"100"`
 }
 
 func (config *ScriptingConfig) GetScripts() (scripts []*misc.Script, err error) {
@@ -78,26 +49,7 @@ func (config *ScriptingConfig) GetScripts() (scripts []*misc.Script, err error)
 		Source string
 		File   fs.DirEntry
 	}
-package configStructs
 
-// ScriptingConfig defines configuration options for the scripting subsystem
-type ScriptingConfig struct {
-	// Enabled determines if scripting functionality is available
-	Enabled bool `yaml:"enabled" json:"enabled" default:"false"`
-	
-	// RetentionLimit sets the maximum duration (in seconds) that scripts can retain PCAP files
-	// Setting to 0 means no limit
-	RetentionLimit int `yaml:"retentionLimit" json:"retentionLimit" default:"3600"`
-	
-	// ScriptsDir is the directory where scripts are stored
-	ScriptsDir string `yaml:"scriptsDir" json:"scriptsDir" default:"scripts"`
-	
-	// MaxConcurrency is the maximum number of scripts that can run concurrently
-	MaxConcurrency int `yaml:"maxConcurrency" json:"maxConcurrency" default:"10"`
-	
-	// Timeout is the maximum execution time for a script in milliseconds
-	Timeout int `yaml:"timeout" json:"timeout" default:"5000"`
-}
 	// Handle single Source directory
 	if config.Source != "" {
 		files, err := os.ReadDir(config.Source)
@@ -127,19 +79,7 @@ type ScriptingConfig struct {
 			}
 		}
 	}
-package configStructs
 
-// ScriptingConfig represents configuration for scripting capabilities
-type ScriptingConfig struct {
-	// Enabled indicates whether scripting is enabled
-	Enabled bool `yaml:"enabled" json:"enabled" default:"true"`
-	
-	// Timeout is the maximum execution time for scripts in milliseconds
-	Timeout int `yaml:"timeout" json:"timeout" default:"5000"`
-	
-	// MaxMemory is the maximum memory usage for scripts in megabytes
-	MaxMemory int `yaml:"maxMemory" json:"maxMemory" default:"100"`
-}
 	// Iterate over all collected files
 	for _, f := range allFiles {
 		if f.File.IsDir() {
@@ -159,14 +99,7 @@ type ScriptingConfig struct {
 		if err != nil {
 			return nil, fmt.Errorf("failed to read script file %s: %v", path, err)
 		}
-package configStructs
 
-// ScriptingConfig defines settings for the scripting engine
-type ScriptingConfig struct {
-	Enabled       bool   `yaml:"enabled" default:"false"`
-	TimeoutMs     int    `yaml:"timeoutMs" default:"5000"`
-	DefaultScript string `yaml:"defaultScript" default:""`
-}
 		// Append the valid script to the scripts slice
 		scripts = append(scripts, script)
 
@@ -176,58 +109,3 @@ type ScriptingConfig struct {
 	// Return the collected scripts and nil error if successful
 	return scripts, nil
 }
-package configStructs
-package configStructs
-
-// ScriptingConfig represents configuration for the scripting system
-type ScriptingConfig struct {
-	// Enabled indicates whether scripting is enabled
-	Enabled bool `yaml:"enabled" json:"enabled" default:"true"`
-	
-	// Timeout is the maximum execution time for scripts in milliseconds
-	Timeout int `yaml:"timeout" json:"timeout" default:"5000"`
-	
-	// MaxMemory is the maximum memory usage for scripts in megabytes
-	MaxMemory int `yaml:"maxMemory" json:"maxMemory" default:"100"`
-}
-
-// ScriptingPermissions represents user permissions for scripting
-type ScriptingPermissions struct {
-	// CanSave indicates whether the user can save scripts
-	CanSave bool `yaml:"canSave" json:"canSave" default:"false"`
-	
-	// CanActivate indicates whether the user can activate scripts
-	CanActivate bool `yaml:"canActivate" json:"canActivate" default:"false"`
-	
-	// CanDelete indicates whether the user can delete scripts
-	CanDelete bool `yaml:"canDelete" json:"canDelete" default:"false"`
-}
-// ScriptingConfig represents configuration for the scripting system
-type ScriptingConfig struct {
-	// Enabled indicates whether scripting is enabled
-	Enabled bool `yaml:"enabled" json:"enabled" default:"true"`
-	
-	// Timeout is the maximum execution time for scripts in milliseconds
-	Timeout int `yaml:"timeout" json:"timeout" default:"5000"`
-	
-	// MaxMemory is the maximum memory usage for scripts in megabytes
-	MaxMemory int `yaml:"maxMemory" json:"maxMemory" default:"100"`
-	
-	// AllowFileSystem indicates whether scripts can access the file system
-	AllowFileSystem bool `yaml:"allowFileSystem" json:"allowFileSystem" default:"false"`
-	
-	// AllowNetwork indicates whether scripts can access the network
-	AllowNetwork bool `yaml:"allowNetwork" json:"allowNetwork" default:"false"`
-}
-
-// ScriptingPermissions represents user permissions for scripting
-type ScriptingPermissions struct {
-	// CanSave indicates whether the user can save scripts
-	CanSave bool `yaml:"canSave" json:"canSave" default:"false"`
-	
-	// CanActivate indicates whether the user can activate scripts
-	CanActivate bool `yaml:"canActivate" json:"canActivate" default:"false"`
-	
-	// CanDelete indicates whether the user can delete scripts
-	CanDelete bool `yaml:"canDelete" json:"canDelete" default:"false"`
-}
\ No newline at end of file
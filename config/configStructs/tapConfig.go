@@ -237,6 +237,7 @@ import (
 	"fmt"
 	"regexp"
 
+	"github.com/kubeshark/kubeshark/internal/worker"
 	v1 "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
 )
@@ -682,16 +683,49 @@ type PprofConfig struct {
 }
 
 type MiscConfig struct {
-	JsonTTL                     string `yaml:"jsonTTL" json:"jsonTTL" default:"5m"`
-	PcapTTL                     string `yaml:"pcapTTL" json:"pcapTTL" default:"60s"`
-	PcapErrorTTL                string `yaml:"pcapErrorTTL" json:"pcapErrorTTL" default:"60s"`
-	TrafficSampleRate           int    `yaml:"trafficSampleRate" json:"trafficSampleRate" default:"100"`
-	TcpStreamChannelTimeoutMs   int    `yaml:"tcpStreamChannelTimeoutMs" json:"tcpStreamChannelTimeoutMs" default:"10000"`
-	TcpStreamChannelTimeoutShow bool   `yaml:"tcpStreamChannelTimeoutShow" json:"tcpStreamChannelTimeoutShow" default:"false"`
-	ResolutionStrategy          string `yaml:"resolutionStrategy" json:"resolutionStrategy" default:"auto"`
-	DuplicateTimeframe          string `yaml:"duplicateTimeframe" json:"duplicateTimeframe" default:"200ms"`
-	DetectDuplicates            bool   `yaml:"detectDuplicates" json:"detectDuplicates" default:"false"`
-	StaleTimeoutSeconds         int    `yaml:"staleTimeoutSeconds" json:"staleTimeoutSeconds" default:"30"`
+	JsonTTL                     string                 `yaml:"jsonTTL" json:"jsonTTL" default:"5m"`
+	PcapTTL                     string                 `yaml:"pcapTTL" json:"pcapTTL" default:"60s"`
+	PcapErrorTTL                string                 `yaml:"pcapErrorTTL" json:"pcapErrorTTL" default:"60s"`
+	PcapGracePeriod             string                 `yaml:"pcapGracePeriod" json:"pcapGracePeriod" default:"30s"`
+	TrafficSampleRate           int                    `yaml:"trafficSampleRate" json:"trafficSampleRate" default:"100"`
+	TcpStreamChannelTimeoutMs   int                    `yaml:"tcpStreamChannelTimeoutMs" json:"tcpStreamChannelTimeoutMs" default:"10000"`
+	TcpStreamChannelTimeoutShow bool                   `yaml:"tcpStreamChannelTimeoutShow" json:"tcpStreamChannelTimeoutShow" default:"false"`
+	ResolutionStrategy          string                 `yaml:"resolutionStrategy" json:"resolutionStrategy" default:"auto"`
+	DuplicateTimeframe          string                 `yaml:"duplicateTimeframe" json:"duplicateTimeframe" default:"200ms"`
+	DetectDuplicates            bool                   `yaml:"detectDuplicates" json:"detectDuplicates" default:"false"`
+	StaleTimeoutSeconds         int                    `yaml:"staleTimeoutSeconds" json:"staleTimeoutSeconds" default:"30"`
+	RetentionClasses            []RetentionClassConfig `yaml:"retentionClasses" json:"retentionClasses" default:"[]"`
+}
+
+// RetentionClassConfig assigns its own PCAP TTL to the streams it matches. Unset criteria
+// match every stream, and the first matching class wins.
+type RetentionClassConfig struct {
+	Name      string   `yaml:"name" json:"name"`
+	Error     *bool    `yaml:"error" json:"error,omitempty"`
+	Protocols []string `yaml:"protocols" json:"protocols,omitempty"`
+	Namespace string   `yaml:"namespace" json:"namespace,omitempty"`
+	Pod       string   `yaml:"pod" json:"pod,omitempty"`
+	Ports     []uint16 `yaml:"ports" json:"ports,omitempty"`
+	TTL       string   `yaml:"ttl" json:"ttl"`
+}
+
+// Spec returns the class in the form the worker compiles
+func (config *RetentionClassConfig) Spec() worker.RetentionClassSpec {
+	return worker.RetentionClassSpec{
+		Name:      config.Name,
+		Error:     config.Error,
+		Protocols: config.Protocols,
+		Namespace: config.Namespace,
+		Pod:       config.Pod,
+		Ports:     config.Ports,
+		TTL:       config.TTL,
+	}
+}
+
+// Validate checks the class with the worker's own rules
+func (config *RetentionClassConfig) Validate() error {
+	_, err := worker.CompileRetentionClasses([]worker.RetentionClassSpec{config.Spec()})
+	return err
 }
 
 type PcapDumpConfig struct {
@@ -796,5 +830,13 @@ func (config *TapConfig) Validate() error {
 		return fmt.Errorf("%s is not a valid regex %s", config.PodRegexStr, compileErr)
 	}
 
+	specs := make([]worker.RetentionClassSpec, 0, len(config.Misc.RetentionClasses))
+	for i := range config.Misc.RetentionClasses {
+		specs = append(specs, config.Misc.RetentionClasses[i].Spec())
+	}
+	if _, err := worker.CompileRetentionClasses(specs); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -1,27 +1,36 @@
 package scripting
-package scripting
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
+	"github.com/kubeshark/kubeshark/internal/worker"
 	"github.com/robertkrimen/otto"
 )
 
-// RegisterBindings registers all JavaScript bindings for the scripting engine
-func RegisterBindings(vm *otto.Otto, pcapHelper *PcapHelper) error {
+// RegisterBindings registers all JavaScript bindings for the scripting engine.
+// The bindings account their calls and output to meter.
+func RegisterBindings(vm *otto.Otto, pcapHelper *PcapHelper, meter *budgetMeter) error {
+	if meter == nil {
+		meter = &budgetMeter{}
+	}
+
 	// Register PCAP helper functions
-	if err := registerPcapHelperBindings(vm, pcapHelper); err != nil {
+	if err := registerPcapHelperBindings(vm, pcapHelper, meter); err != nil {
 		return fmt.Errorf("failed to register PCAP helper bindings: %w", err)
 	}
 
 	// Register console functions
-	if err := registerConsoleBindings(vm); err != nil {
+	if err := registerConsoleBindings(vm, pcapHelper.script, os.Stdout, meter); err != nil {
 		return fmt.Errorf("failed to register console bindings: %w", err)
 	}
 
 	// Register utility functions
-	if err := registerUtilBindings(vm); err != nil {
+	if err := registerUtilBindings(vm, meter); err != nil {
 		return fmt.Errorf("failed to register utility bindings: %w", err)
 	}
 
@@ -29,7 +38,7 @@ func RegisterBindings(vm *otto.Otto, pcapHelper *PcapHelper) error {
 }
 
 // registerPcapHelperBindings registers PCAP helper functions to the JavaScript VM
-func registerPcapHelperBindings(vm *otto.Otto, pcapHelper *PcapHelper) error {
+func registerPcapHelperBindings(vm *otto.Otto, pcapHelper *PcapHelper, meter *budgetMeter) error {
 	// Create pcap object
 	pcapObj, err := vm.Object("pcap = {}")
 	if err != nil {
@@ -38,22 +47,45 @@ func registerPcapHelperBindings(vm *otto.Otto, pcapHelper *PcapHelper) error {
 
 	// Register retain function
 	err = pcapObj.Set("retain", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("pcap.retain")
+		meter.retention()
 		pcapName := call.Argument(0).String()
 		durationSec, _ := call.Argument(1).ToInteger()
-		
-		pcapHelper.RetainPcap(pcapName, int(durationSec))
-		
+		namespace := optionString(call.Argument(2), "namespace")
+
+		if err := pcapHelper.RetainPcapFor(pcapName, int(durationSec), namespace); err != nil {
+			if errors.Is(err, worker.ErrRetentionQuotaExceeded) {
+				throwError(call, "RetentionQuotaError", err)
+			}
+			throwError(call, "Error", err)
+		}
+
 		return otto.UndefinedValue()
 	})
 	if err != nil {
 		return err
 	}
 
+	// Register listRetentions function
+	err = pcapObj.Set("listRetentions", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("pcap.listRetentions")
+		listing, err := pcapHelper.ListRetentions()
+		if err != nil {
+			throwError(call, "Error", err)
+		}
+
+		return toJSValue(call, listing)
+	})
+	if err != nil {
+		return err
+	}
+
 	// Register isRetained function
 	err = pcapObj.Set("isRetained", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("pcap.isRetained")
 		pcapName := call.Argument(0).String()
 		isRetained := pcapHelper.IsRetained(pcapName)
-		
+
 		result, _ := vm.ToValue(isRetained)
 		return result
 	})
@@ -63,9 +95,10 @@ func registerPcapHelperBindings(vm *otto.Otto, pcapHelper *PcapHelper) error {
 
 	// Register getPcapPath function
 	err = pcapObj.Set("getPcapPath", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("pcap.getPcapPath")
 		streamID := call.Argument(0).String()
 		path := pcapHelper.GetPcapPath(streamID)
-		
+
 		result, _ := vm.ToValue(path)
 		return result
 	})
@@ -73,22 +106,49 @@ func registerPcapHelperBindings(vm *otto.Otto, pcapHelper *PcapHelper) error {
 		return err
 	}
 
-	return nil
-}
+	// Register read function
+	err = pcapObj.Set("read", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("pcap.read")
+		path := call.Argument(0).String()
+		reader, err := pcapHelper.OpenReader(path, int(optionInt(call.Argument(1), "payload")))
+		if err != nil {
+			throwError(call, "Error", err)
+		}
 
-// registerConsoleBindings registers console.log and similar functions
-func registerConsoleBindings(vm *otto.Otto) error {
-	console, err := vm.Object("console = {}")
+		return newPacketIterator(call, pcapHelper, reader)
+	})
 	if err != nil {
 		return err
 	}
 
-	err = console.Set("log", func(call otto.FunctionCall) otto.Value {
-		for _, arg := range call.ArgumentList {
-			fmt.Print(arg.String() + " ")
+	// Register snapshot function
+	err = pcapObj.Set("snapshot", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("pcap.snapshot")
+		meter.retention()
+		name := call.Argument(0).String()
+		options := call.Argument(1)
+
+		opts := worker.SnapshotOptions{
+			Streams: optionStrings(call, options, "streams"),
+			From:    optionTime(call, options, "from"),
+			To:      optionTime(call, options, "to"),
+			BPF:     optionString(options, "bpf"),
+			Retain:  time.Duration(optionInt(options, "retain")) * time.Second,
+			Owner:   worker.RetentionOwner{Namespace: optionString(options, "namespace")},
 		}
-		fmt.Println()
-		return otto.UndefinedValue()
+
+		result, err := pcapHelper.Snapshot(name, opts)
+		if err != nil {
+			if errors.Is(err, worker.ErrRetentionQuotaExceeded) {
+				throwError(call, "RetentionQuotaError", err)
+			}
+			throwError(call, "Error", err)
+		}
+
+		return toJSValue(call, map[string]interface{}{
+			"path":    result.Path,
+			"packets": result.Packets,
+		})
 	})
 	if err != nil {
 		return err
@@ -97,167 +157,256 @@ func registerConsoleBindings(vm *otto.Otto) error {
 	return nil
 }
 
-// registerUtilBindings registers utility functions like sleep
-func registerUtilBindings(vm *otto.Otto) error {
-	// Register global sleep function
-	err := vm.Set("sleep", func(call otto.FunctionCall) otto.Value {
-		milliseconds, _ := call.Argument(0).ToInteger()
-		time.Sleep(time.Duration(milliseconds) * time.Millisecond)
-		return otto.UndefinedValue()
-	})
+// newPacketIterator returns the iterator object of pcap.read. next() returns the next packet
+// or null at the end, forEach(fn) calls fn for each packet until it returns false, and
+// close() stops the iteration early. The reader is closed once the iteration ends.
+func newPacketIterator(call otto.FunctionCall, pcapHelper *PcapHelper, reader *worker.PcapReader) otto.Value {
+	iterator, err := call.Otto.Object("({})")
 	if err != nil {
-		return err
+		pcapHelper.CloseReader(reader)
+		throwError(call, "Error", err)
 	}
 
-	return nil
-}
-import (
-	"github.com/robertkrimen/otto"
-	"github.com/rs/zerolog/log"
-)
-
-// RegisterPcapBindings registers PCAP-related functions to the JavaScript VM
-func RegisterPcapBindings(vm *otto.Otto, pcapHelper *PcapHelper) {
-	pcapObj, _ := vm.Object("({})")
-	
-	// Register the retain function
-	_ = pcapObj.Set("retain", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) < 2 {
-			log.Error().Msg("pcap.retain requires two arguments: streamID and duration in seconds")
-			return otto.UndefinedValue()
-		}
-		
-		streamID, err := call.Argument(0).ToString()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse streamID argument")
-			return otto.UndefinedValue()
-		}
-		
-		duration, err := call.Argument(1).ToInteger()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse duration argument")
-			return otto.UndefinedValue()
+	done := false
+	next := func(call otto.FunctionCall) otto.Value {
+		if done {
+			return otto.NullValue()
 		}
-		
-		pcapHelper.RetainPcap(streamID, int(duration))
-		
-		result, _ := otto.ToValue(true)
-		return result
-	})
-	
-	// Register the isRetained function
-	_ = pcapObj.Set("isRetained", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) < 1 {
-			log.Error().Msg("pcap.isRetained requires one argument: streamID")
-			return otto.UndefinedValue()
-		}
-		
-		streamID, err := call.Argument(0).ToString()
+
+		summary, err := reader.Next()
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse streamID argument")
-			return otto.UndefinedValue()
+			done = true
+			pcapHelper.CloseReader(reader)
+			if errors.Is(err, io.EOF) {
+				return otto.NullValue()
+			}
+			throwError(call, "Error", err)
 		}
-		
-		isRetained := pcapHelper.IsRetained(streamID)
-		
-		result, _ := otto.ToValue(isRetained)
-		return result
+
+		return packetObject(call, summary)
+	}
+
+	_ = iterator.Set("next", next)
+	_ = iterator.Set("close", func(call otto.FunctionCall) otto.Value {
+		done = true
+		pcapHelper.CloseReader(reader)
+		return otto.UndefinedValue()
 	})
-	
-	// Register the path function
-	_ = pcapObj.Set("path", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) < 1 {
-			log.Error().Msg("pcap.path requires one argument: streamID")
-			return otto.UndefinedValue()
+	_ = iterator.Set("forEach", func(call otto.FunctionCall) otto.Value {
+		callback := call.Argument(0)
+		if !callback.IsFunction() {
+			throwError(call, "TypeError", errors.New("forEach requires a function"))
 		}
-		
-		streamID, err := call.Argument(0).ToString()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse streamID argument")
-			return otto.UndefinedValue()
+
+		for {
+			packet := next(call)
+			if packet.IsNull() {
+				return otto.UndefinedValue()
+			}
+
+			result, err := callback.Call(otto.UndefinedValue(), packet)
+			if err != nil {
+				done = true
+				pcapHelper.CloseReader(reader)
+				throwError(call, "Error", err)
+			}
+			if result.IsBoolean() {
+				if proceed, _ := result.ToBoolean(); !proceed {
+					done = true
+					pcapHelper.CloseReader(reader)
+					return otto.UndefinedValue()
+				}
+			}
 		}
-		
-		path := pcapHelper.GetPcapPath(streamID)
-		
-		result, _ := otto.ToValue(path)
-		return result
 	})
-	
-	// Set the pcap object as a property of the global object
-	vm.Set("pcap", pcapObj)
+
+	return iterator.Value()
 }
-import (
-	"github.com/robertkrimen/otto"
-	"github.com/rs/zerolog/log"
-)
 
-// RegisterPcapBindings registers PCAP-related functions to the JavaScript VM
-func RegisterPcapBindings(vm *otto.Otto, pcapHelper *PcapHelper) {
-	pcapObj, _ := vm.Object("({})")
-	
-	// Register the retain function
-	_ = pcapObj.Set("retain", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) < 2 {
-			log.Error().Msg("pcap.retain requires two arguments: streamID and duration in seconds")
-			return otto.UndefinedValue()
-		}
-		
-		streamID, err := call.Argument(0).ToString()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse streamID argument")
-			return otto.UndefinedValue()
-		}
-		
-		duration, err := call.Argument(1).ToInteger()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse duration argument")
-			return otto.UndefinedValue()
-		}
-		
-		pcapHelper.RetainPcap(streamID, int(duration))
-		
-		result, _ := otto.ToValue(true)
-		return result
-	})
-	
-	// Register the isRetained function
-	_ = pcapObj.Set("isRetained", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) < 1 {
-			log.Error().Msg("pcap.isRetained requires one argument: streamID")
-			return otto.UndefinedValue()
+// packetObject converts a packet summary to a JavaScript object with a millisecond
+// timestamp and the payload, if requested, as an array of bytes
+func packetObject(call otto.FunctionCall, summary worker.PacketSummary) otto.Value {
+	flags := summary.Flags
+	if flags == nil {
+		flags = []string{}
+	}
+
+	packet := map[string]interface{}{
+		"timestamp":     summary.Timestamp.UnixMilli(),
+		"length":        summary.Length,
+		"captureLength": summary.CaptureLength,
+		"network":       summary.Network,
+		"transport":     summary.Transport,
+		"srcIp":         summary.SrcIP,
+		"dstIp":         summary.DstIP,
+		"srcPort":       summary.SrcPort,
+		"dstPort":       summary.DstPort,
+		"flags":         flags,
+		"payloadLength": summary.PayloadLength,
+	}
+	if summary.Payload != nil {
+		payload := make([]int, len(summary.Payload))
+		for i, b := range summary.Payload {
+			payload[i] = int(b)
 		}
-		
-		streamID, err := call.Argument(0).ToString()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse streamID argument")
-			return otto.UndefinedValue()
+		packet["payload"] = payload
+	}
+
+	return toJSValue(call, packet)
+}
+
+// optionString reads a string property from an optional options object argument
+func optionString(options otto.Value, key string) string {
+	if !options.IsObject() {
+		return ""
+	}
+
+	value, err := options.Object().Get(key)
+	if err != nil || !value.IsDefined() {
+		return ""
+	}
+
+	return value.String()
+}
+
+// optionInt reads an integer property from an optional options object argument
+func optionInt(options otto.Value, key string) int64 {
+	if !options.IsObject() {
+		return 0
+	}
+
+	value, err := options.Object().Get(key)
+	if err != nil || !value.IsDefined() {
+		return 0
+	}
+
+	i, _ := value.ToInteger()
+	return i
+}
+
+// optionStrings reads a string or an array of strings from an optional options object argument
+func optionStrings(call otto.FunctionCall, options otto.Value, key string) []string {
+	if !options.IsObject() {
+		return nil
+	}
+
+	value, err := options.Object().Get(key)
+	if err != nil || !value.IsDefined() || value.IsNull() {
+		return nil
+	}
+	if value.IsString() {
+		return []string{value.String()}
+	}
+
+	exported, err := value.Export()
+	if err != nil {
+		throwError(call, "TypeError", fmt.Errorf("%s must be a string or an array of strings", key))
+	}
+
+	var values []string
+	switch v := exported.(type) {
+	case []string:
+		values = v
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
 		}
-		
-		isRetained := pcapHelper.IsRetained(streamID)
-		
-		result, _ := otto.ToValue(isRetained)
-		return result
-	})
-	
-	// Register the path function
-	_ = pcapObj.Set("path", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) < 1 {
-			log.Error().Msg("pcap.path requires one argument: streamID")
-			return otto.UndefinedValue()
+	default:
+		throwError(call, "TypeError", fmt.Errorf("%s must be a string or an array of strings", key))
+	}
+
+	return values
+}
+
+// optionTime reads a time property, given as milliseconds since the epoch, an RFC 3339
+// string or a Date, from an optional options object argument
+func optionTime(call otto.FunctionCall, options otto.Value, key string) time.Time {
+	if !options.IsObject() {
+		return time.Time{}
+	}
+
+	value, err := options.Object().Get(key)
+	if err != nil || !value.IsDefined() || value.IsNull() {
+		return time.Time{}
+	}
+
+	if value.IsObject() && value.Class() == "Date" {
+		value, _ = value.Object().Call("getTime")
+	}
+	if value.IsNumber() {
+		ms, _ := value.ToInteger()
+		return time.UnixMilli(ms)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value.String())
+	if err != nil {
+		throwError(call, "TypeError", fmt.Errorf("%s must be milliseconds since the epoch or an RFC 3339 time: %w", key, err))
+	}
+
+	return t
+}
+
+// toJSValue converts a Go value to a plain JavaScript object using its JSON representation
+func toJSValue(call otto.FunctionCall, v interface{}) otto.Value {
+	result, err := jsValue(call.Otto, v)
+	if err != nil {
+		throwError(call, "Error", err)
+	}
+
+	return result
+}
+
+// jsValue converts a Go value to a plain JavaScript value in vm using its JSON representation
+func jsValue(vm *otto.Otto, v interface{}) (otto.Value, error) {
+	if value, ok := v.(otto.Value); ok {
+		return value, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+
+	return vm.Call("JSON.parse", nil, string(data))
+}
+
+// setFunctions creates the global object name holding functions
+func setFunctions(vm *otto.Otto, name string, functions map[string]func(call otto.FunctionCall) otto.Value) error {
+	object, err := vm.Object(name + " = {}")
+	if err != nil {
+		return err
+	}
+
+	for key, function := range functions {
+		if err := object.Set(key, function); err != nil {
+			return err
 		}
-		
-		streamID, err := call.Argument(0).ToString()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse streamID argument")
-			return otto.UndefinedValue()
+	}
+
+	return nil
+}
+
+// throwError raises err as a JavaScript exception of the given name in the calling script
+func throwError(call otto.FunctionCall, name string, err error) {
+	panic(call.Otto.MakeCustomError(name, err.Error()))
+}
+
+// registerUtilBindings registers utility functions like sleep
+func registerUtilBindings(vm *otto.Otto, meter *budgetMeter) error {
+	// Register global sleep function, which wakes up at the execution's deadline at the latest
+	err := vm.Set("sleep", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("sleep")
+		milliseconds, _ := call.Argument(0).ToInteger()
+		duration := time.Duration(milliseconds) * time.Millisecond
+		if deadline := meter.until(); !deadline.IsZero() && time.Until(deadline) < duration {
+			duration = time.Until(deadline)
 		}
-		
-		path := pcapHelper.GetPcapPath(streamID)
-		
-		result, _ := otto.ToValue(path)
-		return result
+		time.Sleep(duration)
+		return otto.UndefinedValue()
 	})
-	
-	// Set the pcap object as a property of the global object
-	vm.Set("pcap", pcapObj)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,169 @@
+package scripting
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOperationBudgetExceeded is returned when a script evaluates more operations than its budget allows
+	ErrOperationBudgetExceeded = errors.New("script operation budget exceeded")
+	// ErrMemoryBudgetExceeded is returned when a script allocates more memory than its budget allows
+	ErrMemoryBudgetExceeded = errors.New("script memory budget exceeded")
+	// ErrOutputBudgetExceeded is returned when a script writes more console output than its budget allows
+	ErrOutputBudgetExceeded = errors.New("script output budget exceeded")
+	// ErrBindingCallBudgetExceeded is returned when a script calls the bindings more often than its budget allows
+	ErrBindingCallBudgetExceeded = errors.New("script binding call budget exceeded")
+	// ErrRetentionBudgetExceeded is returned when a script requests more retentions than its budget allows
+	ErrRetentionBudgetExceeded = errors.New("script retention budget exceeded")
+)
+
+// ScriptBudget limits what a single script execution may consume. A zero limit is unlimited.
+// Operations are the statements and expressions the VM evaluates. Memory is the approximate
+// size, in bytes, of the objects, arrays and functions the script creates, see instrumentAllocations.
+type ScriptBudget struct {
+	MaxOperations   int64
+	MaxMemory       int64
+	MaxOutput       int64
+	MaxBindingCalls int64
+	MaxRetentions   int64
+}
+
+// budgetViolation is panicked inside the VM to stop a script that exceeded its budget.
+// A try block can catch it, but then the script is stopped again, see check.
+type budgetViolation struct {
+	err error
+}
+
+// budgetMeter accounts the consumption of the current execution against a budget
+type budgetMeter struct {
+	budget       ScriptBudget
+	operations   int64
+	memory       int64
+	output       int64
+	bindingCalls int64
+	retentions   int64
+	violation    error
+	deadline     time.Time
+	mu           sync.Mutex
+}
+
+// start resets the meter for a new execution that must end by deadline
+func (m *budgetMeter) start(deadline time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operations, m.memory, m.output, m.bindingCalls, m.retentions = 0, 0, 0, 0, 0
+	m.violation = nil
+	m.deadline = deadline
+}
+
+// until returns the deadline of the current execution, zero when it has none. Blocking
+// bindings return by then, so the script can be interrupted.
+func (m *budgetMeter) until() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deadline
+}
+
+// limits returns the limits the meter accounts against
+func (m *budgetMeter) limits() ScriptBudget {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.budget
+}
+
+// metersOperations reports whether the VM must count operations for this budget
+func (m *budgetMeter) metersOperations() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.budget.MaxOperations > 0
+}
+
+// operation accounts an evaluated operation
+func (m *budgetMeter) operation() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operations++
+	if m.budget.MaxOperations > 0 && m.operations > m.budget.MaxOperations {
+		m.fail(fmt.Errorf("%w: the limit is %d operations", ErrOperationBudgetExceeded, m.budget.MaxOperations))
+	}
+	m.check()
+}
+
+// allocate accounts size bytes of allocated memory
+func (m *budgetMeter) allocate(size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.memory += size
+	if m.budget.MaxMemory > 0 && m.memory > m.budget.MaxMemory {
+		m.fail(fmt.Errorf("%w: the script allocated about %d bytes, the limit is %d", ErrMemoryBudgetExceeded, m.memory, m.budget.MaxMemory))
+	}
+	m.check()
+}
+
+// writeOutput accounts n bytes of console output
+func (m *budgetMeter) writeOutput(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.output += int64(n)
+	if m.budget.MaxOutput > 0 && m.output > m.budget.MaxOutput {
+		m.fail(fmt.Errorf("%w: the limit is %d bytes", ErrOutputBudgetExceeded, m.budget.MaxOutput))
+	}
+	m.check()
+}
+
+// bindingCall accounts a call to the named binding
+func (m *budgetMeter) bindingCall(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bindingCalls++
+	if m.budget.MaxBindingCalls > 0 && m.bindingCalls > m.budget.MaxBindingCalls {
+		m.fail(fmt.Errorf("%w: %s is call %d, the limit is %d", ErrBindingCallBudgetExceeded, name, m.bindingCalls, m.budget.MaxBindingCalls))
+	}
+	m.check()
+}
+
+// retention accounts a retention request
+func (m *budgetMeter) retention() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.retentions++
+	if m.budget.MaxRetentions > 0 && m.retentions > m.budget.MaxRetentions {
+		m.fail(fmt.Errorf("%w: the limit is %d retentions", ErrRetentionBudgetExceeded, m.budget.MaxRetentions))
+	}
+	m.check()
+}
+
+// fail records the first violation of the execution
+func (m *budgetMeter) fail(err error) {
+	if m.violation == nil {
+		m.violation = err
+	}
+}
+
+// violated returns the violation of the current execution, if any
+func (m *budgetMeter) violated() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.violation
+}
+
+// check stops the script if it violated its budget. The violation is sticky: a script
+// that catches it is stopped again at its next operation or binding call.
+func (m *budgetMeter) check() {
+	if m.violation != nil {
+		panic(budgetViolation{m.violation})
+	}
+}
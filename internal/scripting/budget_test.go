@@ -0,0 +1,46 @@
+package scripting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestScriptBudgets(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	engine, err := NewScriptEngine(NewPcapHelper(manager), 5000)
+	if err != nil {
+		t.Fatalf("Failed to create script engine: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		budget ScriptBudget
+		script string
+		err    error
+	}{
+		{"operations", ScriptBudget{MaxOperations: 1000}, "for (var i = 0; i < 100000; i++) {}", ErrOperationBudgetExceeded},
+		{"memory", ScriptBudget{MaxMemory: 10000}, "var all = []; for (;;) { all.push({a: 1, b: [1, 2]}) }", ErrMemoryBudgetExceeded},
+		{"constructors", ScriptBudget{MaxMemory: 10000}, "for (;;) { new Date(); (function() {}) }", ErrMemoryBudgetExceeded},
+		{"output", ScriptBudget{MaxOutput: 64}, "for (var i = 0; i < 100; i++) { console.log('line', i) }", ErrOutputBudgetExceeded},
+		{"binding calls", ScriptBudget{MaxBindingCalls: 5}, "for (var i = 0; i < 10; i++) { pcap.isRetained('a.pcap') }", ErrBindingCallBudgetExceeded},
+		{"retentions", ScriptBudget{MaxRetentions: 2}, "for (var i = 0; i < 3; i++) { pcap.retain('s' + i + '.pcap', 60) }", ErrRetentionBudgetExceeded},
+		{"caught", ScriptBudget{MaxOperations: 1000}, "while (true) { try { for (;;) {} } catch (e) {} }", ErrOperationBudgetExceeded},
+	}
+	for _, test := range tests {
+		engine.SetBudget(test.budget)
+		if _, err := engine.Execute(test.script); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+
+	// Budgets are per execution
+	engine.SetBudget(ScriptBudget{MaxBindingCalls: 5})
+	for i := 0; i < 3; i++ {
+		if _, err := engine.Execute("pcap.isRetained('a.pcap'); pcap.isRetained('b.pcap')"); err != nil {
+			t.Errorf("Execution %d within budget failed: %v", i, err)
+		}
+	}
+}
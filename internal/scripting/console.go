@@ -0,0 +1,180 @@
+package scripting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// LogLevel is the level of a console record
+type LogLevel string
+
+const (
+	LogDebug LogLevel = "debug"
+	LogInfo  LogLevel = "info"
+	LogWarn  LogLevel = "warn"
+	LogError LogLevel = "error"
+)
+
+// LogLevels lists the levels from the least to the most severe
+var LogLevels = []LogLevel{LogDebug, LogInfo, LogWarn, LogError}
+
+// ParseLogLevel parses a level name, case insensitively
+func ParseLogLevel(name string) (LogLevel, error) {
+	for _, level := range LogLevels {
+		if strings.EqualFold(name, string(level)) {
+			return level, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown log level %q, expected one of debug, info, warn and error", name)
+}
+
+// AtLeast reports whether the level is as severe as min or more
+func (l LogLevel) AtLeast(min LogLevel) bool {
+	return l.severity() >= min.severity()
+}
+
+// severity returns the position of the level in LogLevels, -1 for an unknown level
+func (l LogLevel) severity() int {
+	for i, level := range LogLevels {
+		if l == level {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// consoleFunctions are the functions of the console object and the level they write at
+var consoleFunctions = map[string]LogLevel{
+	"log":   LogInfo,
+	"debug": LogDebug,
+	"info":  LogInfo,
+	"warn":  LogWarn,
+	"error": LogError,
+}
+
+// consoleTakesFields reports whether a console function takes a trailing object as fields.
+// console.log doesn't, it prints the object in the message as it did before records.
+func consoleTakesFields(name string) bool {
+	return name != "log"
+}
+
+// ConsoleRecord is a line a script writes to its console. Records are written as JSON lines.
+type ConsoleRecord struct {
+	Script  string                 `json:"script"`
+	Level   LogLevel               `json:"level"`
+	Time    time.Time              `json:"time"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ParseConsoleRecord parses a JSON line written by a script's console. Lines that are not
+// records, such as the output of older workers, are reported as such.
+func ParseConsoleRecord(line string) (ConsoleRecord, bool) {
+	var record ConsoleRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil || record.Level.severity() < 0 {
+		return ConsoleRecord{}, false
+	}
+
+	return record, true
+}
+
+// Text formats the record without its script and time, as the level, the message and the
+// fields sorted by name
+func (r ConsoleRecord) Text() string {
+	text := strings.ToUpper(string(r.Level)) + " " + r.Message
+	if fields := r.FieldsText(); fields != "" {
+		text += " " + fields
+	}
+
+	return text
+}
+
+// FieldsText formats the fields of the record as name=value, sorted by name and separated by spaces
+func (r ConsoleRecord) FieldsText() string {
+	names := make([]string, 0, len(r.Fields))
+	for name := range r.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value, _ := json.Marshal(r.Fields[name])
+		parts = append(parts, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// consoleRecord builds the record of a console call. With withFields, when more than one
+// argument is given and the last is a plain object, it holds the fields. The other arguments
+// make up the message, objects in their JSON form.
+func consoleRecord(call otto.FunctionCall, script string, level LogLevel, withFields bool, now time.Time) ConsoleRecord {
+	record := ConsoleRecord{Script: script, Level: level, Time: now}
+
+	args := call.ArgumentList
+	if withFields && len(args) > 1 && args[len(args)-1].Class() == "Object" {
+		if fields, err := args[len(args)-1].Export(); err == nil {
+			record.Fields, _ = fields.(map[string]interface{})
+		}
+		if record.Fields != nil {
+			args = args[:len(args)-1]
+		}
+	}
+
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		parts = append(parts, consoleString(call, arg))
+	}
+	record.Message = strings.Join(parts, " ")
+
+	return record
+}
+
+// consoleString formats an argument of a console call, objects and arrays in their JSON form
+func consoleString(call otto.FunctionCall, value otto.Value) string {
+	if class := value.Class(); class == "Object" || class == "Array" {
+		if data, err := call.Otto.Call("JSON.stringify", nil, value); err == nil && data.IsString() {
+			return data.String()
+		}
+	}
+
+	return value.String()
+}
+
+// registerConsoleBindings registers console.debug, info, warn and error, which write the
+// records of script to out, and console.log, which writes at the info level
+func registerConsoleBindings(vm *otto.Otto, script string, out io.Writer, meter *budgetMeter) error {
+	console, err := vm.Object("console = {}")
+	if err != nil {
+		return err
+	}
+
+	for name, level := range consoleFunctions {
+		level, withFields := level, consoleTakesFields(name)
+		err := console.Set(name, func(call otto.FunctionCall) otto.Value {
+			data, err := json.Marshal(consoleRecord(call, script, level, withFields, time.Now()))
+			if err != nil {
+				throwError(call, "TypeError", err)
+			}
+			data = append(data, '\n')
+
+			meter.writeOutput(len(data))
+			out.Write(data)
+			return otto.UndefinedValue()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package scripting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConsoleRecords(t *testing.T) {
+	var out bytes.Buffer
+	vm := newVM()
+	if err := registerConsoleBindings(vm, "errors", &out, &budgetMeter{}); err != nil {
+		t.Fatalf("Failed to register console bindings: %v", err)
+	}
+
+	script := `
+		console.log("plain", 1, [2, 3]);
+		console.log("request", {path: "/a"});
+		console.warn("slow response", {path: "/a", ms: 1500});
+		console.error({status: 500});
+	`
+	if _, err := vm.Run(script); err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 records, got %q", out.String())
+	}
+
+	expected := []struct {
+		level LogLevel
+		text  string
+	}{
+		{LogInfo, "INFO plain 1 [2,3]"},
+		{LogInfo, `INFO request {"path":"/a"}`},
+		{LogWarn, `WARN slow response ms=1500 path="/a"`},
+		{LogError, `ERROR {"status":500}`},
+	}
+	for i, line := range lines {
+		record, ok := ParseConsoleRecord(line)
+		if !ok {
+			t.Errorf("Expected a record, got %q", line)
+			continue
+		}
+		if record.Script != "errors" || record.Level != expected[i].level || record.Time.IsZero() {
+			t.Errorf("Expected an %s record of the script, got %+v", expected[i].level, record)
+		}
+		if text := record.Text(); text != expected[i].text {
+			t.Errorf("Expected %q, got %q", expected[i].text, text)
+		}
+	}
+
+	if _, ok := ParseConsoleRecord("[errors:ERROR] legacy line"); ok {
+		t.Errorf("Expected a plain line not to parse as a record")
+	}
+	if level, err := ParseLogLevel("WARN"); err != nil || !LogError.AtLeast(level) || LogInfo.AtLeast(level) {
+		t.Errorf("Expected warn to sit between info and error, got %v, %v", level, err)
+	}
+	if _, err := ParseLogLevel("fatal"); err == nil {
+		t.Errorf("Expected an unknown level to be rejected")
+	}
+}
@@ -0,0 +1,183 @@
+package scripting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronDescriptors are the shorthands accepted in place of the five cron fields
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField is the allowed range of a cron field
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// cronSchedule is a parsed cron expression: minute, hour, day of month, month and day of
+// week, each a set of values. Either an "@every <duration>" interval or fields are set.
+type cronSchedule struct {
+	every time.Duration
+	// fields holds a bit per allowed value of each field
+	fields [5]uint64
+	// A day of month or day of week of * leaves the other one to decide alone
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// parseCron parses a five field cron expression, a descriptor such as "@hourly", or an
+// interval such as "@every 5m"
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if interval, ok := strings.CutPrefix(expr, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in %q: %w", expr, err)
+		}
+		if every < time.Second {
+			return nil, fmt.Errorf("interval in %q is shorter than a second", expr)
+		}
+		return &cronSchedule{every: every}, nil
+	}
+	if descriptor, ok := cronDescriptors[expr]; ok {
+		expr = descriptor
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields, got %d", expr, len(cronFields), len(parts))
+	}
+
+	schedule := &cronSchedule{
+		anyDayOfMonth: parts[2] == "*",
+		anyDayOfWeek:  parts[4] == "*",
+	}
+	for i, part := range parts {
+		bits, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+		schedule.fields[i] = bits
+	}
+	// Sunday can be written as 7
+	if schedule.fields[4]&(1<<7) != 0 {
+		schedule.fields[4] |= 1
+	}
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of *, values, ranges and steps
+func parseCronField(part string, field cronField) (uint64, error) {
+	max := field.max
+	if field.name == "day of week" {
+		max = 7
+	}
+
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in the %s field", stepPart, field.name)
+			}
+		}
+
+		low, high := field.min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return 0, fmt.Errorf("invalid value %q in the %s field", lowPart, field.name)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highPart); err != nil {
+					return 0, fmt.Errorf("invalid value %q in the %s field", highPart, field.name)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < field.min || high > max || low > high {
+			return 0, fmt.Errorf("%s is out of range %d-%d in the %s field", rangePart, field.min, max, field.name)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// next returns the first activation strictly after t
+func (s *cronSchedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every combination repeats within a few years, give up after that
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.matches(3, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matches(1, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matches(0, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matches reports whether a value is set in a field
+func (s *cronSchedule) matches(field int, value int) bool {
+	return s.fields[field]&(1<<uint(value)) != 0
+}
+
+// matchesDay applies the cron rule that a restricted day of month and day of week match
+// when either does
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.matches(2, t.Day())
+	dayOfWeek := s.matches(4, int(t.Weekday()))
+	switch {
+	case s.anyDayOfMonth:
+		return dayOfWeek
+	case s.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
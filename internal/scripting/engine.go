@@ -1,10 +1,14 @@
 package scripting
 
 import (
+	"errors"
 	"fmt"
+	"runtime/debug"
 	"time"
 
 	"github.com/robertkrimen/otto"
+	"github.com/robertkrimen/otto/ast"
+	"github.com/rs/zerolog/log"
 )
 
 // ScriptEngine handles JavaScript execution in a VM
@@ -12,194 +16,357 @@ type ScriptEngine struct {
 	vm         *otto.Otto
 	pcapHelper *PcapHelper
 	timeout    time.Duration
+	meter      *budgetMeter
+	fs         *FileSandbox
+	http       *HTTPClient
+	store      *ScriptStore
+	libraries  map[string]string
+	scheduler  *JobScheduler
+	jobs       *jobDefinitions
+	// retired is set when a script is still running past its timeout, or crashed the VM, the engine
+	// can't be used again
+	retired bool
+	// register replaces the bindings, the test runner uses it to mock them
+	register func(vm *otto.Otto, meter *budgetMeter) error
 }
 
 // NewScriptEngine creates a new script engine with the given timeout
 func NewScriptEngine(pcapHelper *PcapHelper, timeoutMs int) (*ScriptEngine, error) {
-	vm := otto.New()
-	
+	pcapHelper = pcapHelper.forEngine()
+	vm := newVM()
+
 	engine := &ScriptEngine{
 		vm:         vm,
 		pcapHelper: pcapHelper,
 		timeout:    time.Duration(timeoutMs) * time.Millisecond,
+		meter:      &budgetMeter{},
 	}
-	
+
 	// Register bindings
-	if err := RegisterBindings(vm, pcapHelper); err != nil {
+	if err := engine.registerBindings(pcapHelper); err != nil {
 		return nil, fmt.Errorf("failed to register bindings: %w", err)
 	}
-	
+
 	return engine, nil
 }
 
-// Execute runs a JavaScript script with timeout protection
-func (e *ScriptEngine) Execute(script string) (string, error) {
-	// Set up timeout protection
-	done := make(chan struct{})
-	var result otto.Value
-	var err error
+// newVM creates a VM that can be interrupted. The interrupt channel holds both the
+// operation counter and the timeout, see run.
+func newVM() *otto.Otto {
+	vm := otto.New()
+	vm.Interrupt = make(chan func(), 2)
+	return vm
+}
 
-	go func() {
-		result, err = e.vm.Run(script)
-		close(done)
-	}()
+// SetBudget limits what each execution of the engine may consume
+func (e *ScriptEngine) SetBudget(budget ScriptBudget) {
+	e.meter.mu.Lock()
+	defer e.meter.mu.Unlock()
 
-	select {
-	case <-done:
-		if err != nil {
-			return "", err
-		}
-		if result.IsUndefined() || result.IsNull() {
-			return "", nil
+	e.meter.budget = budget
+}
+
+// SetFileSystem gives the engine's scripts the fs binding, confined to sandbox.
+// A nil sandbox removes the binding.
+func (e *ScriptEngine) SetFileSystem(sandbox *FileSandbox) error {
+	e.fs = sandbox
+	return e.Reset()
+}
+
+// SetNetwork gives the engine's scripts the http binding, limited to the client's allowlist.
+// A nil client removes the binding.
+func (e *ScriptEngine) SetNetwork(client *HTTPClient) error {
+	e.http = client
+	return e.Reset()
+}
+
+// SetStore gives the engine's scripts the store binding, each in its own namespace of store.
+// A nil store removes the binding.
+func (e *ScriptEngine) SetStore(store *ScriptStore) error {
+	e.store = store
+	return e.Reset()
+}
+
+// SetLibraries sets the modules the engine's scripts can require, by name
+func (e *ScriptEngine) SetLibraries(libraries map[string]string) error {
+	e.libraries = libraries
+	return e.Reset()
+}
+
+// SetScheduler makes the jobs the engine's scripts schedule listable and cancellable
+func (e *ScriptEngine) SetScheduler(scheduler *JobScheduler) error {
+	e.scheduler = scheduler
+	return e.Reset()
+}
+
+// registerBindings registers the bindings on the engine's VM on behalf of pcapHelper's script
+func (e *ScriptEngine) registerBindings(pcapHelper *PcapHelper) error {
+	// Jobs scheduled by the previous script are forgotten with its VM
+	e.jobs = newJobDefinitions()
+	if err := registerJobBindings(e.vm, e.jobs, e.scheduler, pcapHelper.script, e.meter); err != nil {
+		return fmt.Errorf("failed to register job bindings: %w", err)
+	}
+	// Modules are cached per VM, so the previous script's are forgotten too
+	if err := registerRequire(e.vm, e.libraries, e.meter); err != nil {
+		return err
+	}
+	if err := registerAllocHook(e.vm, e.meter); err != nil {
+		return fmt.Errorf("failed to register the allocation hook: %w", err)
+	}
+
+	if e.register != nil {
+		return e.register(e.vm, e.meter)
+	}
+
+	if err := RegisterBindings(e.vm, pcapHelper, e.meter); err != nil {
+		return err
+	}
+
+	// The fs binding only exists when the file system is allowed
+	if e.fs != nil {
+		if err := registerFsBindings(e.vm, e.fs, pcapHelper, e.meter); err != nil {
+			return fmt.Errorf("failed to register file system bindings: %w", err)
 		}
-		return result.ToString()
-	case <-time.After(e.timeout):
-		e.vm.Interrupt <- func() {
-			panic("Script execution timed out")
+	}
+
+	// The http binding only exists when the network is allowed
+	if e.http != nil {
+		if err := registerHTTPBindings(e.vm, e.http, e.meter.until, e.meter); err != nil {
+			return fmt.Errorf("failed to register HTTP bindings: %w", err)
 		}
-		return "", fmt.Errorf("script execution timed out after %v", e.timeout)
 	}
-}
 
-// Reset clears the VM state
-func (e *ScriptEngine) Reset() error {
-	// Create new VM
-	e.vm = otto.New()
-	
-	// Re-register bindings
-	if err := RegisterBindings(e.vm, e.pcapHelper); err != nil {
-		return fmt.Errorf("failed to re-register bindings: %w", err)
+	// The store binding only exists when a store is set
+	if e.store != nil {
+		if err := registerStoreBindings(e.vm, e.store, pcapHelper.script, e.meter); err != nil {
+			return fmt.Errorf("failed to register store bindings: %w", err)
+		}
 	}
-	
+
 	return nil
 }
-import (
-	"fmt"
-	"time"
 
-	"github.com/kubeshark/kubeshark/internal/worker"
-	"github.com/robertkrimen/otto"
-	"github.com/rs/zerolog/log"
-)
+// errScriptTimeout is raised inside the VM to stop a script that ran past its timeout
+var errScriptTimeout = errors.New("script execution timed out")
+
+// errEngineRetired is returned when a script is run on an engine that was retired
+var errEngineRetired = errors.New("the script engine was retired after a timeout or a crash")
 
-// ScriptingEngine manages the JavaScript engine and script execution
-type ScriptingEngine struct {
-	vm          *otto.Otto
-	pcapHelper  *PcapHelper
-	timeout     time.Duration
-	isInitialized bool
+// retireGrace is how long run waits for a timed out script to stop before retiring its engine
+const retireGrace = 10 * time.Millisecond
+
+// Retired reports whether a script of the engine was still running at its timeout, or crashed
+// the VM. Such an engine is left as is, and must be replaced, see replacement.
+func (e *ScriptEngine) Retired() bool {
+	return e.retired
 }
 
-// NewScriptingEngine creates a new scripting engine
-func NewScriptingEngine(pcapManager *worker.PcapManager, timeoutMs int) *ScriptingEngine {
-	pcapHelper := NewPcapHelper(pcapManager)
-	
-	return &ScriptingEngine{
-		pcapHelper: pcapHelper,
-		timeout:    time.Duration(timeoutMs) * time.Millisecond,
+// replacement creates an engine configured like e, to take the place of a retired engine
+func (e *ScriptEngine) replacement() (*ScriptEngine, error) {
+	engine := &ScriptEngine{
+		vm:         newVM(),
+		pcapHelper: e.pcapHelper.forEngine(),
+		timeout:    e.timeout,
+		meter:      &budgetMeter{budget: e.meter.limits()},
+		fs:         e.fs,
+		http:       e.http,
+		store:      e.store,
+		libraries:  e.libraries,
+		scheduler:  e.scheduler,
+		register:   e.register,
+	}
+	if err := engine.registerBindings(engine.pcapHelper); err != nil {
+		return nil, fmt.Errorf("failed to register bindings: %w", err)
+	}
+
+	return engine, nil
+}
+
+// Execute runs a JavaScript script with timeout protection
+func (e *ScriptEngine) Execute(script string) (string, error) {
+	program, err := parseScript("", script)
+	if err != nil {
+		return "", err
 	}
+
+	return e.runProgram(program)
 }
 
-// Initialize sets up the JavaScript VM with necessary bindings
-func (se *ScriptingEngine) Initialize() error {
-	if se.isInitialized {
-		return nil
+// runProgram runs a parsed script with timeout protection and returns its completion value
+func (e *ScriptEngine) runProgram(program *ast.Program) (string, error) {
+	result, err := e.run(func() (otto.Value, error) {
+		return e.vm.Run(program)
+	})
+	if errors.Is(err, errScriptTimeout) {
+		return "", fmt.Errorf("script execution timed out after %v", e.timeout)
 	}
-	
-	// Create a new JavaScript VM
-	se.vm = otto.New()
-	
-	// Register PCAP-related functions
-	RegisterPcapBindings(se.vm, se.pcapHelper)
-	
-	// Register other utility functions
-	err := se.registerUtilityFunctions()
 	if err != nil {
-		return fmt.Errorf("failed to register utility functions: %w", err)
+		return "", err
 	}
-	
-	se.isInitialized = true
-	return nil
+	if result.IsUndefined() || result.IsNull() {
+		return "", nil
+	}
+
+	return result.ToString()
 }
 
-// ExecuteScript runs a JavaScript script with timeout protection
-func (se *ScriptingEngine) ExecuteScript(script string) (otto.Value, error) {
-	if !se.isInitialized {
-		if err := se.Initialize(); err != nil {
-			return otto.UndefinedValue(), err
+// run calls fn, interrupting the VM once the timeout passes or the budget is exceeded.
+// The PCAP readers the script left open are closed when it ends. A script blocked in a
+// binding can't be interrupted, so run returns at the timeout anyway and retires the engine.
+// A panic of the VM or a binding fails the run and retires the engine, its state is unknown.
+func (e *ScriptEngine) run(fn func() (otto.Value, error)) (otto.Value, error) {
+	if e.retired {
+		return otto.UndefinedValue(), errEngineRetired
+	}
+	interrupt := e.vm.Interrupt
+
+	// Drop the interrupts left over by the previous run
+	for len(interrupt) > 0 {
+		<-interrupt
+	}
+
+	// The VM runs an interrupt before each operation, one that requeues itself counts them
+	e.meter.start(time.Now().Add(e.timeout))
+	if e.meter.metersOperations() {
+		var count func()
+		count = func() {
+			interrupt <- count
+			e.meter.operation()
 		}
+		interrupt <- count
 	}
-	
-	// Create a channel to signal script completion
-	done := make(chan struct{})
-	
-	// Default return value and error
-	var result otto.Value
-	var err error
-	
-	// Execute the script in a goroutine
+
+	type outcome struct {
+		value otto.Value
+		err   error
+		// crashed is set when fn panicked
+		crashed bool
+	}
+	done := make(chan outcome, 1)
+
 	go func() {
-		result, err = se.vm.Run(script)
-		close(done)
+		defer e.pcapHelper.CloseReaders()
+		defer func() {
+			if caught := recover(); caught != nil {
+				if violation, ok := caught.(budgetViolation); ok {
+					done <- outcome{err: violation.err}
+					return
+				}
+				if caught != errScriptTimeout {
+					log.Error().Interface("panic", caught).Bytes("stack", debug.Stack()).Msg("Script engine panicked")
+					done <- outcome{err: fmt.Errorf("script engine panicked: %v", caught), crashed: true}
+					return
+				}
+				done <- outcome{err: errScriptTimeout}
+			}
+		}()
+
+		value, err := fn()
+		done <- outcome{value: value, err: err}
 	}()
-	
-	// Wait for script completion or timeout
+
 	select {
-	case <-done:
-		return result, err
-	case <-time.After(se.timeout):
-		// Interrupt the VM to stop execution
-		se.vm.Interrupt <- func() {
-			panic("Script execution timed out")
+	case result := <-done:
+		if result.crashed {
+			e.retired = true
+			return otto.UndefinedValue(), result.err
 		}
-		
-		// Wait for the script to finish
-		<-done
-		
-		// Return a timeout error
-		return otto.UndefinedValue(), fmt.Errorf("script execution timed out after %v", se.timeout)
-	}
-}
-
-// registerUtilityFunctions adds utility functions to the JavaScript VM
-func (se *ScriptingEngine) registerUtilityFunctions() error {
-	// Add a sleep function
-	err := se.vm.Set("sleep", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) < 1 {
-			log.Error().Msg("sleep requires one argument: milliseconds")
-			return otto.UndefinedValue()
+		// A script that caught the violation is stopped by another error, report the violation
+		if err := e.meter.violated(); err != nil {
+			return otto.UndefinedValue(), err
 		}
-		
-		ms, err := call.Argument(0).ToInteger()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to parse milliseconds argument")
-			return otto.UndefinedValue()
+		return result.value, result.err
+	case <-time.After(e.timeout):
+		// Requeue the interrupt, so a script catching it is stopped again
+		var stop func()
+		stop = func() {
+			interrupt <- stop
+			panic(errScriptTimeout)
 		}
-		
-		time.Sleep(time.Duration(ms) * time.Millisecond)
-		
-		return otto.UndefinedValue()
-	})
-	
-	if err != nil {
-		return err
-	}
-	
-	// Add a log function
-	err = se.vm.Set("log", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) < 1 {
-			return otto.UndefinedValue()
+		interrupt <- stop
+
+		// Give the script a moment to reach the interrupt, otherwise leave the VM to it
+		select {
+		case result := <-done:
+			e.retired = result.crashed
+		case <-time.After(retireGrace):
+			e.retired = true
 		}
-		
-		message, err := call.Argument(0).ToString()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to convert log message to string")
-			return otto.UndefinedValue()
+		return otto.UndefinedValue(), errScriptTimeout
+	}
+}
+
+// CallHook calls the global function name with args, if the last executed script defined it.
+// The args are converted to plain JavaScript values. It reports whether the hook exists.
+func (e *ScriptEngine) CallHook(name string, args ...interface{}) (bool, error) {
+	hook, err := e.vm.Get(name)
+	if err != nil || !hook.IsFunction() {
+		return false, err
+	}
+
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if values[i], err = jsValue(e.vm, arg); err != nil {
+			return true, fmt.Errorf("failed to convert argument %d of hook %s: %w", i, name, err)
 		}
-		
-		log.Info().Str("script", "user").Msg(message)
-		return otto.UndefinedValue()
+	}
+
+	_, err = e.run(func() (otto.Value, error) {
+		return hook.Call(otto.UndefinedValue(), values...)
 	})
-	
+	if errors.Is(err, errScriptTimeout) {
+		return true, fmt.Errorf("hook %s timed out after %v", name, e.timeout)
+	}
+
+	return true, err
+}
+
+// Load runs a parsed script on behalf of the titled script, defining its hooks, and returns
+// its completion value. The PCAP retentions it makes are accounted to that script's quota.
+func (e *ScriptEngine) Load(title string, program *ast.Program) (string, error) {
+	if err := e.registerBindings(e.pcapHelper.WithScript(title)); err != nil {
+		return "", fmt.Errorf("failed to register bindings for script %q: %w", title, err)
+	}
+
+	return e.runProgram(program)
+}
+
+// ScheduledJobs returns the cron expressions of the jobs the last script scheduled, by name
+func (e *ScriptEngine) ScheduledJobs() map[string]string {
+	schedules := make(map[string]string, len(e.jobs.schedules))
+	for name, expr := range e.jobs.schedules {
+		schedules[name] = expr
+	}
+
+	return schedules
+}
+
+// RunJob calls the function of a job the last script scheduled
+func (e *ScriptEngine) RunJob(name string) error {
+	fn, ok := e.jobs.functions[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownJob, name)
+	}
+
+	_, err := e.run(func() (otto.Value, error) {
+		return fn.Call(otto.UndefinedValue())
+	})
+	if errors.Is(err, errScriptTimeout) {
+		return fmt.Errorf("job %s timed out after %v", name, e.timeout)
+	}
+
 	return err
 }
+
+// Reset clears the VM state
+func (e *ScriptEngine) Reset() error {
+	// Create new VM
+	e.vm = newVM()
+
+	// Re-register bindings
+	if err := e.registerBindings(e.pcapHelper); err != nil {
+		return fmt.Errorf("failed to re-register bindings: %w", err)
+	}
+
+	return nil
+}
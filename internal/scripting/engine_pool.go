@@ -0,0 +1,207 @@
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrQueueTimeout is returned when no script engine became available within the queue timeout
+var ErrQueueTimeout = errors.New("timed out waiting for a script engine")
+
+// EnginePool holds a fixed number of script engines with their bindings already registered.
+// Runs beyond the pool size are queued until an engine is released or the queue timeout passes.
+// The pool also creates engines configured like its own with Spawn.
+type EnginePool struct {
+	engines      chan *ScriptEngine
+	all          []*ScriptEngine
+	template     *ScriptEngine
+	queueTimeout time.Duration
+	mu           sync.Mutex
+}
+
+// NewEnginePool creates size engines with the given script timeout. A size of zero or less
+// creates a single engine, and a queue timeout of zero or less waits without a limit.
+func NewEnginePool(pcapHelper *PcapHelper, timeoutMs int, size int, queueTimeout time.Duration) (*EnginePool, error) {
+	if size <= 0 {
+		size = 1
+	}
+
+	template, err := NewScriptEngine(pcapHelper, timeoutMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create script engine: %w", err)
+	}
+
+	pool := &EnginePool{
+		engines:      make(chan *ScriptEngine, size),
+		template:     template,
+		queueTimeout: queueTimeout,
+	}
+	for i := 0; i < size; i++ {
+		engine, err := NewScriptEngine(pcapHelper, timeoutMs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create script engine: %w", err)
+		}
+		pool.engines <- engine
+		pool.all = append(pool.all, engine)
+	}
+
+	return pool, nil
+}
+
+// Size returns the number of engines in the pool
+func (p *EnginePool) Size() int {
+	return cap(p.engines)
+}
+
+// Acquire takes an engine from the pool, waiting until one is released, the queue timeout
+// passes or ctx is done. The engine must be given back with Release.
+func (p *EnginePool) Acquire(ctx context.Context) (*ScriptEngine, error) {
+	select {
+	case engine := <-p.engines:
+		return engine, nil
+	default:
+	}
+
+	if p.queueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.queueTimeout)
+		defer cancel()
+	}
+
+	select {
+	case engine := <-p.engines:
+		return engine, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %v", ErrQueueTimeout, p.queueTimeout)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Release resets an engine to a fresh VM and gives it back to the pool. A retired engine
+// is replaced by a new one with the same configuration.
+func (p *EnginePool) Release(engine *ScriptEngine) {
+	if engine.Retired() {
+		p.engines <- p.replace(engine)
+		return
+	}
+
+	if err := engine.Reset(); err != nil {
+		log.Error().Err(err).Msg("Failed to reset script engine")
+	}
+
+	p.engines <- engine
+}
+
+// Spawn creates an engine outside of the pool, configured like the pool's engines. The pool
+// doesn't reconfigure it later, the HookRegistry holding it does.
+func (p *EnginePool) Spawn() (*ScriptEngine, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.template.replacement()
+}
+
+// replace swaps a retired engine for a new one. If the new engine can't be created, the
+// retired one is kept, so the pool keeps its size and its runs fail.
+func (p *EnginePool) replace(retired *ScriptEngine) *ScriptEngine {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	engine, err := p.template.replacement()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to replace retired script engine")
+		return retired
+	}
+
+	for i := range p.all {
+		if p.all[i] == retired {
+			p.all[i] = engine
+		}
+	}
+	log.Warn().Msg("Replaced a script engine whose script ran past its timeout")
+
+	return engine
+}
+
+// SetBudget sets the budget of every engine in the pool
+func (p *EnginePool) SetBudget(budget ScriptBudget) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.template.SetBudget(budget)
+	for _, engine := range p.all {
+		engine.SetBudget(budget)
+	}
+}
+
+// SetFileSystem gives the scripts of every engine the fs binding, confined to sandbox.
+// It waits for the running scripts to finish, as the engines are reset.
+func (p *EnginePool) SetFileSystem(sandbox *FileSandbox) error {
+	return p.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetFileSystem(sandbox)
+	})
+}
+
+// SetNetwork gives the scripts of every engine the http binding, limited to the client's
+// allowlist. It waits for the running scripts to finish, as the engines are reset.
+func (p *EnginePool) SetNetwork(client *HTTPClient) error {
+	return p.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetNetwork(client)
+	})
+}
+
+// SetStore gives the scripts of every engine the store binding. It waits for the running
+// scripts to finish, as the engines are reset.
+func (p *EnginePool) SetStore(store *ScriptStore) error {
+	return p.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetStore(store)
+	})
+}
+
+// SetLibraries sets the modules the scripts of every engine can require. It waits for the
+// running scripts to finish, as the engines are reset.
+func (p *EnginePool) SetLibraries(libraries map[string]string) error {
+	return p.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetLibraries(libraries)
+	})
+}
+
+// SetScheduler makes the jobs scheduled on every engine listable and cancellable. It waits
+// for the running scripts to finish, as the engines are reset.
+func (p *EnginePool) SetScheduler(scheduler *JobScheduler) error {
+	return p.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetScheduler(scheduler)
+	})
+}
+
+// reconfigure takes every engine out of the pool, waiting for the running scripts, and
+// applies configure to it
+func (p *EnginePool) reconfigure(configure func(engine *ScriptEngine) error) error {
+	engines := make([]*ScriptEngine, 0, p.Size())
+	for len(engines) < p.Size() {
+		engines = append(engines, <-p.engines)
+	}
+	defer func() {
+		for _, engine := range engines {
+			p.engines <- engine
+		}
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, engine := range append(engines, p.template) {
+		if err := configure(engine); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
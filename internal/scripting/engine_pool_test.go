@@ -0,0 +1,181 @@
+package scripting
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestScriptsRunConcurrently(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 5000, 4, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	results := make([]string, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := service.ExecuteScript("sleep(200); 'done'")
+			if err != nil {
+				t.Errorf("Script %d failed: %v", i, err)
+			}
+			results[i] = result
+		}(i)
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > 600*time.Millisecond {
+		t.Errorf("Four scripts on four engines should run in parallel, took %v", elapsed)
+	}
+	for i, result := range results {
+		if result != "done" {
+			t.Errorf("Script %d: expected done, got %q", i, result)
+		}
+	}
+}
+
+func TestEnginePoolQueueTimeout(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	pool, err := NewEnginePool(NewPcapHelper(manager), 5000, 1, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to create engine pool: %v", err)
+	}
+
+	engine, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := engine.Execute("var leftover = 1"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if _, err := pool.Acquire(context.Background()); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("Expected ErrQueueTimeout while the only engine is in use, got %v", err)
+	}
+
+	// A queued run gets the engine as soon as it is released, with a fresh VM
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pool.Release(engine)
+	}()
+	engine, err = pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Queued Acquire failed: %v", err)
+	}
+	defer pool.Release(engine)
+
+	result, err := engine.Execute("typeof leftover")
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result != "undefined" {
+		t.Errorf("Released engines should be reset, leftover is %s", result)
+	}
+}
+
+func TestScriptTimeoutFreesEngine(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 100, 1, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+
+	if _, err := service.ExecuteScript("while (true) {}"); err == nil {
+		t.Fatalf("Expected the endless script to time out")
+	}
+
+	result, err := service.ExecuteScript("1 + 1")
+	if err != nil || result != "2" {
+		t.Errorf("The engine should be usable after a timeout, got %q, %v", result, err)
+	}
+}
+
+func TestScriptTimeoutRetiresBlockedEngine(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	pool, err := NewEnginePool(NewPcapHelper(manager), 200, 1, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create engine pool: %v", err)
+	}
+
+	// sleep wakes up at the deadline, so the script is interrupted and the engine kept
+	engine, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	start := time.Now()
+	if _, err := engine.Execute("sleep(3000); 'late'"); err == nil {
+		t.Errorf("Expected the sleeping script to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the script to stop at its timeout, took %v", elapsed)
+	}
+	if engine.Retired() {
+		t.Errorf("Expected an engine interrupted at the deadline to be kept")
+	}
+	pool.Release(engine)
+
+	// A binding that ignores the deadline leaves the engine to the script
+	unblock := make(chan struct{})
+	defer close(unblock)
+	engine, err = pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if err := engine.vm.Set("block", func() { <-unblock }); err != nil {
+		t.Fatalf("Failed to set binding: %v", err)
+	}
+	start = time.Now()
+	if _, err := engine.Execute("block()"); err == nil {
+		t.Errorf("Expected the blocked script to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the blocked script to return at its timeout, took %v", elapsed)
+	}
+	if !engine.Retired() {
+		t.Fatalf("Expected the blocked engine to be retired")
+	}
+	if _, err := engine.Execute("1"); !errors.Is(err, errEngineRetired) {
+		t.Errorf("Expected the retired engine to refuse scripts, got %v", err)
+	}
+	pool.Release(engine)
+
+	replacement, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer pool.Release(replacement)
+	if replacement == engine {
+		t.Fatalf("Expected the retired engine to be replaced")
+	}
+	if result, err := replacement.Execute("1 + 1"); err != nil || result != "2" {
+		t.Errorf("Expected the replacement to run scripts, got %q, %v", result, err)
+	}
+}
+
+func TestPanicRetiresEngine(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	engine, err := NewScriptEngine(NewPcapHelper(manager), 5000)
+	if err != nil {
+		t.Fatalf("Failed to create script engine: %v", err)
+	}
+	if err := engine.vm.Set("crash", func() { panic("boom") }); err != nil {
+		t.Fatalf("Failed to set binding: %v", err)
+	}
+
+	if _, err := engine.Execute("crash()"); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected the panic to fail the script, got %v", err)
+	}
+	if !engine.Retired() {
+		t.Errorf("Expected the engine to be retired after a panic")
+	}
+}
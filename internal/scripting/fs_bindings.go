@@ -0,0 +1,170 @@
+package scripting
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/robertkrimen/otto"
+)
+
+// registerFsBindings registers the fs object, confined to sandbox. Only sandbox paths can
+// be written, but copy also reads the PCAP files of the worker's PCAP storage.
+func registerFsBindings(vm *otto.Otto, sandbox *FileSandbox, pcapHelper *PcapHelper, meter *budgetMeter) error {
+	fsObj, err := vm.Object("fs = {}")
+	if err != nil {
+		return err
+	}
+
+	// Register read function
+	err = fsObj.Set("read", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("fs.read")
+		data, err := sandbox.Read(call.Argument(0).String())
+		if err != nil {
+			throwFsError(call, err)
+		}
+
+		result, _ := call.Otto.ToValue(string(data))
+		return result
+	})
+	if err != nil {
+		return err
+	}
+
+	// Register write function
+	err = fsObj.Set("write", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("fs.write")
+		path := call.Argument(0).String()
+		data := fileData(call, call.Argument(1))
+		appendData := false
+		if options := call.Argument(2); options.IsObject() {
+			if value, err := options.Object().Get("append"); err == nil && value.IsDefined() {
+				appendData, _ = value.ToBoolean()
+			}
+		}
+
+		if err := sandbox.Write(path, data, appendData); err != nil {
+			throwFsError(call, err)
+		}
+
+		return otto.UndefinedValue()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Register list function
+	err = fsObj.Set("list", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("fs.list")
+		path := "."
+		if call.Argument(0).IsDefined() {
+			path = call.Argument(0).String()
+		}
+
+		infos, err := sandbox.List(path)
+		if err != nil {
+			throwFsError(call, err)
+		}
+
+		entries := make([]map[string]interface{}, 0, len(infos))
+		for _, info := range infos {
+			entries = append(entries, fileInfoObject(info))
+		}
+
+		return toJSValue(call, entries)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Register stat function
+	err = fsObj.Set("stat", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("fs.stat")
+		info, err := sandbox.Stat(call.Argument(0).String())
+		if errors.Is(err, os.ErrNotExist) {
+			return otto.NullValue()
+		}
+		if err != nil {
+			throwFsError(call, err)
+		}
+
+		return toJSValue(call, fileInfoObject(info))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Register copy function
+	err = fsObj.Set("copy", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("fs.copy")
+		src := call.Argument(0).String()
+		dst := call.Argument(1).String()
+
+		// Relative sources are sandbox files, absolute ones outside the sandbox are PCAP files
+		var file io.ReadCloser
+		var err error
+		if !filepath.IsAbs(src) || sandbox.Contains(src) {
+			file, err = sandbox.Open(src)
+		} else {
+			file, err = pcapHelper.OpenStoredPcap(src)
+		}
+		if err != nil {
+			throwFsError(call, err)
+		}
+		defer file.Close()
+
+		copied, err := sandbox.Copy(dst, file)
+		if err != nil {
+			throwFsError(call, err)
+		}
+
+		result, _ := call.Otto.ToValue(copied)
+		return result
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// fileData converts the data argument of fs.write, a string or an array of bytes
+func fileData(call otto.FunctionCall, value otto.Value) []byte {
+	if !value.IsObject() || value.Class() != "Array" {
+		return []byte(value.String())
+	}
+
+	length, _ := value.Object().Get("length")
+	n, _ := length.ToInteger()
+	data := make([]byte, 0, n)
+	for i := int64(0); i < n; i++ {
+		element, _ := value.Object().Get(fmt.Sprint(i))
+		b, err := element.ToInteger()
+		if err != nil || b < 0 || b > 255 {
+			throwError(call, "TypeError", fmt.Errorf("data must be a string or an array of bytes"))
+		}
+		data = append(data, byte(b))
+	}
+
+	return data
+}
+
+// fileInfoObject converts file information to the object returned by fs.list and fs.stat
+func fileInfoObject(info SandboxFileInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     info.Name,
+		"size":     info.Size,
+		"dir":      info.Dir,
+		"modified": info.Modified.UnixMilli(),
+	}
+}
+
+// throwFsError raises a file system error in the calling script
+func throwFsError(call otto.FunctionCall, err error) {
+	if errors.Is(err, ErrSandboxQuotaExceeded) {
+		throwError(call, "FsQuotaError", err)
+	}
+	throwError(call, "Error", err)
+}
@@ -0,0 +1,345 @@
+package scripting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOutsideSandbox is returned for paths that leave the sandbox root, directly or through a symlink
+	ErrOutsideSandbox = errors.New("path is outside the file system sandbox")
+	// ErrSandboxQuotaExceeded is returned when a write would grow the sandbox beyond its byte quota
+	ErrSandboxQuotaExceeded = errors.New("file system sandbox quota exceeded")
+)
+
+// sandboxReconcileInterval is how long the running usage of a sandbox is trusted before the
+// files are walked again, to account for changes made outside of it
+const sandboxReconcileInterval = time.Minute
+
+// SandboxFileInfo describes a file or directory in the sandbox
+type SandboxFileInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Dir      bool      `json:"dir"`
+	Modified time.Time `json:"modified"`
+}
+
+// FileSandbox gives scripts access to the files below a root directory. Paths are relative
+// to the root, absolute paths must lie inside it, and symlinks must not lead out of it.
+// The total size of the files in the sandbox is limited by a byte quota. Writes are serialized,
+// and counted in a running usage that a walk of the files reconciles from time to time.
+type FileSandbox struct {
+	root     string
+	maxBytes int64
+	// usage is the total size of the files as of reconciled, plus the writes since
+	usage      int64
+	reconciled time.Time
+	mu         sync.Mutex
+}
+
+// NewFileSandbox creates the root directory if needed and returns a sandbox confined to it.
+// A maxBytes of zero or less leaves the sandbox without a quota.
+func NewFileSandbox(root string, maxBytes int64) (*FileSandbox, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sandbox root %s: %w", root, err)
+	}
+
+	// Resolve the root itself, so it can be compared with resolved paths
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSandbox{root: resolved, maxBytes: maxBytes}, nil
+}
+
+// Root returns the sandbox root directory
+func (s *FileSandbox) Root() string {
+	return s.root
+}
+
+// Contains reports whether an absolute path lies in the sandbox
+func (s *FileSandbox) Contains(path string) bool {
+	_, err := s.relative(path)
+	return filepath.IsAbs(path) && err == nil
+}
+
+// Read returns the content of a file
+func (s *FileSandbox) Read(path string) ([]byte, error) {
+	resolved, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(resolved)
+}
+
+// Open opens a file for reading
+func (s *FileSandbox) Open(path string) (io.ReadCloser, error) {
+	resolved, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(resolved)
+}
+
+// Write replaces the content of a file, or appends to it, creating its directory if needed
+func (s *FileSandbox) Write(path string, data []byte, appendData bool) error {
+	_, err := s.writeFrom(path, bytes.NewReader(data), appendData)
+	return err
+}
+
+// Copy writes the content of src to path, replacing it, and returns the number of bytes copied
+func (s *FileSandbox) Copy(path string, src io.Reader) (int64, error) {
+	return s.writeFrom(path, src, false)
+}
+
+// List returns the entries of a directory
+func (s *FileSandbox) List(path string) ([]SandboxFileInfo, error) {
+	resolved, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SandboxFileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, sandboxFileInfo(info))
+	}
+
+	return infos, nil
+}
+
+// Stat describes a file or directory
+func (s *FileSandbox) Stat(path string) (SandboxFileInfo, error) {
+	resolved, err := s.resolve(path)
+	if err != nil {
+		return SandboxFileInfo{}, err
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return SandboxFileInfo{}, err
+	}
+
+	return sandboxFileInfo(info), nil
+}
+
+// Usage returns the total size of the files in the sandbox
+func (s *FileSandbox) Usage() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.currentUsage(false)
+}
+
+// currentUsage returns the running usage, walking the files first if it is older than
+// sandboxReconcileInterval or reconcile is set. The caller holds s.mu.
+func (s *FileSandbox) currentUsage(reconcile bool) (int64, error) {
+	if !reconcile && time.Since(s.reconciled) < sandboxReconcileInterval {
+		return s.usage, nil
+	}
+
+	usage, err := s.walkUsage()
+	if err != nil {
+		return 0, err
+	}
+	s.usage, s.reconciled = usage, time.Now()
+
+	return usage, nil
+}
+
+// walkUsage sums the sizes of the files in the sandbox
+func (s *FileSandbox) walkUsage() (int64, error) {
+	var usage int64
+	err := filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			usage += info.Size()
+		}
+		return nil
+	})
+
+	return usage, err
+}
+
+// writeFrom writes src to path within the quota. A file that is replaced is written to a
+// temporary file first, so a write exceeding the quota leaves the previous content intact.
+// Writes are serialized, so the quota can't be exceeded by concurrent ones.
+func (s *FileSandbox) writeFrom(path string, src io.Reader, appendData bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resolved, err := s.resolve(path)
+	if err != nil {
+		return 0, err
+	}
+	if resolved == s.root {
+		return 0, fmt.Errorf("cannot write to the sandbox root")
+	}
+	if err := os.MkdirAll(filepath.Dir(resolved), 0755); err != nil {
+		return 0, err
+	}
+
+	var previous int64
+	if info, err := os.Stat(resolved); err == nil {
+		if info.IsDir() {
+			return 0, fmt.Errorf("%s is a directory", path)
+		}
+		previous = info.Size()
+	}
+
+	// An appended file keeps its previous content
+	replaced := previous
+	if appendData {
+		replaced = 0
+	}
+
+	limit := int64(-1)
+	if s.maxBytes > 0 {
+		usage, err := s.currentUsage(false)
+		if err != nil {
+			return 0, err
+		}
+		// Files removed outside of the sandbox may have freed space, walk them before refusing every write
+		if usage-replaced >= s.maxBytes {
+			if usage, err = s.currentUsage(true); err != nil {
+				return 0, err
+			}
+		}
+		limit = s.maxBytes - usage + replaced
+		// Copy one byte more than allowed to detect that the quota is exceeded
+		src = io.LimitReader(src, limit+1)
+	}
+	exceeded := fmt.Errorf("%w: writing %s would exceed %d bytes", ErrSandboxQuotaExceeded, path, s.maxBytes)
+
+	if appendData {
+		file, err := os.OpenFile(resolved, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+
+		written, err := io.Copy(file, src)
+		if err == nil && limit >= 0 && written > limit {
+			file.Truncate(previous)
+			return 0, exceeded
+		}
+		s.usage += written
+		return written, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(resolved), "."+filepath.Base(resolved)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if limit >= 0 && written > limit {
+		return 0, exceeded
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), resolved); err != nil {
+		return 0, err
+	}
+	s.usage += written - replaced
+
+	return written, nil
+}
+
+// resolve maps a sandbox path to a path on disk. The longest existing prefix of the
+// path is resolved through its symlinks and must stay inside the root.
+func (s *FileSandbox) resolve(path string) (string, error) {
+	rel, err := s.relative(path)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(s.root, rel)
+
+	existing := target
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.relative(resolved); err != nil || !filepath.IsAbs(resolved) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideSandbox, path)
+	}
+
+	return filepath.Join(resolved, strings.TrimPrefix(target, existing)), nil
+}
+
+// relative returns a path relative to the root, rejecting absolute paths outside the
+// root and relative paths that climb out of it
+func (s *FileSandbox) relative(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(s.root, filepath.Clean(path))
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrOutsideSandbox, path)
+		}
+		path = rel
+	}
+
+	rel := filepath.Clean(path)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideSandbox, path)
+	}
+
+	return rel, nil
+}
+
+// sandboxFileInfo converts file information to a SandboxFileInfo
+func sandboxFileInfo(info fs.FileInfo) SandboxFileInfo {
+	return SandboxFileInfo{
+		Name:     info.Name(),
+		Size:     info.Size(),
+		Dir:      info.IsDir(),
+		Modified: info.ModTime(),
+	}
+}
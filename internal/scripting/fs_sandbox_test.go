@@ -0,0 +1,149 @@
+package scripting
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestFileSandboxConfinement(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	sandbox, err := NewFileSandbox(filepath.Join(dir, "root"), 0)
+	if err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(sandbox.Root(), "escape")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	for _, path := range []string{"../outside/a.txt", "a/../../outside/a.txt", filepath.Join(outside, "a.txt"), "escape/a.txt"} {
+		if err := sandbox.Write(path, []byte("data"), false); !errors.Is(err, ErrOutsideSandbox) {
+			t.Errorf("Writing %s: expected %v, got %v", path, ErrOutsideSandbox, err)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Expected nothing written outside the sandbox, found %d entries", len(entries))
+	}
+
+	if err := sandbox.Write(filepath.Join(sandbox.Root(), "in/a.txt"), []byte("data"), false); err != nil {
+		t.Fatalf("Failed to write inside the sandbox: %v", err)
+	}
+	if data, err := sandbox.Read("in/a.txt"); err != nil || string(data) != "data" {
+		t.Errorf("Expected to read back data, got %q, %v", data, err)
+	}
+}
+
+func TestFileSandboxQuota(t *testing.T) {
+	sandbox, err := NewFileSandbox(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+
+	if err := sandbox.Write("a.txt", []byte("12345"), false); err != nil {
+		t.Fatalf("Failed to write within the quota: %v", err)
+	}
+	if err := sandbox.Write("a.txt", []byte("123456"), true); !errors.Is(err, ErrSandboxQuotaExceeded) {
+		t.Errorf("Expected %v on append, got %v", ErrSandboxQuotaExceeded, err)
+	}
+	if err := sandbox.Write("a.txt", []byte("12345678901"), false); !errors.Is(err, ErrSandboxQuotaExceeded) {
+		t.Errorf("Expected %v on replace, got %v", ErrSandboxQuotaExceeded, err)
+	}
+	if data, _ := sandbox.Read("a.txt"); string(data) != "12345" {
+		t.Errorf("Expected failed writes to keep the content, got %q", data)
+	}
+
+	// Replacing a file only counts its new size
+	if err := sandbox.Write("a.txt", []byte("1234567890"), false); err != nil {
+		t.Errorf("Failed to replace within the quota: %v", err)
+	}
+	if usage, _ := sandbox.Usage(); usage != 10 {
+		t.Errorf("Expected a usage of 10 bytes, got %d", usage)
+	}
+
+	// Files removed outside of the sandbox free their space for the next write
+	if err := os.Remove(filepath.Join(sandbox.Root(), "a.txt")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := sandbox.Write("b.txt", []byte("12345"), false); err != nil {
+		t.Errorf("Expected the removed file to free its space: %v", err)
+	}
+}
+
+func TestFileSandboxConcurrentQuota(t *testing.T) {
+	sandbox, err := NewFileSandbox(t.TempDir(), 100)
+	if err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sandbox.Write(fmt.Sprintf("%d.txt", i), []byte("1234567890"), false)
+		}(i)
+	}
+	wg.Wait()
+
+	if usage, _ := sandbox.Usage(); usage != 100 {
+		t.Errorf("Expected concurrent writes to fill the quota exactly, got %d bytes", usage)
+	}
+}
+
+func TestFsBinding(t *testing.T) {
+	dir := t.TempDir()
+	pcapDir := filepath.Join(dir, "pcaps")
+	if err := os.MkdirAll(pcapDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pcapDir, "stream.pcap"), []byte("pcap"), 0644); err != nil {
+		t.Fatalf("Failed to write PCAP: %v", err)
+	}
+	manager := worker.NewPcapManager(pcapDir, 10*time.Second, 1024*1024)
+	engine, err := NewScriptEngine(NewPcapHelper(manager), 5000)
+	if err != nil {
+		t.Fatalf("Failed to create script engine: %v", err)
+	}
+
+	if result, err := engine.Execute("typeof fs"); err != nil || result != "undefined" {
+		t.Errorf("Expected no fs binding by default, got %v, %v", result, err)
+	}
+
+	sandbox, err := NewFileSandbox(filepath.Join(dir, "sandbox"), 64)
+	if err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+	if err := engine.SetFileSystem(sandbox); err != nil {
+		t.Fatalf("Failed to set file system: %v", err)
+	}
+
+	script := `
+		var copied = fs.copy(pcap.getPcapPath("stream"), "evidence/stream.pcap");
+		fs.write("evidence/notes.txt", "a");
+		fs.write("evidence/notes.txt", [98, 99], {append: true});
+		var names = fs.list("evidence").map(function(f) { return f.name + ":" + f.size }).join(",");
+		var quota;
+		try { fs.write("big.txt", new Array(100).join("x")) } catch (e) { quota = e.name }
+		var escaped;
+		try { fs.read("../pcaps/stream.pcap") } catch (e) { escaped = e.message }
+		[copied, fs.read("evidence/notes.txt"), names, fs.stat("missing") === null, quota, !!escaped].join(" ")
+	`
+	result, err := engine.Execute(script)
+	if err != nil {
+		t.Fatalf("Script failed: %v", err)
+	}
+	expected := "4 abc notes.txt:3,stream.pcap:4 true FsQuotaError true"
+	if result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
@@ -0,0 +1,130 @@
+package scripting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxScriptHistorySize is the size, in bytes of JSON, the history is trimmed to. A ConfigMap
+// holds at most 1 MiB, the rest is left to the scripts and the other entries.
+const MaxScriptHistorySize = 512 * 1024
+
+// ErrScriptHistoryTooLarge is returned when the latest versions alone don't fit in the history
+var ErrScriptHistoryTooLarge = errors.New("script history too large")
+
+// ScriptVersion is a version of a script recorded in its history
+type ScriptVersion struct {
+	Version int       `json:"version"`
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Code    string    `json:"code"`
+}
+
+// ShortHash returns the first characters of the version's hash, enough to tell versions apart
+func (v ScriptVersion) ShortHash() string {
+	if len(v.Hash) < 12 {
+		return v.Hash
+	}
+
+	return v.Hash[:12]
+}
+
+// ScriptHistory holds the versions of the scripts by title, oldest first. It is kept in the
+// ConfigMap next to the scripts, so a bad update can be rolled back.
+type ScriptHistory map[string][]ScriptVersion
+
+// ParseScriptHistory parses a history from its JSON form, an empty string is an empty history
+func ParseScriptHistory(data string) (ScriptHistory, error) {
+	history := make(ScriptHistory)
+	if data == "" {
+		return history, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), &history); err != nil {
+		return nil, fmt.Errorf("failed to parse script history: %w", err)
+	}
+
+	return history, nil
+}
+
+// HashScript returns the SHA-256 of a script's code in hex
+func HashScript(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Record adds code as the next version of the titled script and reports whether it did.
+// Code equal to the latest version isn't recorded again. Only the last limit versions are
+// kept, a limit of zero or less keeps them all. Version numbers keep counting up, so they
+// still identify the same code once older versions are dropped.
+func (h ScriptHistory) Record(title string, code string, author string, now time.Time, limit int) (ScriptVersion, bool) {
+	versions := h[title]
+	hash := HashScript(code)
+	if len(versions) > 0 && versions[len(versions)-1].Hash == hash {
+		return versions[len(versions)-1], false
+	}
+
+	version := ScriptVersion{Version: 1, Hash: hash, Author: author, Time: now, Code: code}
+	if len(versions) > 0 {
+		version.Version = versions[len(versions)-1].Version + 1
+	}
+
+	versions = append(versions, version)
+	if limit > 0 && len(versions) > limit {
+		versions = append([]ScriptVersion(nil), versions[len(versions)-limit:]...)
+	}
+	h[title] = versions
+
+	return version, true
+}
+
+// Version returns a version of the titled script, if it is still in the history
+func (h ScriptHistory) Version(title string, version int) (ScriptVersion, bool) {
+	for _, v := range h[title] {
+		if v.Version == version {
+			return v, true
+		}
+	}
+
+	return ScriptVersion{}, false
+}
+
+// Trim drops the oldest versions, across all scripts, until the JSON form of the history fits
+// in maxSize bytes, and returns how many it dropped. The latest version of each script is kept.
+func (h ScriptHistory) Trim(maxSize int) (int, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	size := len(data)
+	for size > maxSize {
+		// Pick the oldest version that isn't the latest of its script
+		oldest := ""
+		for title, versions := range h {
+			if len(versions) > 1 && (oldest == "" || versions[0].Time.Before(h[oldest][0].Time)) {
+				oldest = title
+			}
+		}
+		if oldest == "" {
+			return dropped, fmt.Errorf("%w: the latest versions take %d bytes, at most %d are allowed", ErrScriptHistoryTooLarge, size, maxSize)
+		}
+
+		version, err := json.Marshal(h[oldest][0])
+		if err != nil {
+			return dropped, err
+		}
+		// The version and the comma that separates it from the next one
+		size -= len(version) + 1
+		h[oldest] = h[oldest][1:]
+		dropped++
+	}
+
+	return dropped, nil
+}
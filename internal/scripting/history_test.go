@@ -0,0 +1,77 @@
+package scripting
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScriptHistory(t *testing.T) {
+	history, err := ParseScriptHistory("")
+	if err != nil {
+		t.Fatalf("Failed to parse an empty history: %v", err)
+	}
+
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, code := range []string{"v1", "v2", "v2", "v3", "v1"} {
+		history.Record("errors", code, "dev@laptop", now.Add(time.Duration(i)*time.Minute), 3)
+	}
+
+	versions := history["errors"]
+	if len(versions) != 3 {
+		t.Fatalf("Expected the history to be capped to 3 versions, got %d", len(versions))
+	}
+	if versions[0].Version != 2 || versions[2].Version != 4 || versions[2].Code != "v1" {
+		t.Errorf("Expected versions 2 to 4 with the repeated code skipped, got %+v", versions)
+	}
+	if versions[2].Hash != HashScript("v1") || len(versions[2].ShortHash()) != 12 {
+		t.Errorf("Expected the version to hold the hash of its code, got %s", versions[2].Hash)
+	}
+	if _, ok := history.Version("errors", 1); ok {
+		t.Errorf("Expected version 1 to be dropped")
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		t.Fatalf("Failed to marshal history: %v", err)
+	}
+	parsed, err := ParseScriptHistory(string(data))
+	if err != nil {
+		t.Fatalf("Failed to parse history: %v", err)
+	}
+	if version, ok := parsed.Version("errors", 3); !ok || version.Code != "v3" || version.Author != "dev@laptop" || !version.Time.Equal(now.Add(3*time.Minute)) {
+		t.Errorf("Expected version 3 to survive the round trip, got %+v", version)
+	}
+}
+
+func TestScriptHistoryTrim(t *testing.T) {
+	history := make(ScriptHistory)
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	big := strings.Repeat("x", 1000)
+	for i := 0; i < 5; i++ {
+		history.Record("a", big+string(rune('a'+i)), "dev@laptop", now.Add(time.Duration(2*i)*time.Minute), 0)
+		history.Record("b", big+string(rune('a'+i)), "dev@laptop", now.Add(time.Duration(2*i+1)*time.Minute), 0)
+	}
+
+	dropped, err := history.Trim(5000)
+	if err != nil {
+		t.Fatalf("Trim failed: %v", err)
+	}
+	data, _ := json.Marshal(history)
+	if len(data) > 5000 {
+		t.Errorf("Expected the history to fit in 5000 bytes, got %d", len(data))
+	}
+	if dropped != 6 || len(history["a"]) != 2 || len(history["b"]) != 2 {
+		t.Errorf("Expected the 6 oldest versions to be dropped, got %d dropped, %d and %d left", dropped, len(history["a"]), len(history["b"]))
+	}
+
+	// The latest versions are kept, even if they don't fit
+	if _, err := history.Trim(1000); !errors.Is(err, ErrScriptHistoryTooLarge) {
+		t.Errorf("Expected ErrScriptHistoryTooLarge, got %v", err)
+	}
+	if len(history["a"]) != 1 || history["a"][0].Version != 5 || len(history["b"]) != 1 {
+		t.Errorf("Expected the latest versions to be kept, got %+v", history)
+	}
+}
@@ -0,0 +1,426 @@
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+	"github.com/robertkrimen/otto/ast"
+	"github.com/robertkrimen/otto/file"
+	"github.com/rs/zerolog/log"
+)
+
+// Hooks a script can define, the runtime calls them with the matching events
+const (
+	HookItemCaptured         = "onItemCaptured"
+	HookPcapCreated          = "onPcapCreated"
+	HookPcapRetained         = "onPcapRetained"
+	HookPcapRetentionExpired = "onPcapRetentionExpired"
+	HookPcapEvicted          = "onPcapEvicted"
+	HookPcapDeleted          = "onPcapDeleted"
+)
+
+// HookNames lists the hooks a script can define
+var HookNames = []string{
+	HookItemCaptured,
+	HookPcapCreated,
+	HookPcapRetained,
+	HookPcapRetentionExpired,
+	HookPcapEvicted,
+	HookPcapDeleted,
+}
+
+// pcapEventHooks maps PCAP lifecycle events to their hooks
+var pcapEventHooks = map[worker.PcapEventType]string{
+	worker.PcapCreated:          HookPcapCreated,
+	worker.PcapRetained:         HookPcapRetained,
+	worker.PcapRetentionExpired: HookPcapRetentionExpired,
+	worker.PcapEvicted:          HookPcapEvicted,
+	worker.PcapDeleted:          HookPcapDeleted,
+}
+
+// HookEvent is an event dispatched to the scripts defining its hook
+type HookEvent interface {
+	// Hook returns the name of the hook handling the event
+	Hook() string
+	// Args returns the hook arguments, they are converted to plain JavaScript values
+	Args() []interface{}
+}
+
+// ItemEndpoint is the source or destination of a captured item
+type ItemEndpoint struct {
+	IP        string `json:"ip"`
+	Port      uint16 `json:"port"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// CapturedItem is a dissected request and response pair
+type CapturedItem struct {
+	ID        string                 `json:"id"`
+	Protocol  string                 `json:"protocol"`
+	Stream    string                 `json:"stream,omitempty"`
+	Timestamp time.Time              `json:"-"`
+	Elapsed   time.Duration          `json:"-"`
+	Src       ItemEndpoint           `json:"src"`
+	Dst       ItemEndpoint           `json:"dst"`
+	Method    string                 `json:"method,omitempty"`
+	Path      string                 `json:"path,omitempty"`
+	Status    int                    `json:"status,omitempty"`
+	Request   map[string]interface{} `json:"request,omitempty"`
+	Response  map[string]interface{} `json:"response,omitempty"`
+}
+
+// ItemCapturedEvent calls onItemCaptured(item)
+type ItemCapturedEvent struct {
+	Item CapturedItem
+}
+
+// Hook returns the name of the hook handling the event
+func (e ItemCapturedEvent) Hook() string {
+	return HookItemCaptured
+}
+
+// Args returns the item, with its timestamp and elapsed time in milliseconds
+func (e ItemCapturedEvent) Args() []interface{} {
+	return []interface{}{struct {
+		CapturedItem
+		Timestamp int64 `json:"timestamp"`
+		Elapsed   int64 `json:"elapsed"`
+	}{e.Item, e.Item.Timestamp.UnixMilli(), e.Item.Elapsed.Milliseconds()}}
+}
+
+// PcapLifecycleEvent calls the hook of a PCAP lifecycle event, such as onPcapRetained(name, event)
+type PcapLifecycleEvent struct {
+	Event worker.PcapEvent
+}
+
+// Hook returns the name of the hook handling the event
+func (e PcapLifecycleEvent) Hook() string {
+	return pcapEventHooks[e.Event.Type]
+}
+
+// Args returns the PCAP name and the event
+func (e PcapLifecycleEvent) Args() []interface{} {
+	return []interface{}{e.Event.Name, pcapEventObject(e.Event)}
+}
+
+// HookError is the failure of a single script's hook
+type HookError struct {
+	Title string
+	Hook  string
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("hook %s of script %q failed: %v", e.Hook, e.Title, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// HookRegistry holds the loaded scripts and the hooks they define. Each script keeps the
+// engine it was executed on, so its top level runs once and its hooks and jobs share its
+// globals. Events are dispatched to each script defining the event's hook concurrently, so a
+// failing or slow hook doesn't affect the other scripts. The calls into a script are serialized,
+// as its VM can't run two of them at once, so a slow hook delays the script's later events.
+// At most as many calls as the pool has engines run at once, the others wait for a slot.
+type HookRegistry struct {
+	pool    *EnginePool
+	scripts map[string]*hookedScript
+	slots   chan struct{}
+	mu      sync.RWMutex
+}
+
+// hookedScript is a loaded script, the hooks it defines and its engine
+type hookedScript struct {
+	title   string
+	program *ast.Program
+	hooks   map[string]bool
+	engine  *ScriptEngine
+	mu      sync.Mutex
+}
+
+// NewHookRegistry creates a registry that replaces the retired engines of its scripts with
+// engines spawned from pool
+func NewHookRegistry(pool *EnginePool) *HookRegistry {
+	return &HookRegistry{
+		pool:    pool,
+		scripts: make(map[string]*hookedScript),
+		slots:   make(chan struct{}, pool.Size()),
+	}
+}
+
+// Load parses a script, runs it on engine and registers the hooks it defines, replacing a
+// script of the same title. The hooks are called on engine. It returns the completion value
+// of the script and the names of the hooks found.
+func (r *HookRegistry) Load(title string, source string, engine *ScriptEngine) (string, []string, error) {
+	program, err := parseScript(title, source)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse script %q: %w", title, err)
+	}
+
+	result, err := engine.Load(title, program)
+	if err != nil {
+		return "", nil, err
+	}
+
+	script := &hookedScript{
+		title:   title,
+		program: program,
+		hooks:   discoverHooks(program),
+		engine:  engine,
+	}
+
+	r.mu.Lock()
+	r.scripts[title] = script
+	r.mu.Unlock()
+
+	return result, script.hookNames(), nil
+}
+
+// Unload removes a script and its hooks
+func (r *HookRegistry) Unload(title string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.scripts, title)
+}
+
+// Hooks returns the hooks the titled script defines
+func (r *HookRegistry) Hooks(title string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	script, ok := r.scripts[title]
+	if !ok {
+		return nil
+	}
+	return script.hookNames()
+}
+
+// withEngine calls fn with the engine of the titled script, once the previous call into
+// the script returned
+func (r *HookRegistry) withEngine(title string, fn func(engine *ScriptEngine) error) error {
+	r.mu.RLock()
+	script, ok := r.scripts[title]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("script %q is not loaded", title)
+	}
+
+	return r.call(context.Background(), script, fn)
+}
+
+// SetBudget sets the budget of the engines of the loaded scripts
+func (r *HookRegistry) SetBudget(budget ScriptBudget) {
+	for _, script := range r.loaded() {
+		script.mu.Lock()
+		script.engine.SetBudget(budget)
+		script.mu.Unlock()
+	}
+}
+
+// reconfigure applies configure to the engines of the loaded scripts, once their running
+// calls returned. Configuring resets an engine, so the script is loaded on it again. Retired
+// engines are skipped, they are replaced by engines spawned from the reconfigured pool.
+func (r *HookRegistry) reconfigure(configure func(engine *ScriptEngine) error) error {
+	var errs []error
+	for _, script := range r.loaded() {
+		script.mu.Lock()
+		var err error
+		if !script.engine.Retired() {
+			if err = configure(script.engine); err == nil {
+				_, err = script.engine.Load(script.title, script.program)
+			}
+		}
+		script.mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to reconfigure script %q: %w", script.title, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// loaded returns the loaded scripts
+func (r *HookRegistry) loaded() []*hookedScript {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	scripts := make([]*hookedScript, 0, len(r.scripts))
+	for _, script := range r.scripts {
+		scripts = append(scripts, script)
+	}
+
+	return scripts
+}
+
+// Dispatch calls the event's hook of every script defining it, concurrently, and waits for
+// them. The failures are returned joined as HookErrors, each is also logged.
+func (r *HookRegistry) Dispatch(ctx context.Context, event HookEvent) error {
+	hook := event.Hook()
+	if hook == "" {
+		return nil
+	}
+
+	r.mu.RLock()
+	var scripts []*hookedScript
+	for _, script := range r.scripts {
+		if script.hooks[hook] {
+			scripts = append(scripts, script)
+		}
+	}
+	r.mu.RUnlock()
+
+	errs := make([]error, len(scripts))
+	var wg sync.WaitGroup
+	for i, script := range scripts {
+		wg.Add(1)
+		go func(i int, script *hookedScript) {
+			defer wg.Done()
+			err := r.call(ctx, script, func(engine *ScriptEngine) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				_, err := engine.CallHook(hook, event.Args()...)
+				return err
+			})
+			if err != nil {
+				errs[i] = &HookError{Title: script.title, Hook: hook, Err: err}
+				if ctx.Err() == nil {
+					log.Error().Err(err).Str("script", script.title).Str("hook", hook).Msg("Script hook failed")
+				}
+			}
+		}(i, script)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// call calls fn with the script's engine, once the script's previous call returned and a slot
+// is free. An engine retired after a timeout or a crash is replaced by one spawned from the
+// pool, on which the script is loaded again.
+func (r *HookRegistry) call(ctx context.Context, s *hookedScript, fn func(engine *ScriptEngine) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The script's lock is taken first, so the calls waiting for it don't hold slots
+	if err := r.acquire(ctx); err != nil {
+		return err
+	}
+	defer func() { <-r.slots }()
+
+	if s.engine.Retired() {
+		engine, err := r.pool.Spawn()
+		if err != nil {
+			return err
+		}
+		if _, err := engine.Load(s.title, s.program); err != nil {
+			return fmt.Errorf("failed to reload script: %w", err)
+		}
+		log.Warn().Str("script", s.title).Msg("Reloaded a script whose engine was retired")
+		s.engine = engine
+	}
+
+	return fn(s.engine)
+}
+
+// acquire takes a slot, waiting until one is freed, the pool's queue timeout passes or ctx is done
+func (r *HookRegistry) acquire(ctx context.Context) error {
+	select {
+	case r.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if r.pool.queueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.pool.queueTimeout)
+		defer cancel()
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %v", ErrQueueTimeout, r.pool.queueTimeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// hookNames returns the sorted names of the script's hooks
+func (s *hookedScript) hookNames() []string {
+	names := make([]string, 0, len(s.hooks))
+	for name := range s.hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// discoverHooks returns the known hooks a program defines at its top level
+func discoverHooks(program *ast.Program) map[string]bool {
+	hooks := make(map[string]bool)
+	for name := range topLevelFunctions(program) {
+		if isHookName(name) {
+			hooks[name] = true
+		}
+	}
+
+	return hooks
+}
+
+// topLevelFunctions returns the functions a program defines at its top level, as function
+// declarations, variables or assignments of function expressions, and where they are defined
+func topLevelFunctions(program *ast.Program) map[string]file.Idx {
+	functions := make(map[string]file.Idx)
+	define := func(name string, idx file.Idx, value ast.Expression) {
+		if _, ok := unwrapAllocation(value).(*ast.FunctionLiteral); ok {
+			functions[name] = idx
+		}
+	}
+
+	for _, statement := range program.Body {
+		switch statement := statement.(type) {
+		case *ast.FunctionStatement:
+			if name := statement.Function.Name; name != nil {
+				functions[name.Name] = name.Idx
+			}
+		case *ast.VariableStatement:
+			for _, expression := range statement.List {
+				if variable, ok := expression.(*ast.VariableExpression); ok {
+					define(variable.Name, variable.Idx, variable.Initializer)
+				}
+			}
+		case *ast.ExpressionStatement:
+			if assign, ok := statement.Expression.(*ast.AssignExpression); ok {
+				if identifier, ok := assign.Left.(*ast.Identifier); ok {
+					define(identifier.Name, identifier.Idx, assign.Right)
+				}
+			}
+		}
+	}
+
+	return functions
+}
+
+// isHookName reports whether name is a known hook
+func isHookName(name string) bool {
+	for _, hook := range HookNames {
+		if name == hook {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,210 @@
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestHookDispatch(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 5000, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+
+	scripts := map[string]string{
+		"retainer": `
+			function onPcapRetained(name, event) {
+				if (event.type === "retained" && typeof event.size === "number") {
+					pcap.retain("seen-" + name, 60);
+				}
+			}
+			var onItemCaptured = function(item) {
+				if (item.src.port === 8080 && item.status === 500 && item.timestamp === 1000 && item.request.headers.length === 2) {
+					pcap.retain("item-" + item.id + ".pcap", 60);
+				}
+			};
+			function onSomethingElse() {}
+		`,
+		"broken": `
+			onPcapRetained = function(name) { throw new Error("broken hook") };
+		`,
+	}
+	for title, script := range scripts {
+		if _, err := service.ExecuteScriptAs(title, script); err != nil {
+			t.Fatalf("Failed to execute script %s: %v", title, err)
+		}
+	}
+
+	if hooks := service.hooks.Hooks("retainer"); !reflect.DeepEqual(hooks, []string{HookItemCaptured, HookPcapRetained}) {
+		t.Errorf("Expected the retainer hooks to be discovered, got %v", hooks)
+	}
+
+	err = service.Dispatch(context.Background(), PcapLifecycleEvent{Event: worker.PcapEvent{Type: worker.PcapRetained, Name: "a.pcap", Size: 10}})
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || hookErr.Title != "broken" || hookErr.Hook != HookPcapRetained {
+		t.Errorf("Expected the broken hook to fail alone, got %v", err)
+	}
+	if !service.IsRetained("seen-a.pcap") {
+		t.Errorf("Expected the retainer hook to run despite the broken one")
+	}
+
+	item := CapturedItem{
+		ID:        "42",
+		Protocol:  "http",
+		Timestamp: time.UnixMilli(1000),
+		Src:       ItemEndpoint{IP: "10.0.0.1", Port: 8080},
+		Status:    500,
+		Request:   map[string]interface{}{"headers": []string{"a", "b"}},
+	}
+	if err := service.Dispatch(context.Background(), ItemCapturedEvent{Item: item}); err != nil {
+		t.Errorf("Failed to dispatch item: %v", err)
+	}
+	if !service.IsRetained("item-42.pcap") {
+		t.Errorf("Expected the item hook to receive typed fields")
+	}
+
+	// Unloaded scripts receive no more events
+	service.UnloadScript("broken")
+	if err := service.Dispatch(context.Background(), PcapLifecycleEvent{Event: worker.PcapEvent{Type: worker.PcapRetained, Name: "b.pcap"}}); err != nil {
+		t.Errorf("Expected no failure after unloading the broken script, got %v", err)
+	}
+}
+
+func TestHooksKeepScriptGlobals(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 5000, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+
+	script := `
+		var loads = (typeof loads === "number" ? loads : 0) + 1;
+		var seen = 0;
+		function onPcapDeleted(name) {
+			seen++;
+			if (loads !== 1) throw new Error("the top level ran " + loads + " times");
+		}
+		function count() { return seen }
+	`
+	if _, err := service.ExecuteScriptAs("counter", script); err != nil {
+		t.Fatalf("Failed to execute script: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := service.Dispatch(context.Background(), PcapLifecycleEvent{Event: worker.PcapEvent{Type: worker.PcapDeleted, Name: "a.pcap"}}); err != nil {
+			t.Fatalf("Dispatch %d failed: %v", i, err)
+		}
+	}
+
+	var seen string
+	err = service.hooks.withEngine("counter", func(engine *ScriptEngine) error {
+		seen, err = engine.Execute("count()")
+		return err
+	})
+	if err != nil || seen != "3" {
+		t.Errorf("Expected the hook to count 3 events in the script's globals, got %q, %v", seen, err)
+	}
+}
+
+func TestPcapEventsDispatchInOrder(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 5000, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+	if _, err := service.ExecuteScriptAs("slow", `function onPcapRetained(name) { sleep(20) }`); err != nil {
+		t.Fatalf("Failed to execute script: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.ServePcapEvents(ctx)
+	time.Sleep(20 * time.Millisecond)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if err := manager.RetainPcap(fmt.Sprintf("%d.pcap", i), time.Hour); err != nil {
+			t.Fatalf("RetainPcap failed: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Dispatching one event at a time, the slow hook doesn't pile up goroutines
+	if after := runtime.NumGoroutine(); after > before+10 {
+		t.Errorf("Expected the events to wait in the subscription, goroutines grew from %d to %d", before, after)
+	}
+}
+
+func TestLoadedScriptsFollowConfiguration(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 5000, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+
+	script := `
+		function onPcapDeleted(name) {
+			pcap.isRetained(name);
+			pcap.isRetained(name);
+			if (typeof fs !== "undefined") fs.write("deleted.txt", name);
+		}
+	`
+	if _, err := service.ExecuteScriptAs("configured", script); err != nil {
+		t.Fatalf("Failed to execute script: %v", err)
+	}
+	event := PcapLifecycleEvent{Event: worker.PcapEvent{Type: worker.PcapDeleted, Name: "a.pcap"}}
+
+	// The budget and the bindings set after the script loaded apply to its hooks
+	service.SetBudget(ScriptBudget{MaxBindingCalls: 1})
+	if err := service.Dispatch(context.Background(), event); !errors.Is(err, ErrBindingCallBudgetExceeded) {
+		t.Errorf("Expected the new budget to apply to the hook, got %v", err)
+	}
+	service.SetBudget(ScriptBudget{})
+
+	dir := t.TempDir()
+	sandbox, err := NewFileSandbox(dir, 0)
+	if err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+	if err := service.SetFileSystem(sandbox); err != nil {
+		t.Fatalf("Failed to set file system: %v", err)
+	}
+	if err := service.Dispatch(context.Background(), event); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "deleted.txt")); err != nil || string(data) != "a.pcap" {
+		t.Errorf("Expected the hook to get the fs binding, got %q, %v", data, err)
+	}
+}
+
+func TestHookCallsAreBounded(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 5000, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+	for _, title := range []string{"first", "second"} {
+		if _, err := service.ExecuteScriptAs(title, `function onPcapDeleted(name) { sleep(50) }`); err != nil {
+			t.Fatalf("Failed to execute script %s: %v", title, err)
+		}
+	}
+
+	// With a single slot, the hooks of both scripts run one after the other
+	start := time.Now()
+	if err := service.Dispatch(context.Background(), PcapLifecycleEvent{Event: worker.PcapEvent{Type: worker.PcapDeleted, Name: "a.pcap"}}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the hooks to wait for the slot, dispatch took %v", elapsed)
+	}
+}
@@ -0,0 +1,57 @@
+package scripting
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// registerHTTPBindings registers the http object. Requests are cancelled at deadline, which
+// returns the end of the current execution, so they count against the script timeout.
+func registerHTTPBindings(vm *otto.Otto, client *HTTPClient, deadline func() time.Time, meter *budgetMeter) error {
+	httpObj, err := vm.Object("http = {}")
+	if err != nil {
+		return err
+	}
+
+	// Register request function
+	err = httpObj.Set("request", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("http.request")
+		options := call.Argument(0)
+		request := HTTPRequest{
+			Method:  optionString(options, "method"),
+			URL:     optionString(options, "url"),
+			Body:    optionString(options, "body"),
+			Timeout: time.Duration(optionInt(options, "timeout")) * time.Millisecond,
+		}
+		if options.IsObject() {
+			if headers, err := options.Object().Get("headers"); err == nil && headers.IsObject() {
+				request.Headers = make(map[string]string)
+				for _, key := range headers.Object().Keys() {
+					value, _ := headers.Object().Get(key)
+					request.Headers[key] = value.String()
+				}
+			}
+		}
+
+		ctx, cancel := context.WithDeadline(context.Background(), deadline())
+		defer cancel()
+
+		response, err := client.Do(ctx, request)
+		if err != nil {
+			if errors.Is(err, ErrHostNotAllowed) {
+				throwError(call, "NetworkPolicyError", err)
+			}
+			throwError(call, "Error", err)
+		}
+
+		return toJSValue(call, response)
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kubeshark/kubeshark/utils"
+)
+
+var (
+	// ErrHostNotAllowed is returned for requests to hosts that are not on the network allowlist
+	ErrHostNotAllowed = errors.New("host is not on the network allowlist")
+	// ErrResponseTooLarge is returned when a response body exceeds the maximum response size
+	ErrResponseTooLarge = errors.New("response exceeds the maximum size")
+)
+
+// maxRedirects is the number of redirects a script request follows
+const maxRedirects = 10
+
+// HTTPRequest is a request made by a script
+type HTTPRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    string
+	Timeout time.Duration
+}
+
+// HTTPResponse is the response given to a script
+type HTTPResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// HTTPClient makes the requests of scripts to the hosts of an allowlist. Allowlist entries
+// are host names, "*.domain" wildcards, IP addresses or CIDRs. A host allowed by name is
+// dialed as is, any other host must resolve to an allowed address, which is then dialed,
+// so redirects and DNS answers can't lead to other addresses.
+type HTTPClient struct {
+	names           []string
+	networks        []*net.IPNet
+	maxResponseSize int64
+	client          *http.Client
+}
+
+// NewHTTPClient creates a client for the allowlist. Responses larger than maxResponseSize
+// bytes are refused, a size of zero or less leaves them unlimited.
+func NewHTTPClient(allowlist []string, maxResponseSize int64) (*HTTPClient, error) {
+	c := &HTTPClient{maxResponseSize: maxResponseSize}
+	for _, entry := range allowlist {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			c.networks = append(c.networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			c.networks = append(c.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if strings.ContainsAny(entry, "/:") {
+			return nil, fmt.Errorf("invalid network allowlist entry %q", entry)
+		}
+		c.names = append(c.names, entry)
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if c.allowsName(host) {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if c.allowsIP(ip.IP) {
+				return dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+			}
+		}
+
+		return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+
+	c.client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+
+	return c, nil
+}
+
+// Do makes a request. It fails once ctx is done, or after the request timeout, if set.
+func (c *HTTPClient) Do(ctx context.Context, request HTTPRequest) (HTTPResponse, error) {
+	target, err := url.Parse(request.URL)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return HTTPResponse{}, fmt.Errorf("unsupported URL scheme %q", target.Scheme)
+	}
+
+	if request.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, request.Timeout)
+		defer cancel()
+	}
+
+	method := strings.ToUpper(request.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if request.Body != "" {
+		body = strings.NewReader(request.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	for key, value := range request.Headers {
+		req.Header.Set(key, value)
+	}
+	// Kubeshark must not capture its own traffic
+	utils.AddIgnoreCaptureHeader(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	reader := io.Reader(resp.Body)
+	if c.maxResponseSize > 0 {
+		reader = io.LimitReader(resp.Body, c.maxResponseSize+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	if c.maxResponseSize > 0 && int64(len(data)) > c.maxResponseSize {
+		return HTTPResponse{}, fmt.Errorf("%w of %d bytes", ErrResponseTooLarge, c.maxResponseSize)
+	}
+
+	headers := make(map[string]string, len(resp.Header))
+	for key := range resp.Header {
+		headers[strings.ToLower(key)] = resp.Header.Get(key)
+	}
+
+	return HTTPResponse{
+		Status:  resp.StatusCode,
+		Headers: headers,
+		Body:    string(data),
+	}, nil
+}
+
+// allowsName reports whether a host name is on the allowlist
+func (c *HTTPClient) allowsName(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, name := range c.names {
+		if suffix, ok := strings.CutPrefix(name, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsIP reports whether an address is on the allowlist
+func (c *HTTPClient) allowsIP(ip net.IP) bool {
+	for _, network := range c.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+package scripting
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+	"github.com/kubeshark/kubeshark/utils"
+)
+
+func TestHTTPBinding(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/large":
+			fmt.Fprint(w, strings.Repeat("x", 2048))
+		default:
+			w.Header().Set("X-Method", r.Method)
+			fmt.Fprint(w, r.Header.Get(utils.X_KUBESHARK_CAPTURE_HEADER_KEY)+" "+r.Header.Get("X-Test"))
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	engine, err := NewScriptEngine(NewPcapHelper(manager), 500)
+	if err != nil {
+		t.Fatalf("Failed to create script engine: %v", err)
+	}
+
+	if result, err := engine.Execute("typeof http"); err != nil || result != "undefined" {
+		t.Errorf("Expected no http binding by default, got %v, %v", result, err)
+	}
+
+	client, err := NewHTTPClient([]string{"127.0.0.0/8"}, 1024)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP client: %v", err)
+	}
+	if err := engine.SetNetwork(client); err != nil {
+		t.Fatalf("Failed to set network: %v", err)
+	}
+
+	script := fmt.Sprintf(`
+		var r = http.request({method: "post", url: "%s/echo", headers: {"X-Test": "yes"}, body: "{}"});
+		var large;
+		try { http.request({url: "%[1]s/large"}) } catch (e) { large = e.message }
+		[r.status, r.headers["x-method"], r.body, !!large].join(" ")
+	`, server.URL)
+	result, err := engine.Execute(script)
+	if err != nil {
+		t.Fatalf("Script failed: %v", err)
+	}
+	if expected := "200 POST ignore yes true"; result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	// Requests are cancelled with the script
+	start := time.Now()
+	if _, err := engine.Execute(fmt.Sprintf(`http.request({url: "%s/slow"})`, server.URL)); err == nil {
+		t.Errorf("Expected the slow request to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the request to stop at the script timeout, took %v", elapsed)
+	}
+
+	// Hosts off the allowlist are refused
+	client, err = NewHTTPClient([]string{"10.0.0.0/8", "*.example.com"}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP client: %v", err)
+	}
+	if err := engine.SetNetwork(client); err != nil {
+		t.Fatalf("Failed to set network: %v", err)
+	}
+	result, err = engine.Execute(fmt.Sprintf(`try { http.request({url: "%s"}) } catch (e) { e.name }`, server.URL))
+	if err != nil || result != "NetworkPolicyError" {
+		t.Errorf("Expected NetworkPolicyError, got %v, %v", result, err)
+	}
+}
@@ -0,0 +1,317 @@
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robertkrimen/otto"
+	"github.com/rs/zerolog/log"
+)
+
+// JobInfo describes a scheduled job
+type JobInfo struct {
+	Script   string    `json:"script"`
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Next     time.Time `json:"next"`
+	LastRun  time.Time `json:"lastRun,omitempty"`
+	LastErr  string    `json:"lastError,omitempty"`
+	Runs     int       `json:"runs"`
+	Skipped  int       `json:"skipped"`
+	Running  bool      `json:"running"`
+}
+
+// JobScheduler runs the jobs that scripts schedule with jobs.schedule. A job runs on the
+// engine of its script in the registry, so it shares the script's globals and is subject to
+// the engine's timeout and budget. An activation that comes while the job still runs is skipped.
+type JobScheduler struct {
+	registry *HookRegistry
+	jobs     map[jobKey]*scheduledJob
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+}
+
+// jobKey identifies a job by its script and name
+type jobKey struct {
+	script string
+	name   string
+}
+
+// scheduledJob is a job and its state
+type scheduledJob struct {
+	JobInfo
+	schedule *cronSchedule
+	timer    *time.Timer
+}
+
+// NewJobScheduler creates a scheduler running jobs on the engines of the scripts of registry.
+// Jobs stop when Stop is called.
+func NewJobScheduler(registry *HookRegistry) *JobScheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobScheduler{
+		registry: registry,
+		jobs:     make(map[jobKey]*scheduledJob),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Replace sets the jobs of a script, as scheduled by its latest execution. Jobs whose
+// schedule didn't change keep their state, the other jobs of the script are cancelled.
+func (s *JobScheduler) Replace(script string, schedules map[string]string) error {
+	parsed := make(map[string]*cronSchedule, len(schedules))
+	for name, expr := range schedules {
+		schedule, err := parseCron(expr)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+		parsed[name] = schedule
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, job := range s.jobs {
+		if key.script == script && schedules[key.name] != job.Schedule {
+			job.stop()
+			delete(s.jobs, key)
+		}
+	}
+
+	now := time.Now()
+	for name, schedule := range parsed {
+		key := jobKey{script, name}
+		if _, ok := s.jobs[key]; ok {
+			continue
+		}
+		job := &scheduledJob{
+			JobInfo:  JobInfo{Script: script, Name: name, Schedule: schedules[name]},
+			schedule: schedule,
+		}
+		s.jobs[key] = job
+		s.arm(key, job, now)
+	}
+
+	return nil
+}
+
+// Cancel removes a job and reports whether it existed. A running job finishes its run.
+func (s *JobScheduler) Cancel(script string, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := jobKey{script, name}
+	job, ok := s.jobs[key]
+	if ok {
+		job.stop()
+		delete(s.jobs, key)
+	}
+
+	return ok
+}
+
+// RemoveScript cancels every job of a script
+func (s *JobScheduler) RemoveScript(script string) {
+	if err := s.Replace(script, nil); err != nil {
+		log.Error().Err(err).Str("script", script).Msg("Failed to remove the jobs of the script")
+	}
+}
+
+// List returns the jobs of a script, or of all scripts if script is empty, sorted by name
+func (s *JobScheduler) List(script string) []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]JobInfo, 0, len(s.jobs))
+	for key, job := range s.jobs {
+		if script == "" || key.script == script {
+			jobs = append(jobs, job.JobInfo)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Script != jobs[j].Script {
+			return jobs[i].Script < jobs[j].Script
+		}
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	return jobs
+}
+
+// Stop cancels all jobs. Runs in progress finish, queued runs are abandoned.
+func (s *JobScheduler) Stop() {
+	s.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, job := range s.jobs {
+		job.stop()
+		delete(s.jobs, key)
+	}
+}
+
+// arm starts the timer of the job's next activation, s.mu must be held
+func (s *JobScheduler) arm(key jobKey, job *scheduledJob, now time.Time) {
+	job.Next = job.schedule.next(now)
+	if job.Next.IsZero() {
+		log.Warn().Str("script", key.script).Str("job", key.name).Msg("The job's schedule never activates")
+		return
+	}
+
+	job.timer = time.AfterFunc(job.Next.Sub(now), func() { s.fire(key, job) })
+}
+
+// stop stops the timer of the job's next activation
+func (j *scheduledJob) stop() {
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+}
+
+// fire runs a job unless its previous run is still going, and arms its next activation
+func (s *JobScheduler) fire(key jobKey, job *scheduledJob) {
+	s.mu.Lock()
+	if s.jobs[key] != job || s.ctx.Err() != nil {
+		// The job was cancelled or replaced
+		s.mu.Unlock()
+		return
+	}
+	s.arm(key, job, time.Now())
+	if job.Running {
+		job.Skipped++
+		s.mu.Unlock()
+		log.Warn().Str("script", key.script).Str("job", key.name).Msg("Skipping a job activation, the previous run is still going")
+		return
+	}
+	job.Running = true
+	s.mu.Unlock()
+
+	err := s.run(key)
+
+	s.mu.Lock()
+	job.Running = false
+	job.LastRun = time.Now()
+	job.Runs++
+	job.LastErr = ""
+	if err != nil {
+		job.LastErr = err.Error()
+	}
+	s.mu.Unlock()
+
+	if err != nil && s.ctx.Err() == nil {
+		log.Error().Err(err).Str("script", key.script).Str("job", key.name).Msg("Script job failed")
+	}
+}
+
+// run calls the job function on the engine of the job's script
+func (s *JobScheduler) run(key jobKey) error {
+	return s.registry.withEngine(key.script, func(engine *ScriptEngine) error {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		return engine.RunJob(key.name)
+	})
+}
+
+// jobDefinitions collects the jobs a script schedules while it runs on an engine
+type jobDefinitions struct {
+	schedules map[string]string
+	functions map[string]otto.Value
+}
+
+// newJobDefinitions creates an empty set of job definitions
+func newJobDefinitions() *jobDefinitions {
+	return &jobDefinitions{
+		schedules: make(map[string]string),
+		functions: make(map[string]otto.Value),
+	}
+}
+
+// errUnknownJob is returned when a job's function is not defined by its script
+var errUnknownJob = errors.New("the script doesn't schedule the job")
+
+// registerJobBindings registers the jobs object. Scheduled jobs are collected in definitions,
+// and handed to the scheduler once the script ran. list and cancel act on the scheduler's
+// jobs of the script, if there is a scheduler.
+func registerJobBindings(vm *otto.Otto, definitions *jobDefinitions, scheduler *JobScheduler, script string, meter *budgetMeter) error {
+	jobsObj, err := vm.Object("jobs = {}")
+	if err != nil {
+		return err
+	}
+
+	// Register schedule function
+	err = jobsObj.Set("schedule", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("jobs.schedule")
+		name := call.Argument(0).String()
+		expr := call.Argument(1).String()
+		fn := call.Argument(2)
+		if name == "" || !fn.IsFunction() {
+			throwError(call, "TypeError", errors.New("jobs.schedule requires a name, a cron expression and a function"))
+		}
+		if _, err := parseCron(expr); err != nil {
+			throwError(call, "Error", err)
+		}
+
+		definitions.schedules[name] = expr
+		definitions.functions[name] = fn
+		return otto.UndefinedValue()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Register list function
+	err = jobsObj.Set("list", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("jobs.list")
+		jobs := []map[string]interface{}{}
+		if scheduler != nil {
+			for _, job := range scheduler.List(script) {
+				jobs = append(jobs, map[string]interface{}{
+					"name":     job.Name,
+					"schedule": job.Schedule,
+					"next":     unixMilli(job.Next),
+					"lastRun":  unixMilli(job.LastRun),
+					"runs":     job.Runs,
+					"running":  job.Running,
+				})
+			}
+		}
+		return toJSValue(call, jobs)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Register cancel function
+	err = jobsObj.Set("cancel", func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("jobs.cancel")
+		name := call.Argument(0).String()
+		_, defined := definitions.schedules[name]
+		delete(definitions.schedules, name)
+		delete(definitions.functions, name)
+		cancelled := scheduler != nil && scheduler.Cancel(script, name)
+
+		result, _ := call.Otto.ToValue(defined || cancelled)
+		return result
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unixMilli returns a time in milliseconds since the epoch, or 0 for the zero time
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
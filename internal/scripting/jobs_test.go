@@ -0,0 +1,85 @@
+package scripting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 23, 58, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"*/5 * * * *", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"59 23 * * *", time.Date(2024, time.January, 31, 23, 59, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, time.February, 1, 9, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 13 * 5", time.Date(2024, time.February, 2, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", start.Add(90 * time.Second)},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.expr, err)
+			continue
+		}
+		if next := schedule.next(start); !next.Equal(test.next) {
+			t.Errorf("%q: expected %v, got %v", test.expr, test.next, next)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "a * * * *", "@every 10ms", "5-1 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestScheduledJobs(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 5000, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+	defer service.Close()
+
+	script := `
+		jobs.schedule("slow", "@every 1s", function() { sleep(1500) });
+		jobs.schedule("daily", "@daily", function() {});
+		jobs.cancel("daily");
+		jobs.list().length
+	`
+	if _, err := service.ExecuteScriptAs("summary", script); err != nil {
+		t.Fatalf("Failed to execute script: %v", err)
+	}
+	if _, err := service.ExecuteScript(`jobs.schedule("bad", "not cron", function() {})`); err == nil {
+		t.Errorf("Expected an invalid cron expression to fail")
+	}
+
+	jobs := service.Jobs()
+	if len(jobs) != 1 || jobs[0].Script != "summary" || jobs[0].Name != "slow" {
+		t.Fatalf("Expected the slow job to be scheduled, got %+v", jobs)
+	}
+
+	// Activations during a run are skipped rather than overlapping
+	time.Sleep(3500 * time.Millisecond)
+	jobs = service.Jobs()
+	if len(jobs) != 1 || jobs[0].Runs < 1 || jobs[0].Skipped < 1 || jobs[0].LastErr != "" {
+		t.Errorf("Expected runs and skipped activations without errors, got %+v", jobs)
+	}
+
+	// Listing from a script sees the scheduler's jobs
+	if result, err := service.ExecuteScriptAs("summary", script); err != nil || result != "1" {
+		t.Errorf("Expected the script to list its job, got %v, %v", result, err)
+	}
+
+	service.UnloadScript("summary")
+	if jobs := service.Jobs(); len(jobs) != 0 {
+		t.Errorf("Expected the jobs to be removed with the script, got %+v", jobs)
+	}
+}
@@ -0,0 +1,368 @@
+package scripting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrStoreQuotaExceeded is returned when a write would grow a script's namespace beyond its byte quota
+	ErrStoreQuotaExceeded = errors.New("script store quota exceeded")
+	// ErrStoreNotNumber is returned when incrementing a key whose value is not a number
+	ErrStoreNotNumber = errors.New("store value is not a number")
+)
+
+// ScriptStore is the key-value store behind the store binding. Each script has its own
+// namespace, persisted as JSON in a file of the store directory and limited to a byte quota.
+// The operations on a namespace are serialized, so increments from the engines of the pool
+// don't race, while the namespaces of different scripts are used concurrently.
+type ScriptStore struct {
+	dir        string
+	maxBytes   int64
+	namespaces map[string]*lockedNamespace
+	now        func() time.Time
+	// mu guards namespaces, each namespace has its own lock
+	mu sync.Mutex
+}
+
+// lockedNamespace is a namespace and the lock serializing its operations. Its entries are
+// nil until they are loaded.
+type lockedNamespace struct {
+	entries storeNamespace
+	mu      sync.Mutex
+}
+
+// storeEntry is a value of the store in its JSON form, and when it expires
+type storeEntry struct {
+	Value   json.RawMessage `json:"value"`
+	Expires time.Time       `json:"expires"`
+}
+
+// expired reports whether the entry has an expiry that passed at now
+func (e storeEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// storeNamespace holds the entries of a script by key
+type storeNamespace map[string]storeEntry
+
+// size returns the bytes the namespace counts against the quota, its keys and values
+func (n storeNamespace) size() int64 {
+	var size int64
+	for key, entry := range n {
+		size += int64(len(key) + len(entry.Value))
+	}
+
+	return size
+}
+
+// purge removes the entries that expired at now and reports whether there were any
+func (n storeNamespace) purge(now time.Time) bool {
+	purged := false
+	for key, entry := range n {
+		if entry.expired(now) {
+			delete(n, key)
+			purged = true
+		}
+	}
+
+	return purged
+}
+
+// NewScriptStore creates a store persisting the namespaces in dir, creating it if needed.
+// An empty dir keeps the store in memory. A maxBytes of zero or less leaves the namespaces
+// without a quota.
+func NewScriptStore(dir string, maxBytes int64) (*ScriptStore, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create store directory %s: %w", dir, err)
+		}
+	}
+
+	return &ScriptStore{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		namespaces: make(map[string]*lockedNamespace),
+		now:        time.Now,
+	}, nil
+}
+
+// Get returns the JSON value of a key of the script's namespace, if it exists
+func (s *ScriptStore) Get(script string, key string) (json.RawMessage, bool, error) {
+	namespace, unlock, err := s.namespace(script)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	entry, exists := namespace[key]
+	if !exists || entry.expired(s.now()) {
+		return nil, false, nil
+	}
+
+	return entry.Value, true, nil
+}
+
+// Set stores the JSON value of a key, expiring after ttl, or never if ttl is zero or less
+func (s *ScriptStore) Set(script string, key string, value json.RawMessage, ttl time.Duration) error {
+	namespace, unlock, err := s.namespace(script)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.put(script, namespace, key, storeEntry{Value: value, Expires: s.expiry(ttl)})
+}
+
+// Incr adds delta to the number stored at key and returns the result. A missing key counts
+// as 0, and is created to expire after ttl, if ttl is positive. An existing key keeps its expiry.
+func (s *ScriptStore) Incr(script string, key string, delta float64, ttl time.Duration) (float64, error) {
+	namespace, unlock, err := s.namespace(script)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	entry, exists := namespace[key]
+	if !exists || entry.expired(s.now()) {
+		entry = storeEntry{Value: json.RawMessage("0"), Expires: s.expiry(ttl)}
+	}
+
+	var value float64
+	if err := json.Unmarshal(entry.Value, &value); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrStoreNotNumber, key)
+	}
+	value += delta
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	entry.Value = data
+
+	if err := s.put(script, namespace, key, entry); err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Delete removes a key and reports whether it existed
+func (s *ScriptStore) Delete(script string, key string) (bool, error) {
+	namespace, unlock, err := s.namespace(script)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	previous, exists := namespace[key]
+	if !exists {
+		return false, nil
+	}
+
+	delete(namespace, key)
+	if err := s.save(script, namespace); err != nil {
+		namespace[key] = previous
+		return false, err
+	}
+
+	return !previous.expired(s.now()), nil
+}
+
+// TTL returns the time left before a key expires, zero if it never does, and whether it exists
+func (s *ScriptStore) TTL(script string, key string) (time.Duration, bool, error) {
+	namespace, unlock, err := s.namespace(script)
+	if err != nil {
+		return 0, false, err
+	}
+	defer unlock()
+
+	now := s.now()
+	entry, exists := namespace[key]
+	if !exists || entry.expired(now) {
+		return 0, false, nil
+	}
+	if entry.Expires.IsZero() {
+		return 0, true, nil
+	}
+
+	return entry.Expires.Sub(now), true, nil
+}
+
+// Expire makes a key expire after ttl, or never if ttl is zero or less, and reports whether it exists
+func (s *ScriptStore) Expire(script string, key string, ttl time.Duration) (bool, error) {
+	namespace, unlock, err := s.namespace(script)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	entry, exists := namespace[key]
+	if !exists || entry.expired(s.now()) {
+		return false, nil
+	}
+
+	entry.Expires = s.expiry(ttl)
+	if err := s.put(script, namespace, key, entry); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// expiry returns when an entry written now with ttl expires, the zero time if it doesn't
+func (s *ScriptStore) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return s.now().Add(ttl)
+}
+
+// namespace locks the namespace of a script and returns its entries, loading them from its
+// file the first time. The namespace stays locked until unlock is called.
+func (s *ScriptStore) namespace(script string) (storeNamespace, func(), error) {
+	s.mu.Lock()
+	locked, ok := s.namespaces[script]
+	if !ok {
+		locked = &lockedNamespace{}
+		s.namespaces[script] = locked
+	}
+	s.mu.Unlock()
+
+	locked.mu.Lock()
+	if locked.entries == nil {
+		entries, err := s.load(script)
+		if err != nil {
+			locked.mu.Unlock()
+			return nil, nil, err
+		}
+		locked.entries = entries
+	}
+
+	return locked.entries, locked.mu.Unlock, nil
+}
+
+// load reads the entries of a script from its file
+func (s *ScriptStore) load(script string) (storeNamespace, error) {
+	namespace := make(storeNamespace)
+	if s.dir != "" {
+		data, err := os.ReadFile(s.path(script))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read the store of script %q: %w", script, err)
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &namespace); err != nil {
+				return nil, fmt.Errorf("failed to parse the store of script %q: %w", script, err)
+			}
+		}
+	}
+	namespace.purge(s.now())
+
+	return namespace, nil
+}
+
+// put writes an entry within the quota and saves the namespace, which must be locked
+func (s *ScriptStore) put(script string, namespace storeNamespace, key string, entry storeEntry) error {
+	// Expired entries don't count against the quota
+	namespace.purge(s.now())
+
+	previous, existed := namespace[key]
+	size := namespace.size() + int64(len(key)+len(entry.Value))
+	if existed {
+		size -= int64(len(key) + len(previous.Value))
+	}
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return fmt.Errorf("%w: %s would hold %d bytes, the quota is %d", ErrStoreQuotaExceeded, script, size, s.maxBytes)
+	}
+
+	namespace[key] = entry
+	if err := s.save(script, namespace); err != nil {
+		if existed {
+			namespace[key] = previous
+		} else {
+			delete(namespace, key)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// save atomically and durably replaces the file of a namespace, or removes it once the
+// namespace is empty. The file is synced before the rename, and the directory around it.
+func (s *ScriptStore) save(script string, namespace storeNamespace) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	path := s.path(script)
+	if len(namespace) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove store file: %w", err)
+		}
+		syncDir(s.dir)
+		return nil
+	}
+
+	data, err := json.Marshal(namespace)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := writeSynced(tmpPath, data); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write store file: %w", err)
+	}
+	syncDir(s.dir)
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace store file: %w", err)
+	}
+	syncDir(s.dir)
+
+	return nil
+}
+
+// writeSynced writes data to a file and syncs it to disk
+func writeSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	return errors.Join(err, file.Close())
+}
+
+// syncDir syncs a directory so a rename in it survives a crash
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		log.Debug().Err(err).Str("dir", dir).Msg("Failed to sync directory")
+	}
+}
+
+// path returns the file of a script's namespace. Titles are escaped, so they can't leave the directory.
+func (s *ScriptStore) path(script string) string {
+	return filepath.Join(s.dir, "script-"+url.PathEscape(script)+".json")
+}
@@ -0,0 +1,121 @@
+package scripting
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestScriptStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewScriptStore(dir, 32)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	if err := store.Set("a/b", "seen", []byte(`{"x":1}`), 0); err != nil {
+		t.Fatalf("Failed to set: %v", err)
+	}
+	if _, err := store.Incr("a/b", "count", 2, time.Minute); err != nil {
+		t.Fatalf("Failed to increment: %v", err)
+	}
+	if _, exists, _ := store.Get("other", "seen"); exists {
+		t.Errorf("Expected the namespaces of scripts to be separate")
+	}
+	if err := store.Set("a/b", "big", []byte(`"0123456789012345678901234567890"`), 0); !errors.Is(err, ErrStoreQuotaExceeded) {
+		t.Errorf("Expected %v, got %v", ErrStoreQuotaExceeded, err)
+	}
+	if _, err := store.Incr("a/b", "seen", 1, 0); !errors.Is(err, ErrStoreNotNumber) {
+		t.Errorf("Expected %v, got %v", ErrStoreNotNumber, err)
+	}
+
+	// The namespace survives a restart, expiring on schedule
+	store, err = NewScriptStore(dir, 32)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	store.now = func() time.Time { return now }
+	if value, _, _ := store.Get("a/b", "seen"); string(value) != `{"x":1}` {
+		t.Errorf("Expected the value to be persisted, got %s", value)
+	}
+	if ttl, exists, _ := store.TTL("a/b", "count"); !exists || ttl != time.Minute {
+		t.Errorf("Expected the count to expire in a minute, got %v, %v", ttl, exists)
+	}
+	now = now.Add(time.Minute)
+	if _, exists, _ := store.Get("a/b", "count"); exists {
+		t.Errorf("Expected the count to expire")
+	}
+	if value, _ := store.Incr("a/b", "count", 1, 0); value != 1 {
+		t.Errorf("Expected an expired count to restart, got %v", value)
+	}
+
+	// A busy namespace doesn't hold up the others
+	_, unlock, err := store.namespace("a/b")
+	if err != nil {
+		t.Fatalf("Failed to lock namespace: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- store.Set("other", "k", []byte("1"), 0) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Failed to set in another namespace: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected another namespace not to wait for a locked one")
+	}
+	unlock()
+}
+
+func TestStoreBindingsConcurrentIncr(t *testing.T) {
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingService(manager, 5000, 4, 0)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+	defer service.Close()
+	store, err := NewScriptStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if err := service.SetStore(store); err != nil {
+		t.Fatalf("Failed to set store: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.ExecuteScriptAs("counter", `for (var i = 0; i < 10; i++) { store.incr("hits") }`); err != nil {
+				t.Errorf("Failed to execute script: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	script := `
+		store.set("last", {status: 500, path: "/a"}, {ttl: 60});
+		var last = store.get("last");
+		[store.get("hits"), last.status, store.ttl("last"), store.ttl("hits"), store.get("missing"), store.delete("last"), store.delete("last")].join(",")
+	`
+	result, err := service.ExecuteScriptAs("counter", script)
+	if err != nil {
+		t.Fatalf("Failed to execute script: %v", err)
+	}
+	if expected := "200,500,60,-1,,true,false"; result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	if result, _ := service.ExecuteScriptAs("other", `String(store.get("hits"))`); result != "null" {
+		t.Errorf("Expected another script not to see the counter, got %q", result)
+	}
+	if _, err := service.ExecuteScriptAs("counter", `store.set("f", function() {})`); err == nil {
+		t.Errorf("Expected storing a function to fail")
+	}
+}
@@ -0,0 +1,295 @@
+package scripting
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/robertkrimen/otto/ast"
+	"github.com/robertkrimen/otto/file"
+	"github.com/robertkrimen/otto/parser"
+	"github.com/robertkrimen/otto/token"
+)
+
+// Severities of lint findings. Scripts with errors are not uploaded.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// bindingFunctions lists the functions of the binding objects
+var bindingFunctions = map[string][]string{
+	"pcap":    {"retain", "listRetentions", "isRetained", "getPcapPath", "read", "snapshot"},
+	"console": {"log", "debug", "info", "warn", "error"},
+	"fs":      {"read", "write", "list", "stat", "copy"},
+	"http":    {"request"},
+	"jobs":    {"schedule", "list", "cancel"},
+	"store":   {"get", "set", "incr", "delete", "ttl", "expire"},
+}
+
+// es6Features are the ES6 constructs otto can't parse, matched on the line of a syntax error
+var es6Features = []struct {
+	pattern *regexp.Regexp
+	name    string
+}{
+	{regexp.MustCompile(`=>`), "arrow functions"},
+	{regexp.MustCompile(`\b(let|const)\s`), "let and const declarations"},
+	{regexp.MustCompile("`"), "template literals"},
+	{regexp.MustCompile(`\bclass\s+\w`), "classes"},
+	{regexp.MustCompile(`\.\.\.`), "spread and rest syntax"},
+	{regexp.MustCompile(`\b(async|await)\b`), "async functions"},
+	{regexp.MustCompile(`\bfor\s*\(\s*\w+(\s+\w+)?\s+of\b`), "for...of loops"},
+	{regexp.MustCompile(`\b(import|export)\b`), "modules"},
+}
+
+// hookPattern matches the names of top-level functions that look like hooks
+var hookPattern = regexp.MustCompile(`^on[A-Z]`)
+
+// LintOptions describes the runtime the scripts are checked against
+type LintOptions struct {
+	AllowFileSystem bool
+	AllowNetwork    bool
+}
+
+// LintFinding is a problem found in a script
+type LintFinding struct {
+	Position file.Position
+	Severity string
+	Rule     string
+	Message  string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s (%s)", f.Position.Filename, f.Position.Line, f.Position.Column, f.Severity, f.Message, f.Rule)
+}
+
+// LintScript checks a script for problems the parser accepts but the runtime doesn't, and
+// for ES6 syntax otto can't parse. Findings are sorted by position.
+func LintScript(filename string, source string, options LintOptions) []LintFinding {
+	program, err := parser.ParseFile(nil, filename, source, 0)
+	if err != nil {
+		return syntaxFindings(filename, source, err)
+	}
+
+	l := &linter{program: program, options: options}
+	l.checkTitle(source)
+	l.checkHooks()
+	ast.Walk(l, program)
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		a, b := l.findings[i].Position, l.findings[j].Position
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+
+	return l.findings
+}
+
+// HasLintErrors reports whether any finding is an error
+func HasLintErrors(findings []LintFinding) bool {
+	for _, finding := range findings {
+		if finding.Severity == LintError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// linter walks a program and collects findings
+type linter struct {
+	program  *ast.Program
+	options  LintOptions
+	findings []LintFinding
+}
+
+// report adds a finding at idx
+func (l *linter) report(idx file.Idx, severity string, rule string, format string, args ...interface{}) {
+	position := file.Position{Filename: l.program.File.Name(), Line: 1, Column: 1}
+	if idx > 0 {
+		position = *l.program.File.Position(idx)
+	}
+
+	l.findings = append(l.findings, LintFinding{
+		Position: position,
+		Severity: severity,
+		Rule:     rule,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkTitle requires the leading comment that names the script
+func (l *linter) checkTitle(source string) {
+	trimmed := strings.TrimSpace(source)
+	if strings.HasPrefix(trimmed, "//") && strings.TrimSpace(strings.SplitN(trimmed, "\n", 2)[0][2:]) != "" {
+		return
+	}
+	if strings.HasPrefix(trimmed, "/*") && strings.Trim(strings.SplitN(trimmed[2:], "*/", 2)[0], " \t\r\n*") != "" {
+		return
+	}
+
+	l.report(0, LintWarning, "title", "the script doesn't start with a comment holding its title")
+}
+
+// checkHooks flags top-level functions named like hooks that the runtime doesn't call
+func (l *linter) checkHooks() {
+	for name, idx := range topLevelFunctions(l.program) {
+		if hookPattern.MatchString(name) && !isHookName(name) {
+			l.report(idx, LintWarning, "unknown-hook", "%s is not a hook the runtime calls, known hooks are %s", name, strings.Join(HookNames, ", "))
+		}
+	}
+}
+
+// Enter checks binding calls and loops
+func (l *linter) Enter(n ast.Node) ast.Visitor {
+	switch n := n.(type) {
+	case *ast.CallExpression:
+		l.checkBindingCall(n)
+	case *ast.WhileStatement:
+		if isConstantTrue(n.Test) {
+			l.checkLoopExit(n.Test.Idx0(), n.Body)
+		}
+	case *ast.DoWhileStatement:
+		if isConstantTrue(n.Test) {
+			l.checkLoopExit(n.Test.Idx0(), n.Body)
+		}
+	case *ast.ForStatement:
+		if n.Test == nil || isConstantTrue(n.Test) {
+			l.checkLoopExit(n.For, n.Body)
+		}
+	}
+
+	return l
+}
+
+// Exit is required by ast.Visitor
+func (l *linter) Exit(n ast.Node) {}
+
+// checkBindingCall flags calls of binding functions that don't exist or are disabled
+func (l *linter) checkBindingCall(call *ast.CallExpression) {
+	dot, ok := call.Callee.(*ast.DotExpression)
+	if !ok {
+		return
+	}
+	object, ok := dot.Left.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	functions, ok := bindingFunctions[object.Name]
+	if !ok {
+		return
+	}
+
+	name := object.Name + "." + dot.Identifier.Name
+	found := false
+	for _, function := range functions {
+		found = found || function == dot.Identifier.Name
+	}
+	if !found {
+		l.report(object.Idx, LintError, "unknown-binding", "%s is not a binding, %s has %s", name, object.Name, strings.Join(functions, ", "))
+		return
+	}
+
+	switch {
+	case object.Name == "fs" && !l.options.AllowFileSystem:
+		l.report(object.Idx, LintError, "disabled-binding", "%s needs scripting.allowFileSystem", name)
+	case object.Name == "http" && !l.options.AllowNetwork:
+		l.report(object.Idx, LintError, "disabled-binding", "%s needs scripting.allowNetwork", name)
+	}
+}
+
+// checkLoopExit flags a loop without a condition whose body never leaves it
+func (l *linter) checkLoopExit(idx file.Idx, body ast.Statement) {
+	finder := &loopExitFinder{}
+	ast.Walk(finder, body)
+	if !finder.found {
+		l.report(idx, LintError, "infinite-loop", "the loop has no condition and its body has no break, return or throw")
+	}
+}
+
+// loopExitFinder looks for a statement leaving a loop. Nested functions are skipped, and an
+// unlabelled break only counts outside nested loops and switches.
+type loopExitFinder struct {
+	depth int
+	found bool
+}
+
+// Enter looks for the statement leaving the loop
+func (f *loopExitFinder) Enter(n ast.Node) ast.Visitor {
+	switch n := n.(type) {
+	case *ast.FunctionLiteral:
+		return nil
+	case *ast.ReturnStatement, *ast.ThrowStatement:
+		f.found = true
+	case *ast.BranchStatement:
+		if n.Token == token.BREAK && (n.Label != nil || f.depth == 0) {
+			f.found = true
+		}
+	case *ast.WhileStatement, *ast.DoWhileStatement, *ast.ForStatement, *ast.ForInStatement, *ast.SwitchStatement:
+		f.depth++
+	}
+
+	return f
+}
+
+// Exit leaves nested loops and switches
+func (f *loopExitFinder) Exit(n ast.Node) {
+	switch n.(type) {
+	case *ast.WhileStatement, *ast.DoWhileStatement, *ast.ForStatement, *ast.ForInStatement, *ast.SwitchStatement:
+		f.depth--
+	}
+}
+
+// isConstantTrue reports whether a loop condition is a truthy literal
+func isConstantTrue(test ast.Expression) bool {
+	switch test := test.(type) {
+	case *ast.BooleanLiteral:
+		return test.Value
+	case *ast.NumberLiteral:
+		return test.Literal != "0"
+	}
+
+	return false
+}
+
+// syntaxFindings converts the first parse error to a finding, naming the ES6 feature on its line
+func syntaxFindings(filename string, source string, err error) []LintFinding {
+	var list parser.ErrorList
+	if !errors.As(err, &list) {
+		var single *parser.Error
+		if !errors.As(err, &single) {
+			return []LintFinding{{
+				Position: file.Position{Filename: filename, Line: 1, Column: 1},
+				Severity: LintError,
+				Rule:     "syntax",
+				Message:  err.Error(),
+			}}
+		}
+		list = parser.ErrorList{single}
+	}
+
+	// The errors after the first one are mostly caused by it
+	lines := strings.Split(source, "\n")
+	findings := make([]LintFinding, 0, 1)
+	for _, parseErr := range list[:1] {
+		finding := LintFinding{
+			Position: parseErr.Position,
+			Severity: LintError,
+			Rule:     "syntax",
+			Message:  parseErr.Message,
+		}
+		if line := parseErr.Position.Line; line > 0 && line <= len(lines) {
+			for _, feature := range es6Features {
+				if feature.pattern.MatchString(lines[line-1]) {
+					finding.Rule = "es6"
+					finding.Message = fmt.Sprintf("%s are not supported by the scripting engine (%s)", feature.name, parseErr.Message)
+					break
+				}
+			}
+		}
+		findings = append(findings, finding)
+	}
+
+	return findings
+}
@@ -0,0 +1,97 @@
+package scripting
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestLintScript(t *testing.T) {
+	source := `// Retain failed streams
+function onItemCaptured(item) {
+	pcap.retian(item.stream, 60);
+	fs.write("a.txt", "a");
+	while (true) {
+		for (;;) { break; }
+	}
+	while (true) {
+		if (pcap.isRetained("a")) { break; }
+	}
+}
+function onItemCapture(item) {}
+`
+	findings := LintScript("retain.js", source, LintOptions{AllowNetwork: true})
+
+	var got []string
+	for _, finding := range findings {
+		got = append(got, fmt.Sprintf("%s@%d:%d", finding.Rule, finding.Position.Line, finding.Position.Column))
+	}
+	expected := []string{"unknown-binding@3:2", "disabled-binding@4:2", "infinite-loop@5:9", "unknown-hook@12:10"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected findings %v, got %v", expected, findings)
+	}
+	if !HasLintErrors(findings) {
+		t.Errorf("Expected the findings to hold errors")
+	}
+
+	findings = LintScript("es6.js", "// ES6\nvar f = (a) => a;\n", LintOptions{})
+	if len(findings) != 1 || findings[0].Rule != "es6" || findings[0].Position.Line != 2 || !strings.Contains(findings[0].Message, "arrow functions") {
+		t.Errorf("Expected an arrow function finding on line 2, got %v", findings)
+	}
+
+	findings = LintScript("untitled.js", "var a = 1;\n", LintOptions{})
+	if len(findings) != 1 || findings[0].Rule != "title" || HasLintErrors(findings) {
+		t.Errorf("Expected a missing title warning, got %v", findings)
+	}
+}
+
+func TestLintBindingsMatchEngine(t *testing.T) {
+	dir := t.TempDir()
+	manager := worker.NewPcapManager(dir, 10*time.Second, 1024*1024)
+	engine, err := NewScriptEngine(NewPcapHelper(manager), 5000)
+	if err != nil {
+		t.Fatalf("Failed to create script engine: %v", err)
+	}
+	sandbox, err := NewFileSandbox(filepath.Join(dir, "sandbox"), 0)
+	if err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+	client, err := NewHTTPClient(nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP client: %v", err)
+	}
+	if err := engine.SetFileSystem(sandbox); err != nil {
+		t.Fatalf("Failed to set file system: %v", err)
+	}
+	if err := engine.SetNetwork(client); err != nil {
+		t.Fatalf("Failed to set network: %v", err)
+	}
+	store, err := NewScriptStore("", 0)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if err := engine.SetStore(store); err != nil {
+		t.Fatalf("Failed to set store: %v", err)
+	}
+
+	for object, functions := range bindingFunctions {
+		value, err := engine.vm.Get(object)
+		if err != nil || !value.IsObject() {
+			t.Errorf("Expected the engine to register %s", object)
+			continue
+		}
+		keys := value.Object().Keys()
+		sort.Strings(keys)
+		expected := append([]string(nil), functions...)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("Expected %s to have %v, the engine registers %v", object, expected, keys)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package scripting
+
+import (
+	"github.com/robertkrimen/otto"
+	"github.com/robertkrimen/otto/ast"
+	"github.com/robertkrimen/otto/parser"
+)
+
+// allocHook is the global the instrumented scripts call for each allocation, see instrumentAllocations
+const allocHook = "__kubesharkAlloc"
+
+const (
+	// allocObjectCost is the approximate size of an object, array or function, in bytes
+	allocObjectCost = 64
+	// allocSlotCost is the approximate size of an element, property or argument, in bytes
+	allocSlotCost = 16
+)
+
+// parseScript parses a script and instruments its allocations for the memory budget
+func parseScript(filename string, source string) (*ast.Program, error) {
+	program, err := parser.ParseFile(nil, filename, source, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	instrumentAllocations(program)
+	return program, nil
+}
+
+// registerAllocHook defines the read-only global the instrumented scripts report their
+// allocations to. It returns the allocated value unchanged. The hook of a VM is defined once,
+// the meter of its engine doesn't change.
+func registerAllocHook(vm *otto.Otto, meter *budgetMeter) error {
+	if defined, err := vm.Get(allocHook); err == nil && defined.IsFunction() {
+		return nil
+	}
+
+	hook := func(call otto.FunctionCall) otto.Value {
+		size, _ := call.Argument(1).ToInteger()
+		meter.allocate(size)
+		return call.Argument(0)
+	}
+
+	define, err := vm.Get("Object")
+	if err != nil {
+		return err
+	}
+	descriptor, err := vm.Object(`({writable: false, enumerable: false, configurable: false})`)
+	if err != nil {
+		return err
+	}
+	if err := descriptor.Set("value", hook); err != nil {
+		return err
+	}
+	global, err := vm.Run("this")
+	if err != nil {
+		return err
+	}
+
+	_, err = define.Object().Call("defineProperty", global, allocHook, descriptor)
+	return err
+}
+
+// instrumentAllocations wraps the object, array, function and new expressions of program
+// in calls to allocHook, with their approximate size. Values grown later, such as arrays
+// pushed to or concatenated strings, aren't accounted, so the size is a lower bound.
+func instrumentAllocations(program *ast.Program) {
+	r := &allocRewriter{visited: make(map[*ast.FunctionLiteral]bool)}
+	r.statements(program.Body)
+	r.declarations(program.DeclarationList)
+}
+
+// allocRewriter rewrites the allocations of a program in place
+type allocRewriter struct {
+	// visited holds the functions already rewritten, declarations share them with their statements
+	visited map[*ast.FunctionLiteral]bool
+}
+
+// wrap returns the call of allocHook on node, accounting slots elements
+func (r *allocRewriter) wrap(node ast.Expression, slots int) ast.Expression {
+	return &ast.CallExpression{
+		Callee:       &ast.Identifier{Name: allocHook},
+		ArgumentList: []ast.Expression{node, &ast.NumberLiteral{Value: float64(allocObjectCost + slots*allocSlotCost)}},
+	}
+}
+
+// unwrapAllocation returns the expression an allocHook call wraps, or expression itself
+func unwrapAllocation(expression ast.Expression) ast.Expression {
+	call, ok := expression.(*ast.CallExpression)
+	if !ok || len(call.ArgumentList) != 2 {
+		return expression
+	}
+	if callee, ok := call.Callee.(*ast.Identifier); !ok || callee.Name != allocHook {
+		return expression
+	}
+	return call.ArgumentList[0]
+}
+
+// function rewrites the body of a function once
+func (r *allocRewriter) function(function *ast.FunctionLiteral) {
+	if function == nil || r.visited[function] {
+		return
+	}
+	r.visited[function] = true
+
+	r.statement(function.Body)
+	r.declarations(function.DeclarationList)
+}
+
+// declarations rewrites the hoisted functions of a scope
+func (r *allocRewriter) declarations(declarations []ast.Declaration) {
+	for _, declaration := range declarations {
+		if function, ok := declaration.(*ast.FunctionDeclaration); ok {
+			r.function(function.Function)
+		}
+	}
+}
+
+// expressions rewrites a list of expressions in place
+func (r *allocRewriter) expressions(list []ast.Expression) {
+	for i := range list {
+		list[i] = r.expression(list[i])
+	}
+}
+
+// expression returns the rewritten expression
+func (r *allocRewriter) expression(expression ast.Expression) ast.Expression {
+	switch n := expression.(type) {
+	case *ast.ArrayLiteral:
+		r.expressions(n.Value)
+		return r.wrap(n, len(n.Value))
+	case *ast.ObjectLiteral:
+		for i := range n.Value {
+			// Getters and setters must stay function literals
+			if function, ok := n.Value[i].Value.(*ast.FunctionLiteral); ok && n.Value[i].Kind != "value" {
+				r.function(function)
+				continue
+			}
+			n.Value[i].Value = r.expression(n.Value[i].Value)
+		}
+		return r.wrap(n, len(n.Value))
+	case *ast.NewExpression:
+		n.Callee = r.expression(n.Callee)
+		r.expressions(n.ArgumentList)
+		return r.wrap(n, len(n.ArgumentList))
+	case *ast.FunctionLiteral:
+		r.function(n)
+		return r.wrap(n, 0)
+	case *ast.AssignExpression:
+		n.Left = r.expression(n.Left)
+		n.Right = r.expression(n.Right)
+	case *ast.BinaryExpression:
+		n.Left = r.expression(n.Left)
+		n.Right = r.expression(n.Right)
+	case *ast.BracketExpression:
+		n.Left = r.expression(n.Left)
+		n.Member = r.expression(n.Member)
+	case *ast.CallExpression:
+		n.Callee = r.expression(n.Callee)
+		r.expressions(n.ArgumentList)
+	case *ast.ConditionalExpression:
+		n.Test = r.expression(n.Test)
+		n.Consequent = r.expression(n.Consequent)
+		n.Alternate = r.expression(n.Alternate)
+	case *ast.DotExpression:
+		n.Left = r.expression(n.Left)
+	case *ast.SequenceExpression:
+		r.expressions(n.Sequence)
+	case *ast.UnaryExpression:
+		n.Operand = r.expression(n.Operand)
+	case *ast.VariableExpression:
+		n.Initializer = r.expression(n.Initializer)
+	}
+
+	return expression
+}
+
+// statements rewrites a list of statements
+func (r *allocRewriter) statements(list []ast.Statement) {
+	for _, statement := range list {
+		r.statement(statement)
+	}
+}
+
+// statement rewrites the expressions of a statement in place
+func (r *allocRewriter) statement(statement ast.Statement) {
+	switch n := statement.(type) {
+	case *ast.BlockStatement:
+		r.statements(n.List)
+	case *ast.CaseStatement:
+		n.Test = r.expression(n.Test)
+		r.statements(n.Consequent)
+	case *ast.CatchStatement:
+		r.statement(n.Body)
+	case *ast.DoWhileStatement:
+		n.Test = r.expression(n.Test)
+		r.statement(n.Body)
+	case *ast.ExpressionStatement:
+		n.Expression = r.expression(n.Expression)
+	case *ast.ForInStatement:
+		n.Into = r.expression(n.Into)
+		n.Source = r.expression(n.Source)
+		r.statement(n.Body)
+	case *ast.ForStatement:
+		n.Initializer = r.expression(n.Initializer)
+		n.Update = r.expression(n.Update)
+		n.Test = r.expression(n.Test)
+		r.statement(n.Body)
+	case *ast.FunctionStatement:
+		r.function(n.Function)
+	case *ast.IfStatement:
+		n.Test = r.expression(n.Test)
+		r.statement(n.Consequent)
+		r.statement(n.Alternate)
+	case *ast.LabelledStatement:
+		r.statement(n.Statement)
+	case *ast.ReturnStatement:
+		n.Argument = r.expression(n.Argument)
+	case *ast.SwitchStatement:
+		n.Discriminant = r.expression(n.Discriminant)
+		for _, c := range n.Body {
+			r.statement(c)
+		}
+	case *ast.ThrowStatement:
+		n.Argument = r.expression(n.Argument)
+	case *ast.TryStatement:
+		r.statement(n.Body)
+		if n.Catch != nil {
+			r.statement(n.Catch)
+		}
+		r.statement(n.Finally)
+	case *ast.VariableStatement:
+		r.expressions(n.List)
+	case *ast.WhileStatement:
+		n.Test = r.expression(n.Test)
+		r.statement(n.Body)
+	case *ast.WithStatement:
+		n.Object = r.expression(n.Object)
+		r.statement(n.Body)
+	}
+}
@@ -0,0 +1,137 @@
+package scripting
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/robertkrimen/otto"
+)
+
+// LibraryDir is the folder, next to the script folders, holding the modules scripts require
+const LibraryDir = "lib"
+
+// ErrModuleNotFound is returned when a script requires a module that isn't in the libraries
+var ErrModuleNotFound = errors.New("cannot find module")
+
+// requireSource defines require on top of the resolve and load functions. Modules run once
+// per VM, their module objects are cached by name before they run, so cyclic requires see
+// the exports defined so far. A module that throws is dropped from the cache, so requiring
+// it again runs it again instead of returning its partial exports.
+const requireSource = `(function(resolve, load) {
+	var cache = {};
+	function makeRequire(parent) {
+		return function require(name) {
+			var id = resolve(parent, name);
+			if (cache.hasOwnProperty(id)) {
+				return cache[id].exports;
+			}
+			var module = {id: id, exports: {}};
+			cache[id] = module;
+			try {
+				load(id).call(module.exports, module, module.exports, makeRequire(id));
+			} catch (e) {
+				delete cache[id];
+				throw e;
+			}
+			return module.exports;
+		};
+	}
+	return makeRequire("");
+})`
+
+// moduleWrapper wraps the code of a module in a function on its first line, so line
+// numbers of errors match the module file
+const moduleWrapper = "(function(module, exports, require) {%s\n})"
+
+// LoadLibraries reads the modules of a library folder by name. The name of a module is
+// lib/ followed by its path in the folder without the .js suffix, as scripts require it.
+func LoadLibraries(dir string) (map[string]string, error) {
+	libraries := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, ".js") {
+			return nil
+		}
+
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		code, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		libraries[ModuleName(relative)] = string(code)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library folder %s: %w", dir, err)
+	}
+
+	return libraries, nil
+}
+
+// ModuleName returns the name scripts require a module by, from its path in the library folder
+func ModuleName(relative string) string {
+	return path.Join(LibraryDir, strings.TrimSuffix(filepath.ToSlash(relative), ".js"))
+}
+
+// resolveModule returns the name of the module required as name from the module parent,
+// or from a script if parent is empty. Names starting with ./ or ../ are relative to parent.
+func resolveModule(libraries map[string]string, parent string, name string) (string, error) {
+	id := name
+	if strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+		id = path.Join(path.Dir(parent), name)
+	}
+	id = strings.TrimSuffix(path.Clean(id), ".js")
+
+	if _, ok := libraries[id]; !ok {
+		return "", fmt.Errorf("%w %q", ErrModuleNotFound, name)
+	}
+
+	return id, nil
+}
+
+// registerRequire registers require, loading the modules of libraries
+func registerRequire(vm *otto.Otto, libraries map[string]string, meter *budgetMeter) error {
+	resolve := func(call otto.FunctionCall) otto.Value {
+		meter.bindingCall("require")
+		id, err := resolveModule(libraries, call.Argument(0).String(), call.Argument(1).String())
+		if err != nil {
+			throwError(call, "Error", err)
+		}
+
+		result, _ := call.Otto.ToValue(id)
+		return result
+	}
+
+	load := func(call otto.FunctionCall) otto.Value {
+		id := call.Argument(0).String()
+		program, err := parseScript(id+".js", fmt.Sprintf(moduleWrapper, libraries[id]))
+		if err != nil {
+			throwError(call, "SyntaxError", fmt.Errorf("module %s: %w", id, err))
+		}
+
+		function, err := call.Otto.Run(program)
+		if err != nil {
+			throwError(call, "Error", fmt.Errorf("module %s: %w", id, err))
+		}
+
+		return function
+	}
+
+	require, err := vm.Call(requireSource, nil, resolve, load)
+	if err != nil {
+		return fmt.Errorf("failed to define require: %w", err)
+	}
+
+	return vm.Set("require", require)
+}
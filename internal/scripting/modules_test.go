@@ -0,0 +1,89 @@
+package scripting
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestRequire(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"format.js":     "exports.percent = function(a, b) { return Math.round(100 * a / b) + '%'; };\n",
+		"net/ports.js":  "var format = require('../format');\nmodule.exports = {http: 80, format: format};\n",
+		"counter.js":    "var count = 0;\nexports.next = function() { return ++count; };\n",
+		"broken.js":     "exports.x = 1;\nthrow new Error('broken module');\n",
+		"notes.txt":     "not a module",
+		"nested/a/b.js": "exports.deep = true;\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	libraries, err := LoadLibraries(dir)
+	if err != nil {
+		t.Fatalf("Failed to load libraries: %v", err)
+	}
+	if len(libraries) != 5 || libraries["lib/nested/a/b"] == "" {
+		t.Fatalf("Expected the 5 modules by name, got %v", libraries)
+	}
+
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	engine, err := NewScriptEngine(NewPcapHelper(manager), 5000)
+	if err != nil {
+		t.Fatalf("Failed to create script engine: %v", err)
+	}
+	if err := engine.SetLibraries(libraries); err != nil {
+		t.Fatalf("Failed to set libraries: %v", err)
+	}
+
+	result, err := engine.Execute(`
+		var ports = require("lib/net/ports");
+		var counter = require("lib/counter.js");
+		counter.next();
+		[ports.http, ports.format.percent(1, 4), require("./lib/counter").next(), ports.format === require("lib/format")].join(",")
+	`)
+	if err != nil {
+		t.Fatalf("Failed to execute script: %v", err)
+	}
+	if result != "80,25%,2,true" {
+		t.Errorf("Expected the modules to be loaded once and shared, got %q", result)
+	}
+
+	// The pool resets engines between runs, which starts the module cache over
+	if err := engine.Reset(); err != nil {
+		t.Fatalf("Failed to reset engine: %v", err)
+	}
+	if result, err := engine.Execute(`require("lib/counter").next()`); err != nil || result != "1" {
+		t.Errorf("Expected a fresh module cache, got %q, %v", result, err)
+	}
+
+	if _, err := engine.Execute(`require("lib/missing")`); err == nil || !strings.Contains(err.Error(), "cannot find module") {
+		t.Errorf("Expected a missing module error, got %v", err)
+	}
+	if _, err := engine.Execute(`require("lib/broken")`); err == nil || !strings.Contains(err.Error(), "broken module") {
+		t.Errorf("Expected the module's error, got %v", err)
+	}
+
+	// A module that threw isn't cached, requiring it again fails again
+	result, err = engine.Execute(`
+		var errors = [];
+		for (var i = 0; i < 2; i++) {
+			try { require("lib/broken") } catch (e) { errors.push(e.message) }
+		}
+		errors.join(";")
+	`)
+	if err != nil || strings.Count(result, "broken module") != 2 {
+		t.Errorf("Expected the broken module to throw on each require, got %q, %v", result, err)
+	}
+}
@@ -0,0 +1,122 @@
+package scripting
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+// Options configure the scripting service. They mirror the scripting section of the
+// configuration, which reaches the worker as JSON in the SCRIPTING_OPTIONS entry of the ConfigMap.
+type Options struct {
+	// Timeout and QueueTimeout are in milliseconds
+	Timeout          int      `json:"timeout"`
+	MaxConcurrency   int      `json:"maxConcurrency"`
+	QueueTimeout     int      `json:"queueTimeout"`
+	AllowFileSystem  bool     `json:"allowFileSystem"`
+	FileSystemRoot   string   `json:"fileSystemRoot"`
+	FileSystemQuota  int64    `json:"fileSystemQuota"`
+	AllowNetwork     bool     `json:"allowNetwork"`
+	NetworkAllowlist []string `json:"networkAllowlist"`
+	MaxResponseSize  int64    `json:"maxResponseSize"`
+	StoreDir         string   `json:"storeDir"`
+	StoreQuota       int64    `json:"storeQuota"`
+	MaxOperations    int64    `json:"maxOperations"`
+	MaxMemory        int64    `json:"maxMemory"`
+	MaxOutput        int64    `json:"maxOutput"`
+	MaxBindingCalls  int64    `json:"maxBindingCalls"`
+	MaxRetentions    int64    `json:"maxRetentions"`
+}
+
+// DefaultOptions returns the options of the configuration's defaults
+func DefaultOptions() Options {
+	return Options{
+		Timeout:         5000,
+		MaxConcurrency:  10,
+		QueueTimeout:    10000,
+		FileSystemRoot:  "scripts-data",
+		FileSystemQuota: 1 << 30,
+		MaxResponseSize: 1 << 20,
+		StoreDir:        "scripts-store",
+		StoreQuota:      1 << 20,
+		MaxMemory:       100 << 20,
+		MaxOutput:       1 << 20,
+		MaxRetentions:   100,
+	}
+}
+
+// ParseOptions parses the JSON options of the ConfigMap. Missing options keep their defaults.
+func ParseOptions(data string) (Options, error) {
+	options := DefaultOptions()
+	if data == "" {
+		return options, nil
+	}
+	if err := json.Unmarshal([]byte(data), &options); err != nil {
+		return options, fmt.Errorf("failed to parse scripting options: %w", err)
+	}
+
+	return options, nil
+}
+
+// NewScriptingServiceWithOptions creates a scripting service configured by options. The file
+// system root and the store directory are relative to dataDir, unless they are absolute.
+func NewScriptingServiceWithOptions(pcapManager *worker.PcapManager, dataDir string, options Options) (*ScriptingService, error) {
+	service, err := NewScriptingService(pcapManager, options.Timeout, options.MaxConcurrency, time.Duration(options.QueueTimeout)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	service.SetBudget(options.Budget())
+
+	if options.AllowFileSystem {
+		sandbox, err := NewFileSandbox(dataPath(dataDir, options.FileSystemRoot), options.FileSystemQuota)
+		if err != nil {
+			return nil, err
+		}
+		if err := service.SetFileSystem(sandbox); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.AllowNetwork {
+		client, err := NewHTTPClient(options.NetworkAllowlist, options.MaxResponseSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := service.SetNetwork(client); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := NewScriptStore(dataPath(dataDir, options.StoreDir), options.StoreQuota)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.SetStore(store); err != nil {
+		return nil, err
+	}
+
+	return service, nil
+}
+
+// Budget returns the execution budget of the options
+func (o Options) Budget() ScriptBudget {
+	return ScriptBudget{
+		MaxOperations:   o.MaxOperations,
+		MaxMemory:       o.MaxMemory,
+		MaxOutput:       o.MaxOutput,
+		MaxBindingCalls: o.MaxBindingCalls,
+		MaxRetentions:   o.MaxRetentions,
+	}
+}
+
+// dataPath resolves a configured path against the data directory
+func dataPath(dataDir string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dataDir, path)
+}
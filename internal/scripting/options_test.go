@@ -0,0 +1,45 @@
+package scripting
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/kubeshark/internal/worker"
+)
+
+func TestScriptingServiceWithOptions(t *testing.T) {
+	options, err := ParseOptions(`{"timeout": 1000, "maxConcurrency": 2, "allowFileSystem": true, "maxBindingCalls": 3}`)
+	if err != nil {
+		t.Fatalf("Failed to parse options: %v", err)
+	}
+	if options.Timeout != 1000 || options.QueueTimeout != 10000 || options.FileSystemRoot != "scripts-data" || options.MaxMemory != 100<<20 || options.AllowNetwork {
+		t.Errorf("Expected the missing options to keep their defaults, got %+v", options)
+	}
+	if _, err := ParseOptions("{"); err == nil {
+		t.Errorf("Expected invalid options to be rejected")
+	}
+
+	dataDir := t.TempDir()
+	manager := worker.NewPcapManager(t.TempDir(), 10*time.Second, 1024*1024)
+	service, err := NewScriptingServiceWithOptions(manager, dataDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create scripting service: %v", err)
+	}
+	if size := service.pool.Size(); size != 2 {
+		t.Errorf("Expected a pool of 2 engines, got %d", size)
+	}
+
+	result, err := service.ExecuteScriptAs("writer", `fs.write("out.txt", "hi"); store.set("k", 1); typeof http`)
+	if err != nil || result != "undefined" {
+		t.Fatalf("Expected fs and store without http, got %q, %v", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "scripts-data", "out.txt")); err != nil {
+		t.Errorf("Expected the file in the data directory: %v", err)
+	}
+	if _, err := service.ExecuteScript("for (var i = 0; i < 4; i++) { pcap.isRetained('a.pcap') }"); !errors.Is(err, ErrBindingCallBudgetExceeded) {
+		t.Errorf("Expected the budget of the options to apply, got %v", err)
+	}
+}
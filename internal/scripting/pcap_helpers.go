@@ -1,417 +1,177 @@
 package scripting
-package scripting
-package scripting
 
 import (
-	"fmt"
-	"path/filepath"
+	"io"
 	"sync"
 	"time"
 
-	"github.com/kubeshark/kubeshark/internal/worker"
-)
-
-// PcapHelper provides JavaScript bindings for PCAP management
-type PcapHelper struct {
-	manager        *worker.PcapManager
-	retentionMap   map[string]time.Time
-	retentionMutex sync.RWMutex
-}
-
-// NewPcapHelper creates a new PCAP helper instance
-func NewPcapHelper(manager *worker.PcapManager) *PcapHelper {
-	return &PcapHelper{
-		manager:        manager,
-		retentionMap:   make(map[string]time.Time),
-		retentionMutex: sync.RWMutex{},
-	}
-}
-
-// RetainPcap marks a PCAP file for retention for the specified duration in seconds
-func (p *PcapHelper) RetainPcap(pcapName string, durationSec int) {
-	p.retentionMutex.Lock()
-	defer p.retentionMutex.Unlock()
-
-	// Calculate expiration time
-	expiration := time.Now().Add(time.Duration(durationSec) * time.Second)
-	
-	// Store in retention map
-	p.retentionMap[pcapName] = expiration
-	
-	// Also use the manager's retention if available
-	p.manager.RetainPcap(pcapName, time.Duration(durationSec)*time.Second)
-}
-
-// IsRetained checks if a PCAP file is currently being retained
-func (p *PcapHelper) IsRetained(pcapName string) bool {
-	p.retentionMutex.RLock()
-	defer p.retentionMutex.RUnlock()
-	
-	expiration, exists := p.retentionMap[pcapName]
-	if !exists {
-		return false
-	}
-	
-	// Check if retention has expired
-	return time.Now().Before(expiration)
-}
-
-// GetPcapPath returns the full path to a PCAP file given a stream ID
-func (p *PcapHelper) GetPcapPath(streamID string) string {
-	// Format the PCAP filename based on stream ID
-	pcapName := fmt.Sprintf("test_stream_%s.pcap", streamID)
-	
-	// Join with the base directory from the manager
-	return filepath.Join(p.manager.GetPcapDirectory(), pcapName)
-}
-
-// CleanExpired removes expired entries from the retention map
-func (p *PcapHelper) CleanExpired() {
-	p.retentionMutex.Lock()
-	defer p.retentionMutex.Unlock()
-	
-	now := time.Now()
-	for pcapName, expiration := range p.retentionMap {
-		if now.After(expiration) {
-			delete(p.retentionMap, pcapName)
-		}
-	}
-}
-import (
-	"time"
-
 	"github.com/kubeshark/kubeshark/internal/worker"
 	"github.com/rs/zerolog/log"
 )
 
-// PcapHelper provides PCAP-related helper functions for scripts
+// PcapHelper provides PCAP-related helper functions for scripts.
+// It keeps no retention state of its own: everything is delegated to the
+// RetentionStore of the PcapManager, so scripts and the worker always agree.
 type PcapHelper struct {
 	pcapManager *worker.PcapManager
+	script      string
+	readers     *pcapReaders
 }
 
-// NewPcapHelper creates a new PCAP helper
-func NewPcapHelper(pcapManager *worker.PcapManager) *PcapHelper {
-	return &PcapHelper{
-		pcapManager: pcapManager,
-	}
+// pcapReaders tracks the readers opened by scripts, so they are closed when a run ends
+// even if the script stopped iterating or was interrupted
+type pcapReaders struct {
+	open map[*worker.PcapReader]struct{}
+	mu   sync.Mutex
 }
 
-// RetainPcap marks a PCAP file for extended retention
-// This can be used by scripts to prevent important PCAPs from being deleted
-func (ph *PcapHelper) RetainPcap(pcapName string, durationSeconds int) {
-	duration := time.Duration(durationSeconds) * time.Second
-	ph.pcapManager.RetainPcap(pcapName, duration)
-	log.Info().
-		Str("pcapName", pcapName).
-		Int("durationSeconds", durationSeconds).
-		Msg("Script requested PCAP retention")
-}
-package scripting
-package scripting
-
-import (
-	"time"
-
-	"github.com/kubeshark/kubeshark/internal/worker"
-	"github.com/robertkrimen/otto"
-)
-
-// PcapHelper helps with PCAP operations in scripts
-type PcapHelper struct {
-	pcapManager *worker.PcapManager
-}
-
-// NewPcapHelper creates a new PcapHelper
+// NewPcapHelper creates a new PCAP helper
 func NewPcapHelper(pcapManager *worker.PcapManager) *PcapHelper {
 	return &PcapHelper{
 		pcapManager: pcapManager,
+		readers:     &pcapReaders{open: make(map[*worker.PcapReader]struct{})},
 	}
 }
-package scripting
-
-import (
-	"path/filepath"
-	"sync"
-	"time"
-
-	"github.com/kubeshark/kubeshark/internal/worker"
-)
-
-// PcapHelper provides functionality for managing PCAP files
-type PcapHelper struct {
-	manager    *worker.PcapManager
-	retentions map[string]time.Time
-	mutex      sync.RWMutex
-}
 
-// NewPcapHelper creates a new PCAP helper
-func NewPcapHelper(manager *worker.PcapManager) *PcapHelper {
+// WithScript returns a helper that accounts retentions to the given script, so its quota applies
+func (ph *PcapHelper) WithScript(title string) *PcapHelper {
 	return &PcapHelper{
-		manager:    manager,
-		retentions: make(map[string]time.Time),
-		mutex:      sync.RWMutex{},
+		pcapManager: ph.pcapManager,
+		script:      title,
+		readers:     ph.readers,
 	}
 }
 
-// RetainPcap marks a PCAP for retention for the specified duration in seconds
-func (p *PcapHelper) RetainPcap(pcapName string, durationSec int) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	
-	// Calculate the expiration time
-	expiration := time.Now().Add(time.Duration(durationSec) * time.Second)
-	
-	// Store in our retention map
-	p.retentions[pcapName] = expiration
-}
-
-// IsRetained checks if a PCAP is currently retained
-func (p *PcapHelper) IsRetained(pcapName string) bool {
-	p.mutex.RLock()
-	defer p.mutex.RUnlock()
-	
-	expiration, exists := p.retentions[pcapName]
-	if !exists {
-		return false
+// forEngine returns a helper with its own set of open readers, so an engine only
+// closes the readers of its own scripts
+func (ph *PcapHelper) forEngine() *PcapHelper {
+	return &PcapHelper{
+		pcapManager: ph.pcapManager,
+		script:      ph.script,
+		readers:     &pcapReaders{open: make(map[*worker.PcapReader]struct{})},
 	}
-	
-	// Check if the retention has expired
-	return time.Now().Before(expiration)
 }
 
-// GetPcapPath returns the full path to a PCAP file based on stream ID
-func (p *PcapHelper) GetPcapPath(streamID string) string {
-	filename := streamID + ".pcap"
-	return filepath.Join(p.manager.GetPcapDir(), filename)
+// RetainPcap marks a PCAP file for extended retention
+// This can be used by scripts to prevent important PCAPs from being deleted
+func (ph *PcapHelper) RetainPcap(pcapName string, durationSeconds int) {
+	_ = ph.RetainPcapFor(pcapName, durationSeconds, "")
 }
 
-// CleanupExpiredRetentions removes all expired PCAP retentions
-func (p *PcapHelper) CleanupExpiredRetentions() {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	
-	now := time.Now()
-	for pcapName, expiration := range p.retentions {
-		if now.After(expiration) {
-			delete(p.retentions, pcapName)
-		}
+// RetainPcapFor marks a PCAP file for extended retention on behalf of the helper's script,
+// accounted to the given target namespace. It fails if the retention is over quota.
+func (ph *PcapHelper) RetainPcapFor(pcapName string, durationSeconds int, namespace string) error {
+	duration := time.Duration(durationSeconds) * time.Second
+	owner := worker.RetentionOwner{
+		Script:    ph.script,
+		Namespace: namespace,
 	}
-}
-
-// GetRetainedPcaps returns a list of all currently retained PCAPs
-func (p *PcapHelper) GetRetainedPcaps() []string {
-	p.mutex.RLock()
-	defer p.mutex.RUnlock()
-	
-	now := time.Now()
-	pcaps := make([]string, 0, len(p.retentions))
-	
-	for pcapName, expiration := range p.retentions {
-		if now.Before(expiration) {
-			pcaps = append(pcaps, pcapName)
-		}
+	if err := ph.pcapManager.RetainPcapFor(pcapName, duration, owner); err != nil {
+		return err
 	}
-	
-	return pcaps
-}
 
-// CleanupExpiredRetentions removes all expired PCAP retentions
-func (p *PcapHelper) CleanupExpiredRetentions() {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	
-	now := time.Now()
-	for pcapName, expiration := range p.retentions {
-		if now.After(expiration) {
-			delete(p.retentions, pcapName)
-		}
-	}
+	log.Info().
+		Str("pcapName", pcapName).
+		Int("durationSeconds", durationSeconds).
+		Str("script", ph.script).
+		Msg("Script requested PCAP retention")
+	return nil
 }
 
-// GetRetainedPcaps returns a list of all currently retained PCAPs
-func (p *PcapHelper) GetRetainedPcaps() []string {
-	p.mutex.RLock()
-	defer p.mutex.RUnlock()
-	
-	now := time.Now()
-	pcaps := make([]string, 0, len(p.retentions))
-	
-	for pcapName, expiration := range p.retentions {
-		if now.Before(expiration) {
-			pcaps = append(pcaps, pcapName)
-		}
+// Snapshot builds a retained PCAP slice of the selected streams and time range on behalf of the helper's script
+func (ph *PcapHelper) Snapshot(name string, opts worker.SnapshotOptions) (worker.SnapshotResult, error) {
+	opts.Owner.Script = ph.script
+	result, err := ph.pcapManager.Snapshot(name, opts)
+	if err != nil {
+		return result, err
 	}
-	
-	return pcaps
-}
 
-// CleanupExpiredRetentions removes expired retentions
-func (p *PcapHelper) CleanupExpiredRetentions() {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	
-	now := time.Now()
-	for pcapName, expiration := range p.retentions {
-		if now.After(expiration) {
-			delete(p.retentions, pcapName)
-		}
-	}
+	log.Info().
+		Str("snapshot", result.Name).
+		Int("packets", result.Packets).
+		Str("script", ph.script).
+		Msg("Script created PCAP snapshot")
+	return result, nil
 }
 
-// GetRetainedPcaps returns a list of currently retained PCAP names
-func (p *PcapHelper) GetRetainedPcaps() []string {
-	p.mutex.RLock()
-	defer p.mutex.RUnlock()
-	
-	pcaps := make([]string, 0, len(p.retentions))
-	now := time.Now()
-	
-	for pcapName, expiration := range p.retentions {
-		if now.Before(expiration) {
-			pcaps = append(pcaps, pcapName)
-		}
+// OpenReader opens a PCAP file for packet iteration, including up to maxPayload payload bytes per packet
+func (ph *PcapHelper) OpenReader(path string, maxPayload int) (*worker.PcapReader, error) {
+	reader, err := ph.pcapManager.OpenPcapReader(path, maxPayload)
+	if err != nil {
+		return nil, err
 	}
-	
-	return pcaps
-}
-// RegisterPcapBindings registers PCAP-related functions to the JS VM
-func RegisterPcapBindings(vm *otto.Otto, helper *PcapHelper) {
-	pcapObj, _ := vm.Object("pcap = {}")
-	
-	_ = pcapObj.Set("retain", func(call otto.FunctionCall) otto.Value {
-		pcapName := call.Argument(0).String()
-		durationSec, _ := call.Argument(1).ToInteger()
-		
-		helper.pcapManager.RetainPcap(pcapName, time.Duration(durationSec)*time.Second)
-		
-		result, _ := otto.ToValue(true)
-		return result
-	})
-}
-import (
-	"time"
 
-	"github.com/kubeshark/kubeshark/internal/worker"
-	"github.com/rs/zerolog/log"
-)
+	ph.readers.mu.Lock()
+	defer ph.readers.mu.Unlock()
 
-// PcapHelper provides PCAP-related helper functions for scripts
-type PcapHelper struct {
-	pcapManager *worker.PcapManager
+	ph.readers.open[reader] = struct{}{}
+	return reader, nil
 }
 
-// NewPcapHelper creates a new PCAP helper
-func NewPcapHelper(pcapManager *worker.PcapManager) *PcapHelper {
-	return &PcapHelper{
-		pcapManager: pcapManager,
-	}
-}
+// CloseReader closes a reader opened by OpenReader
+func (ph *PcapHelper) CloseReader(reader *worker.PcapReader) {
+	ph.readers.mu.Lock()
+	defer ph.readers.mu.Unlock()
 
-// RetainPcap marks a PCAP file for extended retention
-// This can be used by scripts to prevent important PCAPs from being deleted
-func (ph *PcapHelper) RetainPcap(pcapName string, durationSeconds int) {
-	duration := time.Duration(durationSeconds) * time.Second
-	ph.pcapManager.RetainPcap(pcapName, duration)
-	log.Info().
-		Str("pcapName", pcapName).
-		Int("durationSeconds", durationSeconds).
-		Msg("Script requested PCAP retention")
-}
-package scripting
-
-import (
-	"path/filepath"
-	"time"
-
-	"github.com/kubeshark/kubeshark/internal/worker"
-)
-
-// PcapHelper provides functions for working with PCAP files in scripts
-type PcapHelper struct {
-	pcapManager *worker.PcapManager
-}
-
-// NewPcapHelper creates a new PcapHelper instance
-func NewPcapHelper(pcapManager *worker.PcapManager) *PcapHelper {
-	return &PcapHelper{
-		pcapManager: pcapManager,
+	if _, exists := ph.readers.open[reader]; !exists {
+		return
 	}
+	delete(ph.readers.open, reader)
+	reader.Close()
 }
 
-// RetainPcap marks a PCAP file for retention for the specified duration in seconds
-func (h *PcapHelper) RetainPcap(pcapName string, durationSec int) {
-	h.pcapManager.RetainPcap(pcapName, time.Duration(durationSec)*time.Second)
-}
+// CloseReaders closes the readers the scripts left open
+func (ph *PcapHelper) CloseReaders() {
+	ph.readers.mu.Lock()
+	defer ph.readers.mu.Unlock()
 
-// IsRetained checks if a PCAP file is currently marked for retention
-func (h *PcapHelper) IsRetained(pcapName string) bool {
-	return h.pcapManager.IsRetained(pcapName)
+	for reader := range ph.readers.open {
+		reader.Close()
+	}
+	clear(ph.readers.open)
 }
 
-// GetPcapPath generates a PCAP path for the given stream ID
-func (h *PcapHelper) GetPcapPath(streamID string) string {
-	return filepath.Join(h.pcapManager.GetPcapDir(), streamID+".pcap")
-}
-// GetPcapPath returns the path to a PCAP file
-func (ph *PcapHelper) GetPcapPath(streamID string) string {
-	// Implementation depends on how PcapManager stores files
-	// This is a placeholder for the actual implementation
-	return streamID + ".pcap"
+// OpenStoredPcap opens a PCAP file by name or by its path in the PCAP storage
+func (ph *PcapHelper) OpenStoredPcap(path string) (io.ReadCloser, error) {
+	return ph.pcapManager.OpenStoredPcap(path)
 }
 
-// IsRetained checks if a PCAP file is marked for extended retention
-func (ph *PcapHelper) IsRetained(pcapName string) bool {
-	return ph.pcapManager.IsRetained(pcapName)
-}
-// GetPcapPath returns the path to a PCAP file
-func (ph *PcapHelper) GetPcapPath(streamID string) string {
-	// Implementation depends on how PcapManager stores files
-	// This is a placeholder for the actual implementation
-	return streamID + ".pcap"
+// ListRetentions returns the active retentions with the usage per script and per namespace
+func (ph *PcapHelper) ListRetentions() (worker.RetentionListing, error) {
+	return ph.pcapManager.ListRetentions()
 }
 
 // IsRetained checks if a PCAP file is marked for extended retention
 func (ph *PcapHelper) IsRetained(pcapName string) bool {
 	return ph.pcapManager.IsRetained(pcapName)
 }
-import (
-	"time"
-
-	"github.com/kubeshark/kubeshark/internal/worker"
-	"github.com/rs/zerolog/log"
-)
 
-// PcapHelper provides PCAP-related helper functions for scripts
-type PcapHelper struct {
-	pcapManager *worker.PcapManager
+// GetPcapPath returns the full path to the PCAP file of a stream, as known to the
+// manager's catalog, or an empty string if no file holds the stream
+func (ph *PcapHelper) GetPcapPath(streamID string) string {
+	path, _ := ph.pcapManager.ResolvePcapPath(streamID)
+	return path
 }
 
-// NewPcapHelper creates a new PCAP helper
-func NewPcapHelper(pcapManager *worker.PcapManager) *PcapHelper {
-	return &PcapHelper{
-		pcapManager: pcapManager,
+// CleanupExpiredRetentions removes all expired PCAP retentions
+func (ph *PcapHelper) CleanupExpiredRetentions() {
+	if _, err := ph.pcapManager.RetentionStore().PurgeExpired(time.Now()); err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired PCAP retentions")
 	}
 }
 
-// RetainPcap marks a PCAP file for extended retention
-// This can be used by scripts to prevent important PCAPs from being deleted
-func (ph *PcapHelper) RetainPcap(pcapName string, durationSeconds int) {
-	duration := time.Duration(durationSeconds) * time.Second
-	ph.pcapManager.RetainPcap(pcapName, duration)
-	log.Info().
-		Str("pcapName", pcapName).
-		Int("durationSeconds", durationSeconds).
-		Msg("Script requested PCAP retention")
-}
+// GetRetainedPcaps returns a list of all currently retained PCAPs
+func (ph *PcapHelper) GetRetainedPcaps() []string {
+	retentions, err := ph.pcapManager.RetentionStore().List()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list PCAP retentions")
+		return nil
+	}
 
-// GetPcapPath returns the path to a PCAP file
-func (ph *PcapHelper) GetPcapPath(streamID string) string {
-	// Implementation depends on how PcapManager stores files
-	// This is a placeholder for the actual implementation
-	return streamID + ".pcap"
-}
+	pcaps := make([]string, 0, len(retentions))
+	for _, r := range retentions {
+		pcaps = append(pcaps, r.Name)
+	}
 
-// IsRetained checks if a PCAP file is marked for extended retention
-func (ph *PcapHelper) IsRetained(pcapName string) bool {
-	return ph.pcapManager.IsRetained(pcapName)
+	return pcaps
 }
@@ -53,66 +53,30 @@ func TestGetPcapPath(t *testing.T) {
 	}
 	defer os.RemoveAll(tempDir)
 
-	// Create a PCAP manager for testing
-	manager := worker.NewPcapManager(tempDir, 10*time.Second, 1024*1024)
-
-	// Create a PCAP helper
-	helper := NewPcapHelper(manager)
-
-	// Test getting PCAP path
+	// Create a PCAP file before the manager indexes the directory
 	streamID := "test_stream_789012"
 	expectedPath := filepath.Join(tempDir, streamID+".pcap")
-
-	actualPath := helper.GetPcapPath(streamID)
-
-	if actualPath != expectedPath {
-		t.Errorf("Expected path %s, got %s", expectedPath, actualPath)
-	}
-}
-
-func TestCleanupExpiredRetentions(t *testing.T) {
-	// Create a PCAP manager for testing
-	manager := worker.NewPcapManager("/tmp/pcaps", 10*time.Second, 1024*1024)
-
-	// Create a PCAP helper
-	helper := NewPcapHelper(manager)
-
-	// Add some retentions
-	helper.RetainPcap("test1.pcap", 60)
-	helper.RetainPcap("test2.pcap", 1)
-
-	// Wait for test2 to expire
-	time.Sleep(2 * time.Second)
-
-	// Clean up
-	helper.CleanupExpiredRetentions()
-
-	// Verify test1 is still retained, but test2 is not
-	if !helper.IsRetained("test1.pcap") {
-		t.Errorf("test1.pcap should still be retained")
-	}
-
-	if helper.IsRetained("test2.pcap") {
-		t.Errorf("test2.pcap should not be retained anymore")
+	if err := os.WriteFile(expectedPath, []byte("pcap"), 0644); err != nil {
+		t.Fatalf("Failed to create PCAP file: %v", err)
 	}
-}
 
-func TestGetPcapPath(t *testing.T) {
 	// Create a PCAP manager for testing
-	manager := worker.NewPcapManager("/tmp/pcaps", 10*time.Second, 1024*1024)
+	manager := worker.NewPcapManager(tempDir, 10*time.Second, 1024*1024)
 
 	// Create a PCAP helper
 	helper := NewPcapHelper(manager)
 
 	// Test getting PCAP path
-	streamID := "test_stream_789012"
-	expectedPath := "/tmp/pcaps/test_stream_789012.pcap"
-
 	actualPath := helper.GetPcapPath(streamID)
 
 	if actualPath != expectedPath {
 		t.Errorf("Expected path %s, got %s", expectedPath, actualPath)
 	}
+
+	// Unknown streams have no path
+	if path := helper.GetPcapPath("unknown_stream"); path != "" {
+		t.Errorf("Expected no path for an unknown stream, got %s", path)
+	}
 }
 
 func TestCleanupExpiredRetentions(t *testing.T) {
@@ -167,37 +131,3 @@ func TestGetRetainedPcaps(t *testing.T) {
 		t.Errorf("Expected 2 retained PCAPs, got %d", len(retainedPcaps))
 	}
 }
-
-func TestGetRetainedPcaps(t *testing.T) {
-	// Create a PCAP manager for testing
-	manager := worker.NewPcapManager("/tmp/pcaps", 10*time.Second, 1024*1024)
-
-	// Create a PCAP helper
-	helper := NewPcapHelper(manager)
-
-	// Initially should be empty
-	initialPcaps := helper.GetRetainedPcaps()
-	if len(initialPcaps) > 0 {
-		t.Errorf("Initially there should be no retained PCAPs")
-	}
-
-	// Retain some PCAPs
-	helper.RetainPcap("test1.pcap", 60)
-	helper.RetainPcap("test2.pcap", 60)
-
-	// Get retained PCAPs
-	retainedPcaps := helper.GetRetainedPcaps()
-
-	// Should have 2 retained PCAPs
-	if len(retainedPcaps) != 2 {
-		t.Errorf("Expected 2 retained PCAPs, got %d", len(retainedPcaps))
-	}
-}
-
-// Ensure all logic is inside a function, for example:
-func TestSomeFeature(t *testing.T) {
-	// ...existing test code...
-
-	// Move any stray 'if' statements here
-	// if ... { ... }
-}
@@ -1,49 +1,154 @@
 package scripting
 
 import (
+	"context"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/kubeshark/kubeshark/internal/worker"
-	"github.com/robertkrimen/otto"
 )
 
-// ScriptingService manages JavaScript script execution for Kubeshark
+// ScriptingService manages JavaScript script execution for Kubeshark. Ad hoc scripts run
+// concurrently on a pool of engines, bounded by the maximum concurrency. A titled script gets
+// an engine of its own, which keeps its globals for its hooks and jobs.
 type ScriptingService struct {
-	vm          *otto.Otto
-	pcapManager *worker.PcapManager
-	pcapTTL     time.Duration
+	pool       *EnginePool
+	pcapHelper *PcapHelper
+	hooks      *HookRegistry
+	scheduler  *JobScheduler
 }
 
-// NewScriptingService creates a new scripting service
-func NewScriptingService(pcapManager *worker.PcapManager, timeoutMs int) (*ScriptingService, error) {
+// NewScriptingService creates a new scripting service running up to maxConcurrency scripts
+// at a time. Further runs wait up to queueTimeout for an engine.
+func NewScriptingService(pcapManager *worker.PcapManager, timeoutMs int, maxConcurrency int, queueTimeout time.Duration) (*ScriptingService, error) {
 	pcapHelper := NewPcapHelper(pcapManager)
 
-	engine, err := NewScriptEngine(pcapHelper, timeoutMs)
+	pool, err := NewEnginePool(pcapHelper, timeoutMs, maxConcurrency, queueTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create script engine: %w", err)
+		return nil, fmt.Errorf("failed to create script engine pool: %w", err)
+	}
+
+	hooks := NewHookRegistry(pool)
+	scheduler := NewJobScheduler(hooks)
+	if err := pool.SetScheduler(scheduler); err != nil {
+		return nil, fmt.Errorf("failed to set job scheduler: %w", err)
 	}
 
 	return &ScriptingService{
-		engine:     engine,
+		pool:       pool,
 		pcapHelper: pcapHelper,
-		mutex:      sync.Mutex{},
+		hooks:      hooks,
+		scheduler:  scheduler,
 	}, nil
 }
 
-// ExecuteScript runs a script with locking to ensure thread safety
+// SetBudget limits what each script execution may consume, see ScriptBudget
+func (s *ScriptingService) SetBudget(budget ScriptBudget) {
+	s.pool.SetBudget(budget)
+	s.hooks.SetBudget(budget)
+}
+
+// SetFileSystem gives scripts the fs binding, confined to sandbox. A nil sandbox removes it.
+func (s *ScriptingService) SetFileSystem(sandbox *FileSandbox) error {
+	if err := s.pool.SetFileSystem(sandbox); err != nil {
+		return err
+	}
+
+	return s.hooks.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetFileSystem(sandbox)
+	})
+}
+
+// SetNetwork gives scripts the http binding, limited to the client's allowlist. A nil client removes it.
+func (s *ScriptingService) SetNetwork(client *HTTPClient) error {
+	if err := s.pool.SetNetwork(client); err != nil {
+		return err
+	}
+
+	return s.hooks.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetNetwork(client)
+	})
+}
+
+// SetStore gives scripts the store binding, each in its own namespace. A nil store removes it.
+func (s *ScriptingService) SetStore(store *ScriptStore) error {
+	if err := s.pool.SetStore(store); err != nil {
+		return err
+	}
+
+	return s.hooks.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetStore(store)
+	})
+}
+
+// SetLibraries sets the modules scripts can require, by name such as lib/name. The
+// library modules are uploaded with the scripts.
+func (s *ScriptingService) SetLibraries(libraries map[string]string) error {
+	if err := s.pool.SetLibraries(libraries); err != nil {
+		return err
+	}
+
+	return s.hooks.reconfigure(func(engine *ScriptEngine) error {
+		return engine.SetLibraries(libraries)
+	})
+}
+
+// ExecuteScript runs a script on a fresh engine from the pool. Its hooks and jobs aren't registered.
 func (s *ScriptingService) ExecuteScript(script string) (string, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	engine, err := s.pool.Acquire(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer s.pool.Release(engine)
 
-	// Reset engine before execution
-	if err := s.engine.Reset(); err != nil {
-		return "", fmt.Errorf("failed to reset script engine: %w", err)
+	return engine.Execute(script)
+}
+
+// ExecuteScriptAs runs a script on behalf of the titled script, so its retention quota applies,
+// on an engine of its own. The hooks the script defines and the jobs it schedules are registered
+// under its title and run on that engine, replacing those of its previous version.
+func (s *ScriptingService) ExecuteScriptAs(title string, script string) (string, error) {
+	engine, err := s.pool.Spawn()
+	if err != nil {
+		return "", err
+	}
+
+	result, _, err := s.hooks.Load(title, script, engine)
+	if err != nil {
+		return "", err
+	}
+	if err := s.scheduler.Replace(title, engine.ScheduledJobs()); err != nil {
+		return result, err
 	}
 
-	// Execute the script
-	return s.engine.Execute(script)
+	return result, nil
+}
+
+// UnloadScript removes the hooks and jobs of the titled script, when it is deactivated
+func (s *ScriptingService) UnloadScript(title string) {
+	s.scheduler.RemoveScript(title)
+	s.hooks.Unload(title)
+}
+
+// Jobs returns the scheduled jobs of all scripts
+func (s *ScriptingService) Jobs() []JobInfo {
+	return s.scheduler.List("")
+}
+
+// CancelJob cancels a job of the titled script and reports whether it existed
+func (s *ScriptingService) CancelJob(title string, name string) bool {
+	return s.scheduler.Cancel(title, name)
+}
+
+// Close stops the scheduled jobs
+func (s *ScriptingService) Close() {
+	s.scheduler.Stop()
+}
+
+// Dispatch calls the event's hook of every loaded script defining it. A failing hook doesn't
+// keep the others from running, the failures are returned joined as HookErrors.
+func (s *ScriptingService) Dispatch(ctx context.Context, event HookEvent) error {
+	return s.hooks.Dispatch(ctx, event)
 }
 
 // RetainPcap provides access to the PCAP retention functionality
@@ -61,57 +166,37 @@ func (s *ScriptingService) GetPcapPath(streamID string) string {
 	return s.pcapHelper.GetPcapPath(streamID)
 }
 
-// NewScriptingService creates a new scripting service
-func NewScriptingService(pcapManager *worker.PcapManager, pcapTTL time.Duration) *ScriptingService {
-	return &ScriptingService{
-		vm:          otto.New(),
-		pcapManager: pcapManager,
-		pcapTTL:     pcapTTL,
+// ServePcapEvents dispatches the PCAP lifecycle events to the hooks of the loaded scripts,
+// such as onPcapRetained and onPcapEvicted, until ctx is done. Events are dispatched one at a
+// time and in order, so slow hooks fill the subscription's buffer, which then drops events,
+// rather than piling up goroutines.
+func (s *ScriptingService) ServePcapEvents(ctx context.Context) {
+	sub := s.pcapHelper.pcapManager.Subscribe(0)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			// The registry logs the failing hooks
+			_ = s.Dispatch(ctx, PcapLifecycleEvent{Event: event})
+		}
 	}
 }
 
-// RegisterPcapFunctions registers PCAP-related functions to the JavaScript VM
-func (s *ScriptingService) RegisterPcapFunctions() error {
-	// Register pcap.path function
-	err := s.vm.Set("pcap", map[string]interface{}{
-		"path": func(call otto.FunctionCall) otto.Value {
-			streamID, _ := call.Argument(0).ToString()
-			pcapPath := fmt.Sprintf("pcaps/master/%s.pcap", streamID)
-
-			// Automatically retain this PCAP for longer time
-			s.pcapManager.RetainPcap(pcapPath, s.pcapTTL*2)
-
-			result, _ := otto.ToValue(pcapPath)
-			return result
-		},
-	})
-	if err != nil {
-		return err
+// pcapEventObject converts a PCAP event to the object passed to script hooks
+func pcapEventObject(event worker.PcapEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      string(event.Type),
+		"name":      event.Name,
+		"path":      event.Path,
+		"size":      event.Size,
+		"class":     event.Class,
+		"timestamp": event.Time.UnixMilli(),
 	}
-
-	// Register file.copy function with automatic retention
-	err = s.vm.Set("file", map[string]interface{}{
-		"copy": func(call otto.FunctionCall) otto.Value {
-			src, _ := call.Argument(0).ToString()
-			dst, _ := call.Argument(1).ToString()
-
-			// Extract PCAP name from path and retain it
-			s.pcapManager.RetainPcap(src, s.pcapTTL)
-
-			// Actual file copy would be implemented here
-			// (not shown for brevity)
-
-			result, _ := otto.ToValue(true)
-			return result
-		},
-	})
-
-	return err
-}
-
-// ExecuteScript runs a JavaScript script with optimized PCAP handling
-func (s *ScriptingService) ExecuteScript(script string, data map[string]interface{}) error {
-	// Run the script with optimized PCAP retention
-	_, err := s.vm.Run(script)
-	return err
 }
@@ -0,0 +1,151 @@
+package scripting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// registerStoreBindings registers the store object, reading and writing the script's
+// namespace of store
+func registerStoreBindings(vm *otto.Otto, store *ScriptStore, script string, meter *budgetMeter) error {
+	functions := storeFunctions(store, script)
+	for name, function := range functions {
+		binding, function := "store."+name, function
+		functions[name] = func(call otto.FunctionCall) otto.Value {
+			meter.bindingCall(binding)
+			return function(call)
+		}
+	}
+
+	return setFunctions(vm, "store", functions)
+}
+
+// storeFunctions returns the functions of the store object, so the test runner can record
+// their calls around them
+func storeFunctions(store *ScriptStore, script string) map[string]func(call otto.FunctionCall) otto.Value {
+	return map[string]func(call otto.FunctionCall) otto.Value{
+		// get returns the value of a key, null if it doesn't exist
+		"get": func(call otto.FunctionCall) otto.Value {
+			value, exists, err := store.Get(script, storeKey(call))
+			if err != nil {
+				throwStoreError(call, err)
+			}
+			if !exists {
+				return otto.NullValue()
+			}
+
+			return toJSValue(call, value)
+		},
+		// set stores a value of any JSON type, with an optional {ttl: seconds}
+		"set": func(call otto.FunctionCall) otto.Value {
+			key := storeKey(call)
+			value := storeValue(call, call.Argument(1))
+			ttl := time.Duration(optionInt(call.Argument(2), "ttl")) * time.Second
+			if err := store.Set(script, key, value, ttl); err != nil {
+				throwStoreError(call, err)
+			}
+
+			return otto.UndefinedValue()
+		},
+		// incr atomically adds a number, 1 by default, and returns the result. The optional
+		// {ttl: seconds} applies when the key is created.
+		"incr": func(call otto.FunctionCall) otto.Value {
+			key := storeKey(call)
+			delta := 1.0
+			if call.Argument(1).IsDefined() {
+				var err error
+				if delta, err = call.Argument(1).ToFloat(); err != nil {
+					throwError(call, "TypeError", fmt.Errorf("store.incr requires a number"))
+				}
+			}
+			ttl := time.Duration(optionInt(call.Argument(2), "ttl")) * time.Second
+
+			value, err := store.Incr(script, key, delta, ttl)
+			if err != nil {
+				throwStoreError(call, err)
+			}
+
+			result, _ := call.Otto.ToValue(value)
+			return result
+		},
+		// delete removes a key and returns whether it existed
+		"delete": func(call otto.FunctionCall) otto.Value {
+			deleted, err := store.Delete(script, storeKey(call))
+			if err != nil {
+				throwStoreError(call, err)
+			}
+
+			result, _ := call.Otto.ToValue(deleted)
+			return result
+		},
+		// ttl returns the whole seconds left before a key expires, -1 if it never does and null
+		// if it doesn't exist
+		"ttl": func(call otto.FunctionCall) otto.Value {
+			ttl, exists, err := store.TTL(script, storeKey(call))
+			if err != nil {
+				throwStoreError(call, err)
+			}
+			if !exists {
+				return otto.NullValue()
+			}
+			if ttl == 0 {
+				result, _ := call.Otto.ToValue(-1)
+				return result
+			}
+
+			result, _ := call.Otto.ToValue(math.Ceil(ttl.Seconds()))
+			return result
+		},
+		// expire makes a key expire after the given seconds, or never if there are none, and
+		// returns whether it exists
+		"expire": func(call otto.FunctionCall) otto.Value {
+			seconds, _ := call.Argument(1).ToFloat()
+			exists, err := store.Expire(script, storeKey(call), time.Duration(seconds*float64(time.Second)))
+			if err != nil {
+				throwStoreError(call, err)
+			}
+
+			result, _ := call.Otto.ToValue(exists)
+			return result
+		},
+	}
+}
+
+// storeKey returns the key argument of a store function, which must not be empty
+func storeKey(call otto.FunctionCall) string {
+	key := call.Argument(0)
+	if !key.IsDefined() || key.IsNull() || key.String() == "" {
+		throwError(call, "TypeError", errors.New("store keys must be non-empty strings"))
+	}
+
+	return key.String()
+}
+
+// storeValue returns the JSON form of a value to store
+func storeValue(call otto.FunctionCall, value otto.Value) json.RawMessage {
+	data, err := call.Otto.Call("JSON.stringify", nil, value)
+	if err != nil {
+		throwError(call, "TypeError", err)
+	}
+	if !data.IsString() {
+		throwError(call, "TypeError", errors.New("store values must be JSON serializable"))
+	}
+
+	return json.RawMessage(data.String())
+}
+
+// throwStoreError raises a store failure, with StoreQuotaError for an exceeded quota
+func throwStoreError(call otto.FunctionCall, err error) {
+	switch {
+	case errors.Is(err, ErrStoreQuotaExceeded):
+		throwError(call, "StoreQuotaError", err)
+	case errors.Is(err, ErrStoreNotNumber):
+		throwError(call, "TypeError", err)
+	}
+	throwError(call, "Error", err)
+}
@@ -0,0 +1,246 @@
+package scripting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// specSuffix replaces the .js suffix of a script to name its sidecar spec
+const specSuffix = ".spec.json"
+
+// TestSpec is the sidecar spec of a script, next to it as name.spec.json. It describes
+// the cases run by the test runner against the script with mocked bindings.
+type TestSpec struct {
+	// Now is the time of the fake clock when a case starts, the Unix epoch if unset
+	Now time.Time `json:"now"`
+	// Seed seeds Math.random, so random decisions are repeatable
+	Seed int64 `json:"seed"`
+	// Responses are returned by the mocked http.request, by URL
+	Responses map[string]HTTPResponse `json:"responses"`
+	// Store holds the keys of the store when a case starts
+	Store map[string]interface{} `json:"store"`
+	Cases []TestCase             `json:"cases"`
+}
+
+// TestCase feeds the events of a fixture to a hook, or runs a scheduled job, and checks
+// the expectations
+type TestCase struct {
+	Name string `json:"name"`
+	Hook string `json:"hook"`
+	// Job is the name of a job the script schedules, run once after the events
+	Job string `json:"job"`
+	// Fixture is a JSON file, relative to the spec, holding an array of events. An event
+	// is an array of hook arguments, or any other value passed as the single argument.
+	Fixture string          `json:"fixture"`
+	Events  []interface{}   `json:"events"`
+	Expect  TestExpectation `json:"expect"`
+}
+
+// TestExpectation is what a case expects from the script
+type TestExpectation struct {
+	// Calls must be made in this order, other calls may come in between. Args are
+	// compared when given.
+	Calls []RecordedCall `json:"calls"`
+	// NoCalls are bindings that must not be called
+	NoCalls []string `json:"noCalls"`
+	// Output holds substrings of the console output
+	Output []string `json:"output"`
+	// Error is a substring of the error a hook must fail with
+	Error string `json:"error"`
+}
+
+// RecordedCall is a call of a mocked binding
+type RecordedCall struct {
+	Binding string        `json:"binding"`
+	Args    []interface{} `json:"args,omitempty"`
+}
+
+// TestResult is the outcome of a case
+type TestResult struct {
+	Case     string
+	Failures []string
+	Calls    []RecordedCall
+	Output   string
+}
+
+// Passed reports whether the case met its expectations
+func (r TestResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// SpecPath returns the path of the sidecar spec of a script
+func SpecPath(scriptPath string) string {
+	return strings.TrimSuffix(scriptPath, filepath.Ext(scriptPath)) + specSuffix
+}
+
+// LoadTestSpec reads a spec and the fixtures of its cases
+func LoadTestSpec(path string) (*TestSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec TestSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse spec %s: %w", path, err)
+	}
+
+	for i, test := range spec.Cases {
+		if test.Fixture == "" {
+			continue
+		}
+		fixture := test.Fixture
+		if !filepath.IsAbs(fixture) {
+			fixture = filepath.Join(filepath.Dir(path), fixture)
+		}
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture of case %q: %w", test.Name, err)
+		}
+		var events []interface{}
+		if err := json.Unmarshal(data, &events); err != nil {
+			return nil, fmt.Errorf("failed to parse fixture %s: %w", fixture, err)
+		}
+		spec.Cases[i].Events = append(events, test.Events...)
+	}
+
+	return &spec, nil
+}
+
+// RunScriptTests runs each case of spec on a fresh engine with mocked bindings: the script
+// is loaded, the events are passed to the hook, and the recorded calls and output are
+// checked against the expectations. The script can require the modules of libraries. An
+// error is returned when the script can't be loaded.
+func RunScriptTests(title string, source string, spec *TestSpec, libraries map[string]string, timeoutMs int) ([]TestResult, error) {
+	results := make([]TestResult, 0, len(spec.Cases))
+	for _, test := range spec.Cases {
+		mock := newBindingMock(spec)
+		engine, err := newMockEngine(mock, libraries, timeoutMs)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := engine.Execute(source); err != nil {
+			return nil, fmt.Errorf("failed to load script %q: %w", title, err)
+		}
+
+		var hookErr error
+		for i, event := range test.Events {
+			if test.Hook == "" {
+				break
+			}
+			args, ok := event.([]interface{})
+			if !ok {
+				args = []interface{}{event}
+			}
+			exists, err := engine.CallHook(test.Hook, args...)
+			if !exists && err == nil {
+				hookErr = fmt.Errorf("the script doesn't define %s", test.Hook)
+				break
+			}
+			if err != nil {
+				hookErr = fmt.Errorf("event %d: %w", i, err)
+				break
+			}
+		}
+
+		if test.Job != "" && hookErr == nil {
+			hookErr = engine.RunJob(test.Job)
+		}
+
+		result := TestResult{Case: test.Name, Calls: mock.calls, Output: mock.output.String()}
+		result.Failures = checkExpectation(test.Expect, result, hookErr)
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// checkExpectation returns the unmet expectations of a case
+func checkExpectation(expect TestExpectation, result TestResult, hookErr error) []string {
+	var failures []string
+
+	switch {
+	case expect.Error == "" && hookErr != nil:
+		failures = append(failures, fmt.Sprintf("unexpected error: %v", hookErr))
+	case expect.Error != "" && hookErr == nil:
+		failures = append(failures, fmt.Sprintf("expected an error containing %q", expect.Error))
+	case expect.Error != "" && !strings.Contains(hookErr.Error(), expect.Error):
+		failures = append(failures, fmt.Sprintf("expected an error containing %q, got: %v", expect.Error, hookErr))
+	}
+
+	next := 0
+	for _, call := range result.Calls {
+		if next < len(expect.Calls) && callMatches(expect.Calls[next], call) {
+			next++
+		}
+	}
+	for _, call := range expect.Calls[next:] {
+		failures = append(failures, fmt.Sprintf("expected call %s(%s)", call.Binding, formatArgs(call.Args)))
+	}
+
+	for _, binding := range expect.NoCalls {
+		for _, call := range result.Calls {
+			if call.Binding == binding {
+				failures = append(failures, fmt.Sprintf("unexpected call %s(%s)", call.Binding, formatArgs(call.Args)))
+			}
+		}
+	}
+
+	for _, output := range expect.Output {
+		if !strings.Contains(result.Output, output) {
+			failures = append(failures, fmt.Sprintf("expected output containing %q", output))
+		}
+	}
+
+	return failures
+}
+
+// callMatches reports whether a recorded call matches an expected one. The arguments are
+// compared in their JSON form, and only those that are expected.
+func callMatches(expected RecordedCall, call RecordedCall) bool {
+	if expected.Binding != call.Binding {
+		return false
+	}
+	if len(expected.Args) > len(call.Args) {
+		return false
+	}
+
+	for i, arg := range expected.Args {
+		if !reflect.DeepEqual(normalizeJSON(arg), normalizeJSON(call.Args[i])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeJSON converts a value to its decoded JSON form, so numbers and maps compare equal
+func normalizeJSON(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return v
+	}
+
+	return normalized
+}
+
+// formatArgs formats call arguments as JSON
+func formatArgs(args []interface{}) string {
+	formatted := make([]string, 0, len(args))
+	for _, arg := range args {
+		data, _ := json.Marshal(arg)
+		formatted = append(formatted, string(data))
+	}
+
+	return strings.Join(formatted, ", ")
+}
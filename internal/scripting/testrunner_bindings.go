@@ -0,0 +1,317 @@
+package scripting
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// fakeDateSource replaces Date with a constructor reading the fake clock
+const fakeDateSource = `(function(now) {
+	var RealDate = Date;
+	function FakeDate(year, month, day, hours, minutes, seconds, ms) {
+		if (!(this instanceof FakeDate)) {
+			return new RealDate(now()).toString();
+		}
+		switch (arguments.length) {
+		case 0:
+			return new RealDate(now());
+		case 1:
+			return new RealDate(year);
+		default:
+			return new RealDate(year, month, day === undefined ? 1 : day, hours || 0, minutes || 0, seconds || 0, ms || 0);
+		}
+	}
+	FakeDate.prototype = RealDate.prototype;
+	FakeDate.now = function() { return now(); };
+	FakeDate.parse = RealDate.parse;
+	FakeDate.UTC = RealDate.UTC;
+	Date = FakeDate;
+})`
+
+// bindingMock stands in for the bindings in the test runner. It records the calls, keeps
+// retentions, files and the store in memory, and drives the fake clock and the seeded random generator.
+type bindingMock struct {
+	spec     *TestSpec
+	clock    time.Time
+	random   *rand.Rand
+	calls    []RecordedCall
+	retained map[string]bool
+	files    map[string]string
+	store    *ScriptStore
+	output   strings.Builder
+}
+
+// newBindingMock creates the mock of a case, starting at the spec's time and seed
+func newBindingMock(spec *TestSpec) *bindingMock {
+	clock := spec.Now
+	if clock.IsZero() {
+		clock = time.Unix(0, 0)
+	}
+	seed := spec.Seed
+	if seed == 0 {
+		seed = 1
+	}
+
+	m := &bindingMock{
+		spec:     spec,
+		clock:    clock,
+		random:   rand.New(rand.NewSource(seed)),
+		retained: make(map[string]bool),
+		files:    make(map[string]string),
+	}
+
+	// The store is kept in memory, expiring keys on the fake clock
+	m.store, _ = NewScriptStore("", 0)
+	m.store.now = func() time.Time { return m.clock }
+	for key, value := range spec.Store {
+		data, err := json.Marshal(value)
+		if err == nil {
+			err = m.store.Set("", key, data, 0)
+		}
+		if err != nil {
+			m.output.WriteString(fmt.Sprintf("failed to seed store key %s: %v\n", key, err))
+		}
+	}
+
+	return m
+}
+
+// newMockEngine creates an engine whose bindings are mocked, requiring the modules of libraries
+func newMockEngine(mock *bindingMock, libraries map[string]string, timeoutMs int) (*ScriptEngine, error) {
+	engine := &ScriptEngine{
+		vm:         newVM(),
+		pcapHelper: NewPcapHelper(nil),
+		timeout:    time.Duration(timeoutMs) * time.Millisecond,
+		meter:      &budgetMeter{},
+		libraries:  libraries,
+		register:   mock.register,
+	}
+	if err := engine.registerBindings(engine.pcapHelper); err != nil {
+		return nil, fmt.Errorf("failed to register mocked bindings: %w", err)
+	}
+
+	return engine, nil
+}
+
+// register registers the mocked bindings on vm
+func (m *bindingMock) register(vm *otto.Otto, meter *budgetMeter) error {
+	for _, register := range []func(vm *otto.Otto) error{
+		m.registerPcap,
+		m.registerHTTP,
+		m.registerFs,
+		m.registerStore,
+		m.registerConsole,
+		m.registerRuntime,
+	} {
+		if err := register(vm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// record records a call and its arguments
+func (m *bindingMock) record(binding string, call otto.FunctionCall) {
+	args := make([]interface{}, 0, len(call.ArgumentList))
+	for _, arg := range call.ArgumentList {
+		exported, _ := arg.Export()
+		args = append(args, exported)
+	}
+
+	m.calls = append(m.calls, RecordedCall{Binding: binding, Args: args})
+}
+
+// registerPcap mocks the pcap object. Retentions are kept in memory and reads find no packets.
+func (m *bindingMock) registerPcap(vm *otto.Otto) error {
+	return setFunctions(vm, "pcap", map[string]func(call otto.FunctionCall) otto.Value{
+		"retain": func(call otto.FunctionCall) otto.Value {
+			m.record("pcap.retain", call)
+			m.retained[call.Argument(0).String()] = true
+			return otto.UndefinedValue()
+		},
+		"isRetained": func(call otto.FunctionCall) otto.Value {
+			m.record("pcap.isRetained", call)
+			result, _ := call.Otto.ToValue(m.retained[call.Argument(0).String()])
+			return result
+		},
+		"listRetentions": func(call otto.FunctionCall) otto.Value {
+			m.record("pcap.listRetentions", call)
+			names := make([]string, 0, len(m.retained))
+			for name := range m.retained {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			retentions := make([]map[string]interface{}, 0, len(names))
+			for _, name := range names {
+				retentions = append(retentions, map[string]interface{}{"name": name})
+			}
+			return toJSValue(call, retentions)
+		},
+		"getPcapPath": func(call otto.FunctionCall) otto.Value {
+			m.record("pcap.getPcapPath", call)
+			name := call.Argument(0).String()
+			if !strings.HasSuffix(name, ".pcap") {
+				name += ".pcap"
+			}
+			result, _ := call.Otto.ToValue("/pcaps/" + name)
+			return result
+		},
+		"snapshot": func(call otto.FunctionCall) otto.Value {
+			m.record("pcap.snapshot", call)
+			name := call.Argument(0).String()
+			if !strings.HasSuffix(name, ".pcap") {
+				name += ".pcap"
+			}
+			m.retained[name] = true
+			return toJSValue(call, map[string]interface{}{
+				"name": name, "path": "/pcaps/snapshots/" + name, "packets": 0, "size": 0,
+			})
+		},
+		"read": func(call otto.FunctionCall) otto.Value {
+			m.record("pcap.read", call)
+			iterator, _ := call.Otto.Object("({next: function() { return null }, close: function() {}, forEach: function() {}})")
+			return iterator.Value()
+		},
+	})
+}
+
+// registerHTTP mocks http.request with the spec's responses, other URLs get an empty 200
+func (m *bindingMock) registerHTTP(vm *otto.Otto) error {
+	return setFunctions(vm, "http", map[string]func(call otto.FunctionCall) otto.Value{
+		"request": func(call otto.FunctionCall) otto.Value {
+			m.record("http.request", call)
+			response, ok := m.spec.Responses[optionString(call.Argument(0), "url")]
+			if !ok {
+				response = HTTPResponse{Status: 200, Headers: map[string]string{}}
+			}
+			return toJSValue(call, response)
+		},
+	})
+}
+
+// registerFs mocks the fs object with files kept in memory
+func (m *bindingMock) registerFs(vm *otto.Otto) error {
+	info := func(name string, data string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "size": len(data), "dir": false, "modified": m.clock.UnixMilli()}
+	}
+
+	return setFunctions(vm, "fs", map[string]func(call otto.FunctionCall) otto.Value{
+		"read": func(call otto.FunctionCall) otto.Value {
+			m.record("fs.read", call)
+			data, ok := m.files[call.Argument(0).String()]
+			if !ok {
+				throwError(call, "Error", fmt.Errorf("%s does not exist", call.Argument(0).String()))
+			}
+			result, _ := call.Otto.ToValue(data)
+			return result
+		},
+		"write": func(call otto.FunctionCall) otto.Value {
+			m.record("fs.write", call)
+			path := call.Argument(0).String()
+			data := string(fileData(call, call.Argument(1)))
+			if options := call.Argument(2); options.IsObject() {
+				if value, err := options.Object().Get("append"); err == nil {
+					if appendData, _ := value.ToBoolean(); appendData {
+						data = m.files[path] + data
+					}
+				}
+			}
+			m.files[path] = data
+			return otto.UndefinedValue()
+		},
+		"list": func(call otto.FunctionCall) otto.Value {
+			m.record("fs.list", call)
+			paths := make([]string, 0, len(m.files))
+			for path := range m.files {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			entries := make([]map[string]interface{}, 0, len(paths))
+			for _, path := range paths {
+				entries = append(entries, info(path, m.files[path]))
+			}
+			return toJSValue(call, entries)
+		},
+		"stat": func(call otto.FunctionCall) otto.Value {
+			m.record("fs.stat", call)
+			data, ok := m.files[call.Argument(0).String()]
+			if !ok {
+				return otto.NullValue()
+			}
+			return toJSValue(call, info(call.Argument(0).String(), data))
+		},
+		"copy": func(call otto.FunctionCall) otto.Value {
+			m.record("fs.copy", call)
+			data := m.files[call.Argument(0).String()]
+			m.files[call.Argument(1).String()] = data
+			result, _ := call.Otto.ToValue(len(data))
+			return result
+		},
+	})
+}
+
+// registerStore registers the store functions on the in-memory store, recording their calls
+func (m *bindingMock) registerStore(vm *otto.Otto) error {
+	functions := storeFunctions(m.store, "")
+	for name, function := range functions {
+		binding, function := "store."+name, function
+		functions[name] = func(call otto.FunctionCall) otto.Value {
+			m.record(binding, call)
+			return function(call)
+		}
+	}
+
+	return setFunctions(vm, "store", functions)
+}
+
+// registerConsole records the console output as the text of its records, such as
+// "WARN slow response path=\"/a\""
+func (m *bindingMock) registerConsole(vm *otto.Otto) error {
+	functions := make(map[string]func(call otto.FunctionCall) otto.Value)
+	for name, level := range consoleFunctions {
+		level, withFields := level, consoleTakesFields(name)
+		functions[name] = func(call otto.FunctionCall) otto.Value {
+			m.output.WriteString(consoleRecord(call, "", level, withFields, m.clock).Text() + "\n")
+			return otto.UndefinedValue()
+		}
+	}
+
+	return setFunctions(vm, "console", functions)
+}
+
+// registerRuntime installs the fake clock, which sleep advances, and the seeded Math.random
+func (m *bindingMock) registerRuntime(vm *otto.Otto) error {
+	err := vm.Set("sleep", func(call otto.FunctionCall) otto.Value {
+		m.record("sleep", call)
+		ms, _ := call.Argument(0).ToInteger()
+		m.clock = m.clock.Add(time.Duration(ms) * time.Millisecond)
+		return otto.UndefinedValue()
+	})
+	if err != nil {
+		return err
+	}
+
+	now := func(call otto.FunctionCall) otto.Value {
+		result, _ := call.Otto.ToValue(m.clock.UnixMilli())
+		return result
+	}
+	if _, err := vm.Call(fakeDateSource, nil, now); err != nil {
+		return fmt.Errorf("failed to install the fake clock: %w", err)
+	}
+
+	math, err := vm.Get("Math")
+	if err != nil {
+		return err
+	}
+	return math.Object().Set("random", func(call otto.FunctionCall) otto.Value {
+		result, _ := call.Otto.ToValue(m.random.Float64())
+		return result
+	})
+}
@@ -0,0 +1,98 @@
+package scripting
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunScriptTests(t *testing.T) {
+	dir := t.TempDir()
+	script := `
+		function onItemCaptured(item) {
+			if (item.status >= 500 && Math.random() < 2) {
+				pcap.retain(item.stream + ".pcap", 3600);
+				console.log("retained", item.stream, "at", Date.now());
+			}
+			sleep(1000);
+		}
+		jobs.schedule("summary", "@hourly", function() {
+			console.log("summary at", new Date().toISOString(), store.incr("runs"));
+		});
+	`
+	files := map[string]string{
+		"errors.js": script,
+		"fixtures/items.json": `[
+			{"stream": "a", "status": 200},
+			[{"stream": "b", "status": 503}]
+		]`,
+		"errors.spec.json": `{
+			"now": "2024-01-01T00:00:00Z",
+			"seed": 7,
+			"store": {"runs": 2},
+			"cases": [
+				{
+					"name": "retains failed requests",
+					"hook": "onItemCaptured",
+					"fixture": "fixtures/items.json",
+					"expect": {
+						"calls": [{"binding": "pcap.retain", "args": ["b.pcap", 3600]}, {"binding": "sleep"}],
+						"output": ["retained b at 1704067201000"]
+					}
+				},
+				{
+					"name": "wrong expectation",
+					"hook": "onItemCaptured",
+					"events": [{"stream": "c", "status": 500}],
+					"expect": {"noCalls": ["pcap.retain"]}
+				},
+				{
+					"name": "hourly summary",
+					"job": "summary",
+					"expect": {"output": ["summary at 2024-01-01T00:00:00.000Z 3"]}
+				},
+				{
+					"name": "missing hook",
+					"hook": "onPcapEvicted",
+					"events": [["a.pcap", {}]],
+					"expect": {"error": "doesn't define onPcapEvicted"}
+				}
+			]
+		}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	spec, err := LoadTestSpec(SpecPath(filepath.Join(dir, "errors.js")))
+	if err != nil {
+		t.Fatalf("Failed to load spec: %v", err)
+	}
+	results, err := RunScriptTests("errors", script, spec, nil, 1000)
+	if err != nil {
+		t.Fatalf("Failed to run tests: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+
+	if !results[0].Passed() {
+		t.Errorf("Expected the first case to pass, got %v", results[0].Failures)
+	}
+	if results[1].Passed() || !strings.Contains(results[1].Failures[0], "unexpected call pcap.retain") {
+		t.Errorf("Expected the second case to fail on the retain call, got %v", results[1].Failures)
+	}
+	if !results[2].Passed() {
+		t.Errorf("Expected the job to run on the fake clock, got %v", results[2].Failures)
+	}
+	if !results[3].Passed() {
+		t.Errorf("Expected the missing hook to be reported as an error, got %v", results[3].Failures)
+	}
+}
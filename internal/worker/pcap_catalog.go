@@ -0,0 +1,472 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/btree"
+	"github.com/rs/zerolog/log"
+)
+
+// PcapEntry describes a PCAP file known to the catalog
+type PcapEntry struct {
+	StreamID        string    `json:"streamId"`
+	Name            string    `json:"name"`
+	Path            string    `json:"path"`
+	Size            int64     `json:"size"`
+	ModTime         time.Time `json:"modTime"`
+	FirstPacketTime time.Time `json:"firstPacketTime"`
+	LastPacketTime  time.Time `json:"lastPacketTime"`
+	Cold            bool      `json:"cold"`
+	Retained        bool      `json:"retained"`
+	Class           string    `json:"class,omitempty"`
+
+	// header and scanOffset allow rescanning only the records appended since the last scan
+	header     *pcapHeader
+	scanOffset int64
+}
+
+// PcapCatalog is an in-memory index of the PCAP files in the warm and cold tiers.
+// Entries are keyed by path, indexed by stream ID, and kept in a B-tree ordered by modification
+// time, so lookups and usage totals are O(1), and entries are indexed and cleanup candidates
+// found in O(log n).
+type PcapCatalog struct {
+	entries   map[string]*PcapEntry
+	byStream  map[string]string
+	byAge     *btree.BTree
+	totalSize int64
+	mu        sync.RWMutex
+
+	// onAdd is called, after the catalog is unlocked, for every file newly indexed in the warm tier
+	onAdd func(PcapEntry)
+}
+
+// ageItem orders the entries of the age index by modification time, then by path
+type ageItem struct {
+	entry *PcapEntry
+}
+
+// Less implements btree.Item
+func (a ageItem) Less(than btree.Item) bool {
+	b := than.(ageItem)
+	if !a.entry.ModTime.Equal(b.entry.ModTime) {
+		return a.entry.ModTime.Before(b.entry.ModTime)
+	}
+
+	return a.entry.Path < b.entry.Path
+}
+
+// ageIndexDegree is the degree of the age index B-tree
+const ageIndexDegree = 32
+
+// newPcapCatalog creates an empty catalog
+func newPcapCatalog() *PcapCatalog {
+	return &PcapCatalog{
+		entries:  make(map[string]*PcapEntry),
+		byStream: make(map[string]string),
+		byAge:    btree.New(ageIndexDegree),
+	}
+}
+
+// pcapStreamID derives the stream ID from a PCAP file name
+func pcapStreamID(pcapName string) string {
+	return strings.TrimSuffix(pcapName, filepath.Ext(pcapName))
+}
+
+// isCatalogFile reports whether a file in the warm or cold tier should be indexed
+func isCatalogFile(name string, cold bool) bool {
+	if strings.HasSuffix(name, ".tmp") || strings.HasPrefix(name, ".") {
+		return false
+	}
+	if cold {
+		return strings.HasSuffix(name, coldSuffix)
+	}
+
+	return true
+}
+
+// scanDir indexes every file at the top level of dir. A missing directory is not an error.
+func (c *PcapCatalog) scanDir(dir string, cold bool) error {
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !isCatalogFile(file.Name(), cold) {
+			continue
+		}
+
+		if _, err := c.upsert(filepath.Join(dir, file.Name()), cold); err != nil {
+			log.Debug().Err(err).Str("file", file.Name()).Msg("Failed to index PCAP file")
+		}
+	}
+
+	return nil
+}
+
+// upsert indexes the file at path, or refreshes its entry if it is already known.
+// The file is read without holding the lock, on a copy of the entry's scan state.
+func (c *PcapCatalog) upsert(path string, cold bool) (PcapEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return PcapEntry{}, err
+	}
+	if info.IsDir() {
+		return PcapEntry{}, fmt.Errorf("%s is a directory", path)
+	}
+
+	scan := PcapEntry{Path: path}
+	c.mu.RLock()
+	if entry, exists := c.entries[path]; exists {
+		scan = *entry
+	}
+	c.mu.RUnlock()
+	header, offset := scan.header, scan.scanOffset
+
+	// A shrinking file was replaced or truncated, so its records are rescanned
+	if info.Size() < scan.Size {
+		scan.header = nil
+		scan.scanOffset = 0
+		scan.FirstPacketTime = time.Time{}
+		scan.LastPacketTime = time.Time{}
+	}
+
+	// Packet times of compressed files are carried over when they are moved to the cold tier
+	if !cold {
+		if err := scanPcapTimes(&scan); err != nil {
+			log.Debug().Err(err).Str("file", path).Msg("Failed to read PCAP packet times")
+		}
+	}
+
+	c.mu.Lock()
+	entry, exists := c.entries[path]
+	if exists {
+		c.unlinkAge(entry)
+		c.totalSize -= entry.Size
+	} else {
+		name := filepath.Base(path)
+		if cold {
+			name = strings.TrimSuffix(name, coldSuffix)
+		}
+		entry = &PcapEntry{
+			StreamID: pcapStreamID(name),
+			Name:     name,
+			Path:     path,
+			Cold:     cold,
+		}
+		c.entries[path] = entry
+		c.indexStream(entry)
+	}
+	added := !exists
+
+	// The scan is dropped if a concurrent upsert advanced the entry meanwhile, the next one catches up
+	if entry.header == header && entry.scanOffset == offset {
+		entry.header = scan.header
+		entry.scanOffset = scan.scanOffset
+		entry.FirstPacketTime = scan.FirstPacketTime
+		entry.LastPacketTime = scan.LastPacketTime
+	}
+
+	entry.Size = info.Size()
+	entry.ModTime = info.ModTime()
+	c.totalSize += entry.Size
+	c.linkAge(entry)
+
+	indexed := *entry
+	onAdd := c.onAdd
+	c.mu.Unlock()
+
+	if added && !cold && onAdd != nil {
+		onAdd(indexed)
+	}
+
+	return indexed, nil
+}
+
+// move re-keys an entry after its file was moved, keeping its packet times
+func (c *PcapCatalog) move(oldPath string, newPath string, cold bool) {
+	c.mu.Lock()
+	var first, last time.Time
+	if entry, exists := c.entries[oldPath]; exists {
+		first, last = entry.FirstPacketTime, entry.LastPacketTime
+	}
+	c.mu.Unlock()
+
+	c.remove(oldPath)
+
+	if _, err := c.upsert(newPath, cold); err != nil {
+		log.Debug().Err(err).Str("file", newPath).Msg("Failed to index PCAP file")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if moved, exists := c.entries[newPath]; exists && moved.FirstPacketTime.IsZero() {
+		moved.FirstPacketTime = first
+		moved.LastPacketTime = last
+	}
+}
+
+// remove drops the entry for path, if any
+func (c *PcapCatalog) remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[path]
+	if !exists {
+		return
+	}
+
+	c.unlinkAge(entry)
+	c.totalSize -= entry.Size
+	delete(c.entries, path)
+	for _, key := range streamKeys(entry) {
+		if c.byStream[key] == path {
+			delete(c.byStream, key)
+		}
+	}
+}
+
+// replace swaps the content of the catalog with the content of other
+func (c *PcapCatalog) replace(other *PcapCatalog) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = other.entries
+	c.byStream = other.byStream
+	c.byAge = other.byAge
+	c.totalSize = other.totalSize
+}
+
+// Lookup returns the entry of a stream. Both the full stream ID (e.g. 000000000123_udp)
+// and its leading segment (e.g. 000000000123) are accepted.
+func (c *PcapCatalog) Lookup(streamID string) (PcapEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	path, exists := c.byStream[streamID]
+	if !exists {
+		return PcapEntry{}, false
+	}
+
+	return *c.entries[path], true
+}
+
+// Get returns the entry of a PCAP file by name
+func (c *PcapCatalog) Get(pcapName string) (PcapEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	path, exists := c.byStream[pcapName]
+	if !exists || c.entries[path].Name != pcapName {
+		return PcapEntry{}, false
+	}
+
+	return *c.entries[path], true
+}
+
+// entryAt returns the entry of the file at path
+func (c *PcapCatalog) entryAt(path string) (PcapEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.entries[path]
+	if !exists {
+		return PcapEntry{}, false
+	}
+
+	return *entry, true
+}
+
+// TotalSize returns the total size of the indexed files
+func (c *PcapCatalog) TotalSize() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.totalSize
+}
+
+// Len returns the number of indexed files
+func (c *PcapCatalog) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.entries)
+}
+
+// List returns all entries, oldest first
+func (c *PcapCatalog) List() []PcapEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make([]PcapEntry, 0, c.byAge.Len())
+	c.byAge.Ascend(func(item btree.Item) bool {
+		entries = append(entries, *item.(ageItem).entry)
+		return true
+	})
+
+	return entries
+}
+
+// ModifiedBefore returns the entries last modified before cutoff, oldest first
+func (c *PcapCatalog) ModifiedBefore(cutoff time.Time) []PcapEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var entries []PcapEntry
+	c.byAge.AscendLessThan(ageItem{&PcapEntry{ModTime: cutoff}}, func(item btree.Item) bool {
+		entries = append(entries, *item.(ageItem).entry)
+		return true
+	})
+
+	return entries
+}
+
+// watch keeps the catalog current with the given directories until ctx is done
+func (c *PcapCatalog) watch(ctx context.Context, warmDir string, coldDir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(warmDir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", warmDir, err)
+	}
+	if coldDir != "" {
+		if err := watcher.Add(coldDir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %s: %w", coldDir, err)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Debug().Msg("PCAP catalog watcher exiting gracefully.")
+				return
+
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				cold := coldDir != "" && filepath.Dir(event.Name) == filepath.Clean(coldDir)
+				if !isCatalogFile(filepath.Base(event.Name), cold) {
+					continue
+				}
+
+				switch {
+				case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+					c.remove(event.Name)
+				case event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) != 0:
+					if _, err := c.upsert(event.Name, cold); err != nil && !errors.Is(err, os.ErrNotExist) {
+						log.Debug().Err(err).Str("file", event.Name).Msg("Failed to index PCAP file")
+					}
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Err(err).Msg("PCAP catalog watcher error encountered")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// indexStream maps the stream keys of entry to its path. The stream ID and file name
+// always point to the latest entry, the leading segment only if it is not taken yet.
+func (c *PcapCatalog) indexStream(entry *PcapEntry) {
+	keys := streamKeys(entry)
+	c.byStream[keys[0]] = entry.Path
+	c.byStream[keys[1]] = entry.Path
+	for _, key := range keys[2:] {
+		if _, taken := c.byStream[key]; !taken {
+			c.byStream[key] = entry.Path
+		}
+	}
+}
+
+// streamKeys returns the keys under which an entry is indexed: its stream ID, its file name and the stream ID's leading segment
+func streamKeys(entry *PcapEntry) []string {
+	keys := []string{entry.StreamID, entry.Name}
+	if idx := strings.Index(entry.StreamID, "_"); idx > 0 {
+		keys = append(keys, entry.StreamID[:idx])
+	}
+
+	return keys
+}
+
+// linkAge inserts entry into the age index. Its modification time must not change while it is linked.
+func (c *PcapCatalog) linkAge(entry *PcapEntry) {
+	c.byAge.ReplaceOrInsert(ageItem{entry})
+}
+
+// unlinkAge removes entry from the age index
+func (c *PcapCatalog) unlinkAge(entry *PcapEntry) {
+	c.byAge.Delete(ageItem{entry})
+}
+
+// scanPcapTimes reads the record headers appended since the previous scan and
+// updates the first and last packet times of entry
+func scanPcapTimes(entry *PcapEntry) error {
+	file, err := os.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if entry.header == nil {
+		header, err := readPcapHeader(file)
+		if err != nil {
+			return err
+		}
+		entry.header = &header
+		entry.scanOffset = pcapGlobalHeaderLen
+	}
+
+	if _, err := file.Seek(entry.scanOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	buf := make([]byte, pcapRecordHeaderLen)
+	for {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			// A partial record is still being written, it is picked up by the next scan
+			return nil
+		}
+
+		record := entry.header.parseRecord(buf)
+		if !entry.header.validRecord(record) {
+			return fmt.Errorf("invalid pcap record at offset %d", entry.scanOffset)
+		}
+
+		if _, err := reader.Discard(int(record.inclLen)); err != nil {
+			return nil
+		}
+
+		if entry.FirstPacketTime.IsZero() {
+			entry.FirstPacketTime = record.timestamp
+		}
+		entry.LastPacketTime = record.timestamp
+		entry.scanOffset += pcapRecordHeaderLen + int64(record.inclLen)
+	}
+}
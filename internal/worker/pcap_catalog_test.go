@@ -0,0 +1,179 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/gopacket"
+	"github.com/kubeshark/gopacket/layers"
+	"github.com/kubeshark/gopacket/pcapgo"
+)
+
+// writeTestPcap writes a pcap file with one small packet per timestamp
+func writeTestPcap(t *testing.T, path string, timestamps ...time.Time) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create test pcap: %v", err)
+	}
+	defer file.Close()
+
+	writer := pcapgo.NewWriter(file)
+	if err := writer.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Failed to write pcap header: %v", err)
+	}
+
+	data := make([]byte, 60)
+	for _, ts := range timestamps {
+		ci := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(data), Length: len(data)}
+		if err := writer.WritePacket(ci, data); err != nil {
+			t.Fatalf("Failed to write packet: %v", err)
+		}
+	}
+}
+
+func TestCatalogLookup(t *testing.T) {
+	tempDir := t.TempDir()
+
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	last := first.Add(5 * time.Second)
+	writeTestPcap(t, filepath.Join(tempDir, "000000000123_udp.pcap"), first, first.Add(time.Second), last)
+	writeTestPcap(t, filepath.Join(tempDir, "000000000456_tcp.pcap"), first)
+
+	manager := NewPcapManager(tempDir, time.Minute, 1024*1024)
+
+	entry, ok := manager.LookupPcap("000000000123_udp")
+	if !ok {
+		t.Fatalf("Stream should be found by its full ID")
+	}
+	if !entry.FirstPacketTime.Equal(first) || !entry.LastPacketTime.Equal(last) {
+		t.Errorf("Unexpected packet times: first=%v last=%v", entry.FirstPacketTime, entry.LastPacketTime)
+	}
+
+	path, ok := manager.ResolvePcapPath("000000000123")
+	if !ok || path != filepath.Join(tempDir, "000000000123_udp.pcap") {
+		t.Errorf("Stream should resolve by its leading segment, got %q", path)
+	}
+
+	if _, ok := manager.ResolvePcapPath("000000000789"); ok {
+		t.Errorf("Unknown stream should not resolve")
+	}
+
+	_ = manager.RetainPcap("000000000456_tcp.pcap", time.Hour)
+	entry, _ = manager.LookupPcap("000000000456_tcp")
+	if !entry.Retained {
+		t.Errorf("Lookup should report the retention status")
+	}
+
+	usage, _ := manager.GetStorageUsage()
+	var expected int64
+	for _, name := range []string{"000000000123_udp.pcap", "000000000456_tcp.pcap"} {
+		info, _ := os.Stat(filepath.Join(tempDir, name))
+		expected += info.Size()
+	}
+	if usage != expected {
+		t.Errorf("Expected storage usage %d, got %d", expected, usage)
+	}
+}
+
+func TestCatalogCleanupCandidates(t *testing.T) {
+	catalog := newPcapCatalog()
+	tempDir := t.TempDir()
+
+	now := time.Now()
+	for i, name := range []string{"a.pcap", "b.pcap", "c.pcap"} {
+		path := filepath.Join(tempDir, name)
+		writeTestPcap(t, path, now)
+		modTime := now.Add(time.Duration(-3+i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set file time: %v", err)
+		}
+		if _, err := catalog.upsert(path, false); err != nil {
+			t.Fatalf("upsert failed: %v", err)
+		}
+	}
+
+	candidates := catalog.ModifiedBefore(now.Add(-90 * time.Second))
+	if len(candidates) != 2 || candidates[0].Name != "a.pcap" || candidates[1].Name != "b.pcap" {
+		t.Errorf("Expected a.pcap and b.pcap as candidates, got %v", candidates)
+	}
+
+	catalog.remove(filepath.Join(tempDir, "a.pcap"))
+	if catalog.Len() != 2 {
+		t.Errorf("Expected 2 entries after removal, got %d", catalog.Len())
+	}
+	if _, ok := catalog.Lookup("a"); ok {
+		t.Errorf("Removed entry should not be found")
+	}
+}
+
+func TestCatalogOnAddUnlocked(t *testing.T) {
+	catalog := newPcapCatalog()
+	tempDir := t.TempDir()
+
+	// onAdd may read the catalog, which would deadlock if it still held the lock
+	var added []int
+	catalog.onAdd = func(entry PcapEntry) {
+		added = append(added, catalog.Len())
+	}
+
+	path := filepath.Join(tempDir, "a.pcap")
+	writeTestPcap(t, path, time.Now())
+	entry, err := catalog.upsert(path, false)
+	if err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	if _, err := catalog.upsert(path, false); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+
+	if len(added) != 1 || added[0] != 1 {
+		t.Errorf("Expected onAdd once with the entry indexed, got %v", added)
+	}
+	if entry.FirstPacketTime.IsZero() {
+		t.Errorf("Expected the packet times to be scanned")
+	}
+}
+
+func TestCatalogWatch(t *testing.T) {
+	tempDir := t.TempDir()
+	manager := NewPcapManager(tempDir, time.Minute, 1024*1024)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := manager.Watch(ctx); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	path := filepath.Join(tempDir, "000000000777_tcp.pcap")
+	writeTestPcap(t, path, time.Now())
+
+	if !waitFor(func() bool { _, ok := manager.LookupPcap("000000000777_tcp"); return ok }) {
+		t.Fatalf("New PCAP file was not picked up by the catalog")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove test pcap: %v", err)
+	}
+
+	if !waitFor(func() bool { _, ok := manager.LookupPcap("000000000777_tcp"); return !ok }) {
+		t.Fatalf("Removed PCAP file was not dropped from the catalog")
+	}
+}
+
+// waitFor polls cond for up to two seconds
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return cond()
+}
@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PcapMeta describes the stream captured in a PCAP file, as known when the file is registered
+type PcapMeta struct {
+	Error     bool   `json:"error"`
+	Protocol  string `json:"protocol"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	SrcPort   uint16 `json:"srcPort"`
+	DstPort   uint16 `json:"dstPort"`
+}
+
+// RetentionClassSpec is the configured form of a retention class, as set in tap.misc.retentionClasses.
+// Unset criteria match every stream, Namespace and Pod are regular expressions.
+type RetentionClassSpec struct {
+	Name      string   `json:"name"`
+	Error     *bool    `json:"error,omitempty"`
+	Protocols []string `json:"protocols,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	Pod       string   `json:"pod,omitempty"`
+	Ports     []uint16 `json:"ports,omitempty"`
+	TTL       string   `json:"ttl"`
+}
+
+// RetentionClass assigns a TTL to the PCAP files of the streams it matches
+type RetentionClass struct {
+	Name      string
+	TTL       time.Duration
+	error     *bool
+	protocols []string
+	namespace *regexp.Regexp
+	pod       *regexp.Regexp
+	ports     []uint16
+}
+
+// errorRetentionClass returns the class that applies the PCAP error TTL to error streams
+func errorRetentionClass(ttl time.Duration) RetentionClass {
+	isError := true
+	return RetentionClass{Name: "error", TTL: ttl, error: &isError}
+}
+
+// ParseRetentionClasses compiles the retention classes from their JSON configuration, as in
+// PCAP_RETENTION_CLASSES. A positive errorTTL, the PCAP_ERROR_TTL, adds a last class named
+// error for the error streams that no configured class matches.
+func ParseRetentionClasses(data string, errorTTL time.Duration) ([]RetentionClass, error) {
+	var specs []RetentionClassSpec
+	if strings.TrimSpace(data) != "" {
+		if err := json.Unmarshal([]byte(data), &specs); err != nil {
+			return nil, fmt.Errorf("invalid retention classes: %w", err)
+		}
+	}
+
+	classes, err := CompileRetentionClasses(specs)
+	if err != nil {
+		return nil, err
+	}
+	if errorTTL > 0 && !slices.ContainsFunc(classes, func(class RetentionClass) bool { return class.Name == "error" }) {
+		classes = append(classes, errorRetentionClass(errorTTL))
+	}
+
+	return classes, nil
+}
+
+// CompileRetentionClasses validates and compiles retention class specs, keeping their order
+func CompileRetentionClasses(specs []RetentionClassSpec) ([]RetentionClass, error) {
+	classes := make([]RetentionClass, 0, len(specs))
+	names := make(map[string]bool, len(specs))
+	var errs []error
+
+	for i, spec := range specs {
+		if spec.Name == "" {
+			errs = append(errs, fmt.Errorf("retention class #%d has no name", i))
+			continue
+		}
+		if names[spec.Name] {
+			errs = append(errs, fmt.Errorf("retention class %q is defined more than once", spec.Name))
+			continue
+		}
+		names[spec.Name] = true
+
+		class := RetentionClass{Name: spec.Name, error: spec.Error, ports: spec.Ports}
+
+		ttl, err := time.ParseDuration(spec.TTL)
+		if err != nil || ttl <= 0 {
+			errs = append(errs, fmt.Errorf("retention class %q has an invalid TTL %q", spec.Name, spec.TTL))
+			continue
+		}
+		class.TTL = ttl
+
+		for _, protocol := range spec.Protocols {
+			class.protocols = append(class.protocols, strings.ToLower(protocol))
+		}
+
+		if spec.Namespace != "" {
+			if class.namespace, err = regexp.Compile(spec.Namespace); err != nil {
+				errs = append(errs, fmt.Errorf("retention class %q has an invalid namespace regex: %w", spec.Name, err))
+				continue
+			}
+		}
+		if spec.Pod != "" {
+			if class.pod, err = regexp.Compile(spec.Pod); err != nil {
+				errs = append(errs, fmt.Errorf("retention class %q has an invalid pod regex: %w", spec.Name, err))
+				continue
+			}
+		}
+
+		classes = append(classes, class)
+	}
+
+	return classes, errors.Join(errs...)
+}
+
+// Match reports whether the class applies to a stream
+func (c RetentionClass) Match(meta PcapMeta) bool {
+	if c.error != nil && *c.error != meta.Error {
+		return false
+	}
+	if len(c.protocols) > 0 && !slices.Contains(c.protocols, strings.ToLower(meta.Protocol)) {
+		return false
+	}
+	if c.namespace != nil && !c.namespace.MatchString(meta.Namespace) {
+		return false
+	}
+	if c.pod != nil && !c.pod.MatchString(meta.Pod) {
+		return false
+	}
+	if len(c.ports) > 0 && !slices.Contains(c.ports, meta.SrcPort) && !slices.Contains(c.ports, meta.DstPort) {
+		return false
+	}
+
+	return true
+}
+
+// pcapRegistration is the stream metadata and retention class recorded when a PCAP file is registered
+type pcapRegistration struct {
+	meta  PcapMeta
+	class string
+	ttl   time.Duration
+}
+
+// SetRetentionClasses sets the classes applied to newly registered PCAP files.
+// Classes are evaluated in order and the first match wins.
+func (pm *PcapManager) SetRetentionClasses(classes []RetentionClass) {
+	pm.classMu.Lock()
+	defer pm.classMu.Unlock()
+
+	pm.classes = classes
+	pm.minClassTTL = 0
+	for _, class := range classes {
+		if pm.minClassTTL == 0 || class.TTL < pm.minClassTTL {
+			pm.minClassTTL = class.TTL
+		}
+	}
+}
+
+// RegisterPcap records the metadata of the stream captured in a PCAP file, which attributes
+// its storage, and assigns the file a retention class. It returns the class name.
+// Files matching no class, or never registered, use the default TTL.
+func (pm *PcapManager) RegisterPcap(pcapName string, meta PcapMeta) string {
+	pm.classMu.Lock()
+	defer pm.classMu.Unlock()
+
+	registration := pcapRegistration{meta: meta}
+	for _, class := range pm.classes {
+		if class.Match(meta) {
+			registration.class = class.Name
+			registration.ttl = class.TTL
+			log.Debug().Str("pcapName", pcapName).Str("class", class.Name).Dur("ttl", class.TTL).Msg("PCAP file assigned to a retention class")
+			break
+		}
+	}
+
+	pm.registered[pcapName] = registration
+	return registration.class
+}
+
+// ttlFor returns the TTL of a PCAP file and the name of its retention class. A class restored
+// from a retention takes its TTL from the current classes, the default TTL if it was removed.
+func (pm *PcapManager) ttlFor(pcapName string) (time.Duration, string) {
+	pm.classMu.Lock()
+	defer pm.classMu.Unlock()
+
+	registration, exists := pm.registered[pcapName]
+	if !exists || registration.class == "" {
+		return pm.pcapTTL, ""
+	}
+	if registration.ttl > 0 {
+		return registration.ttl, registration.class
+	}
+	for _, class := range pm.classes {
+		if class.Name == registration.class {
+			return class.TTL, class.Name
+		}
+	}
+
+	return pm.pcapTTL, registration.class
+}
+
+// restoreRegistrations restores the classes of the retained PCAP files from the retention
+// store, as the registrations are lost when the worker restarts
+func (pm *PcapManager) restoreRegistrations() {
+	retentions, err := pm.store.List()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to restore the retention classes of PCAP files")
+		return
+	}
+
+	pm.classMu.Lock()
+	defer pm.classMu.Unlock()
+
+	for _, r := range retentions {
+		if _, exists := pm.registered[r.Name]; !exists && r.Class != "" {
+			pm.registered[r.Name] = pcapRegistration{class: r.Class}
+		}
+	}
+}
+
+// minTTL returns the shortest TTL any PCAP file can have
+func (pm *PcapManager) minTTL() time.Duration {
+	pm.classMu.Lock()
+	defer pm.classMu.Unlock()
+
+	if pm.minClassTTL > 0 && pm.minClassTTL < pm.pcapTTL {
+		return pm.minClassTTL
+	}
+
+	return pm.pcapTTL
+}
+
+// forgetRegistration drops the registration of a PCAP file that was removed for good
+func (pm *PcapManager) forgetRegistration(pcapName string) {
+	pm.classMu.Lock()
+	defer pm.classMu.Unlock()
+
+	delete(pm.registered, pcapName)
+}
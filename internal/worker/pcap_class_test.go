@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionClassMatching(t *testing.T) {
+	classes, err := ParseRetentionClasses(`[
+		{"name": "payments", "namespace": "^payments$", "ttl": "1h"},
+		{"name": "dns", "protocols": ["DNS"], "ports": [53], "ttl": "10s"}
+	]`, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("ParseRetentionClasses failed: %v", err)
+	}
+	if len(classes) != 3 || classes[2].Name != "error" || classes[2].TTL != 10*time.Minute {
+		t.Fatalf("Expected the error TTL to add a last error class, got %+v", classes)
+	}
+
+	manager := NewPcapManager(t.TempDir(), time.Minute, 1024*1024)
+	manager.SetRetentionClasses(classes)
+
+	tests := []struct {
+		meta     PcapMeta
+		expected string
+	}{
+		{PcapMeta{Namespace: "payments", Error: true}, "payments"},
+		{PcapMeta{Namespace: "default", Protocol: "dns", DstPort: 53}, "dns"},
+		{PcapMeta{Namespace: "default", Protocol: "dns", DstPort: 5353}, ""},
+		{PcapMeta{Namespace: "default", Error: true}, "error"},
+		{PcapMeta{Namespace: "payments-staging"}, ""},
+	}
+
+	for i, test := range tests {
+		if class := manager.RegisterPcap("test.pcap", test.meta); class != test.expected {
+			t.Errorf("Test %d: expected class %q, got %q", i, test.expected, class)
+		}
+	}
+}
+
+func TestRetentionClassValidation(t *testing.T) {
+	invalid := []string{
+		`[{"name": "a", "ttl": "forever"}]`,
+		`[{"name": "a", "ttl": "1m", "pod": "("}]`,
+		`[{"ttl": "1m"}]`,
+		`[{"name": "a", "ttl": "1m"}, {"name": "a", "ttl": "2m"}]`,
+	}
+
+	for _, data := range invalid {
+		if _, err := ParseRetentionClasses(data, 0); err == nil {
+			t.Errorf("Expected an error for %s", data)
+		}
+	}
+}
+
+func TestRetentionClassTTL(t *testing.T) {
+	tempDir := t.TempDir()
+	pastTime := time.Now().Add(-2 * time.Minute)
+	for _, name := range []string{"error.pcap", "normal.pcap"} {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte("test data"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		if err := os.Chtimes(path, pastTime, pastTime); err != nil {
+			t.Fatalf("Failed to set file time: %v", err)
+		}
+	}
+
+	// Error streams are kept for an hour, other streams for a minute
+	manager := NewPcapManager(tempDir, time.Minute, 1024*1024)
+	classes, err := ParseRetentionClasses("", time.Hour)
+	if err != nil {
+		t.Fatalf("ParseRetentionClasses failed: %v", err)
+	}
+	manager.SetRetentionClasses(classes)
+	manager.RegisterPcap("error.pcap", PcapMeta{Error: true})
+	manager.RegisterPcap("normal.pcap", PcapMeta{})
+
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "error.pcap")); err != nil {
+		t.Errorf("Error PCAP should be kept by its class TTL: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "normal.pcap")); !os.IsNotExist(err) {
+		t.Errorf("Normal PCAP should expire with the default TTL")
+	}
+
+	entry, _ := manager.LookupPcap("error")
+	if entry.Class != "error" {
+		t.Errorf("Expected class %q, got %q", "error", entry.Class)
+	}
+}
+
+func TestRetentionClassSurvivesRestart(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "error.pcap"), []byte("test data"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	classes, err := ParseRetentionClasses("", time.Hour)
+	if err != nil {
+		t.Fatalf("ParseRetentionClasses failed: %v", err)
+	}
+
+	store := NewMemoryRetentionStore()
+	manager := NewPcapManagerWithStore(tempDir, time.Minute, 1024*1024, store)
+	manager.SetRetentionClasses(classes)
+	manager.RegisterPcap("error.pcap", PcapMeta{Error: true})
+	if err := manager.RetainPcap("error.pcap", time.Minute); err != nil {
+		t.Fatalf("RetainPcap failed: %v", err)
+	}
+	if r, _, _ := store.Get("error.pcap"); r.Class != "error" {
+		t.Fatalf("Expected the class to be stored with the retention, got %+v", r)
+	}
+
+	// A new manager on the same store knows the class without the registration
+	restarted := NewPcapManagerWithStore(tempDir, time.Minute, 1024*1024, store)
+	restarted.SetRetentionClasses(classes)
+	if ttl, class := restarted.ttlFor("error.pcap"); class != "error" || ttl != time.Hour {
+		t.Errorf("Expected the error class and its TTL after a restart, got %q, %v", class, ttl)
+	}
+}
@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PcapEventType is the lifecycle stage a PcapEvent reports
+type PcapEventType string
+
+const (
+	// PcapCreated is published when a file appears in the warm tier, including when it is restored from the trash
+	PcapCreated PcapEventType = "created"
+	// PcapRetained is published when a retention is created or extended
+	PcapRetained PcapEventType = "retained"
+	// PcapRetentionExpired is published when a retention ends and the file is subject to its TTL again
+	PcapRetentionExpired PcapEventType = "retentionExpired"
+	// PcapEvicted is published when cleanup removes an expired file from its tier, into the trash or for good
+	PcapEvicted PcapEventType = "evicted"
+	// PcapDeleted is published when a file is removed from disk for good
+	PcapDeleted PcapEventType = "deleted"
+)
+
+// defaultEventBuffer is the buffer size of subscriptions that don't ask for one
+const defaultEventBuffer = 256
+
+// PcapEvent describes a change in the lifecycle of a PCAP file
+type PcapEvent struct {
+	Type      PcapEventType `json:"type"`
+	Name      string        `json:"name"`
+	Path      string        `json:"path,omitempty"`
+	Size      int64         `json:"size,omitempty"`
+	Class     string        `json:"class,omitempty"`
+	Retention *Retention    `json:"retention,omitempty"`
+	Time      time.Time     `json:"time"`
+}
+
+// PcapSubscription receives PCAP lifecycle events on C. Events are buffered per
+// subscription and dropped when the buffer is full, so a slow subscriber never blocks
+// the capture. C is closed by Close.
+type PcapSubscription struct {
+	C       <-chan PcapEvent
+	ch      chan PcapEvent
+	dropped atomic.Uint64
+	bus     *pcapEventBus
+}
+
+// Dropped returns the number of events dropped because the subscriber fell behind
+func (s *PcapSubscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close ends the subscription and closes C
+func (s *PcapSubscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// pcapEventBus fans events out to the subscriptions
+type pcapEventBus struct {
+	subscriptions map[*PcapSubscription]struct{}
+	mu            sync.RWMutex
+}
+
+// newPcapEventBus creates a bus without subscriptions
+func newPcapEventBus() *pcapEventBus {
+	return &pcapEventBus{subscriptions: make(map[*PcapSubscription]struct{})}
+}
+
+// subscribe adds a subscription with the given buffer size
+func (b *pcapEventBus) subscribe(buffer int) *PcapSubscription {
+	if buffer <= 0 {
+		buffer = defaultEventBuffer
+	}
+
+	ch := make(chan PcapEvent, buffer)
+	sub := &PcapSubscription{C: ch, ch: ch, bus: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscriptions[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes a subscription and closes its channel
+func (b *pcapEventBus) unsubscribe(sub *PcapSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.subscriptions[sub]; !exists {
+		return
+	}
+	delete(b.subscriptions, sub)
+	close(sub.ch)
+}
+
+// publish delivers an event to every subscription without blocking
+func (b *pcapEventBus) publish(event PcapEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscriptions {
+		select {
+		case sub.ch <- event:
+		default:
+			if sub.dropped.Add(1) == 1 {
+				log.Warn().Str("event", string(event.Type)).Msg("PCAP event subscriber is falling behind, dropping events")
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription to the lifecycle events of the PCAP files.
+// A buffer of zero or less uses the default buffer size.
+func (pm *PcapManager) Subscribe(buffer int) *PcapSubscription {
+	return pm.events.subscribe(buffer)
+}
+
+// publishEntryEvent publishes an event about a catalog entry
+func (pm *PcapManager) publishEntryEvent(eventType PcapEventType, entry PcapEntry) {
+	_, class := pm.ttlFor(entry.Name)
+	pm.events.publish(PcapEvent{
+		Type:  eventType,
+		Name:  entry.Name,
+		Path:  entry.Path,
+		Size:  entry.Size,
+		Class: class,
+	})
+}
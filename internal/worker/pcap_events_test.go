@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPcapLifecycleEvents(t *testing.T) {
+	tempDir := t.TempDir()
+	manager := NewPcapManager(tempDir, time.Minute, 1024*1024)
+
+	sub := manager.Subscribe(16)
+	defer sub.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := manager.Watch(ctx); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	path := filepath.Join(tempDir, "events.pcap")
+	writeTestPcap(t, path, time.Now())
+	expectEvent(t, sub, PcapCreated, "events.pcap")
+
+	if err := manager.RetainPcap("events.pcap", time.Millisecond); err != nil {
+		t.Fatalf("RetainPcap failed: %v", err)
+	}
+	expectEvent(t, sub, PcapRetained, "events.pcap")
+
+	time.Sleep(5 * time.Millisecond)
+	pastTime := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(path, pastTime, pastTime); err != nil {
+		t.Fatalf("Failed to set file time: %v", err)
+	}
+	if err := manager.Rescan(); err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+
+	expectEvent(t, sub, PcapRetentionExpired, "events.pcap")
+	expectEvent(t, sub, PcapEvicted, "events.pcap")
+	expectEvent(t, sub, PcapDeleted, "events.pcap")
+}
+
+func TestSlowSubscriberDoesNotBlock(t *testing.T) {
+	manager := NewPcapManager(t.TempDir(), time.Minute, 1024*1024)
+	sub := manager.Subscribe(1)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			_ = manager.RetainPcap("slow.pcap", time.Hour)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Publishing blocked on a full subscriber")
+	}
+
+	if sub.Dropped() != 9 {
+		t.Errorf("Expected 9 dropped events, got %d", sub.Dropped())
+	}
+
+	sub.Close()
+	if _, ok := <-sub.C; !ok {
+		t.Fatalf("Buffered event should still be delivered after Close")
+	}
+	if _, ok := <-sub.C; ok {
+		t.Errorf("Channel should be closed after Close")
+	}
+}
+
+// expectEvent skips unrelated events until one of the given type arrives for the PCAP file
+func expectEvent(t *testing.T, sub *PcapSubscription, eventType PcapEventType, name string) {
+	t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-sub.C:
+			if event.Type == eventType && event.Name == name {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Expected a %s event for %s", eventType, name)
+		}
+	}
+}
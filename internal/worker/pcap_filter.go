@@ -0,0 +1,260 @@
+package worker
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kubeshark/gopacket"
+	"github.com/kubeshark/gopacket/layers"
+)
+
+// packetFilter reports whether a decoded packet matches a filter expression
+type packetFilter func(packet gopacket.Packet) bool
+
+// compilePacketFilter compiles the subset of the tcpdump filter syntax that can be evaluated
+// without libpcap: [src|dst] host ADDR, [src|dst] net CIDR, [tcp|udp] [src|dst] port N,
+// the protocols ip, ip6, tcp, udp, sctp, icmp, icmp6 and arp, combined with and/&&, or/||,
+// not/! and parentheses. An empty expression matches every packet.
+func compilePacketFilter(expr string) (packetFilter, error) {
+	p := &filterParser{tokens: tokenizeFilter(expr)}
+	if len(p.tokens) == 0 {
+		return func(gopacket.Packet) bool { return true }, nil
+	}
+
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q", expr, p.tokens[p.pos])
+	}
+
+	return filter, nil
+}
+
+// tokenizeFilter splits a filter expression into words, parentheses and operators
+func tokenizeFilter(expr string) []string {
+	replacer := strings.NewReplacer("(", " ( ", ")", " ) ", "&&", " && ", "||", " || ", "!", " ! ")
+	return strings.Fields(replacer.Replace(expr))
+}
+
+// filterParser is a recursive descent parser over the tokens of a filter expression
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	return strings.ToLower(p.tokens[p.pos])
+}
+
+func (p *filterParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *filterParser) parseOr() (packetFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "or" || p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(packet gopacket.Packet) bool { return l(packet) || right(packet) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (packetFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(packet gopacket.Packet) bool { return l(packet) && right(packet) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (packetFilter, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(packet gopacket.Packet) bool { return !inner(packet) }, nil
+
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	return p.parsePrimitive()
+}
+
+func (p *filterParser) parsePrimitive() (packetFilter, error) {
+	// An optional transport protocol qualifies a following port primitive
+	proto := ""
+	switch p.peek() {
+	case "tcp", "udp", "sctp":
+		proto = p.peek()
+		p.pos++
+		if next := p.peek(); next != "port" && next != "src" && next != "dst" {
+			return protocolFilter(proto), nil
+		}
+	case "ip", "ip6", "icmp", "icmp6", "arp":
+		word := p.peek()
+		p.pos++
+		return protocolFilter(word), nil
+	}
+
+	dir := ""
+	if p.peek() == "src" || p.peek() == "dst" {
+		dir = p.peek()
+		p.pos++
+	}
+
+	keyword, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(keyword) {
+	case "host":
+		ip := net.ParseIP(value)
+		if ip == nil || proto != "" {
+			return nil, fmt.Errorf("invalid host %q", value)
+		}
+		return addressFilter(dir, func(addr net.IP) bool { return addr.Equal(ip) }), nil
+
+	case "net":
+		_, network, err := net.ParseCIDR(value)
+		if err != nil || proto != "" {
+			return nil, fmt.Errorf("invalid net %q", value)
+		}
+		return addressFilter(dir, network.Contains), nil
+
+	case "port":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", value)
+		}
+		return portFilter(proto, dir, uint16(port)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported primitive %q", keyword)
+}
+
+// protocolFilter matches packets carrying the given protocol layer
+func protocolFilter(proto string) packetFilter {
+	layerType := map[string]gopacket.LayerType{
+		"ip":    layers.LayerTypeIPv4,
+		"ip6":   layers.LayerTypeIPv6,
+		"tcp":   layers.LayerTypeTCP,
+		"udp":   layers.LayerTypeUDP,
+		"sctp":  layers.LayerTypeSCTP,
+		"icmp":  layers.LayerTypeICMPv4,
+		"icmp6": layers.LayerTypeICMPv6,
+		"arp":   layers.LayerTypeARP,
+	}[proto]
+
+	return func(packet gopacket.Packet) bool {
+		return packet.Layer(layerType) != nil
+	}
+}
+
+// addressFilter matches packets whose source and/or destination address satisfies match
+func addressFilter(dir string, match func(net.IP) bool) packetFilter {
+	return func(packet gopacket.Packet) bool {
+		var src, dst net.IP
+		switch network := packet.NetworkLayer().(type) {
+		case *layers.IPv4:
+			src, dst = network.SrcIP, network.DstIP
+		case *layers.IPv6:
+			src, dst = network.SrcIP, network.DstIP
+		default:
+			return false
+		}
+
+		return matchDirection(dir, match(src), match(dst))
+	}
+}
+
+// portFilter matches packets whose source and/or destination port is port
+func portFilter(proto string, dir string, port uint16) packetFilter {
+	return func(packet gopacket.Packet) bool {
+		var src, dst uint16
+		switch transport := packet.TransportLayer().(type) {
+		case *layers.TCP:
+			if proto != "" && proto != "tcp" {
+				return false
+			}
+			src, dst = uint16(transport.SrcPort), uint16(transport.DstPort)
+		case *layers.UDP:
+			if proto != "" && proto != "udp" {
+				return false
+			}
+			src, dst = uint16(transport.SrcPort), uint16(transport.DstPort)
+		case *layers.SCTP:
+			if proto != "" && proto != "sctp" {
+				return false
+			}
+			src, dst = uint16(transport.SrcPort), uint16(transport.DstPort)
+		default:
+			return false
+		}
+
+		return matchDirection(dir, src == port, dst == port)
+	}
+}
+
+// matchDirection combines the source and destination matches for a src, dst or either qualifier
+func matchDirection(dir string, src bool, dst bool) bool {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	}
+
+	return src || dst
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	pcapGlobalHeaderLen = 24
+	pcapRecordHeaderLen = 16
+
+	pcapMagicMicroseconds          = 0xA1B2C3D4
+	pcapMagicNanoseconds           = 0xA1B23C4D
+	pcapMagicMicrosecondsBigendian = 0xD4C3B2A1
+	pcapMagicNanosecondsBigendian  = 0x4D3CB2A1
+)
+
+// pcapHeader holds the fields of a classic pcap global header needed to walk its records
+type pcapHeader struct {
+	byteOrder binary.ByteOrder
+	nanos     bool
+	snaplen   uint32
+	linkType  uint32
+}
+
+// readPcapHeader reads and validates a classic pcap global header
+func readPcapHeader(r io.Reader) (pcapHeader, error) {
+	buf := make([]byte, pcapGlobalHeaderLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return pcapHeader{}, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+
+	return parsePcapHeader(buf)
+}
+
+// parsePcapHeader decodes a classic pcap global header
+func parsePcapHeader(buf []byte) (pcapHeader, error) {
+	if len(buf) < pcapGlobalHeaderLen {
+		return pcapHeader{}, errors.New("pcap header is too short")
+	}
+
+	var h pcapHeader
+	switch binary.LittleEndian.Uint32(buf[0:4]) {
+	case pcapMagicMicroseconds:
+		h.byteOrder = binary.LittleEndian
+	case pcapMagicNanoseconds:
+		h.byteOrder = binary.LittleEndian
+		h.nanos = true
+	case pcapMagicMicrosecondsBigendian:
+		h.byteOrder = binary.BigEndian
+	case pcapMagicNanosecondsBigendian:
+		h.byteOrder = binary.BigEndian
+		h.nanos = true
+	default:
+		return pcapHeader{}, fmt.Errorf("unknown pcap magic %x", buf[0:4])
+	}
+
+	if major := h.byteOrder.Uint16(buf[4:6]); major != 2 {
+		return pcapHeader{}, fmt.Errorf("unsupported pcap version %d", major)
+	}
+
+	h.snaplen = h.byteOrder.Uint32(buf[16:20])
+	h.linkType = h.byteOrder.Uint32(buf[20:24])
+	return h, nil
+}
+
+// pcapRecord is a decoded pcap record header
+type pcapRecord struct {
+	timestamp time.Time
+	inclLen   uint32
+	origLen   uint32
+}
+
+// parseRecord decodes a pcap record header
+func (h pcapHeader) parseRecord(buf []byte) pcapRecord {
+	sec := int64(h.byteOrder.Uint32(buf[0:4]))
+	frac := int64(h.byteOrder.Uint32(buf[4:8]))
+	if !h.nanos {
+		frac *= 1000
+	}
+
+	return pcapRecord{
+		timestamp: time.Unix(sec, frac).UTC(),
+		inclLen:   h.byteOrder.Uint32(buf[8:12]),
+		origLen:   h.byteOrder.Uint32(buf[12:16]),
+	}
+}
+
+// validRecord reports whether a record header is plausible for this file
+func (h pcapHeader) validRecord(r pcapRecord) bool {
+	maxLen := h.snaplen
+	if maxLen == 0 || maxLen > 0x40000 {
+		maxLen = 0x40000
+	}
+
+	return r.inclLen <= maxLen && r.inclLen <= r.origLen
+}
@@ -0,0 +1,331 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PcapHandler serves the PCAP files and retentions of a PcapManager over HTTP:
+//
+//	GET    /pcaps               list PCAP files, see pcapFilter for the query parameters
+//	GET    /pcaps/{stream}      stream a PCAP file, with Range support for warm files; ?download
+//	                            adds a Content-Disposition header, HEAD is supported as well
+//	GET    /storage             storage usage and limit
+//	GET    /storage/breakdown   storage usage per namespace, pod and protocol
+//	GET    /metrics             storage metrics in the Prometheus text format
+//	GET    /retentions          active retentions and usage per script and namespace
+//	POST   /retentions          create a retention, see retentionRequest for the body
+//	DELETE /retentions/{name}   release a retention
+type PcapHandler struct {
+	pcapManager *PcapManager
+}
+
+// NewPcapHandler creates an HTTP handler backed by the given PCAP manager
+func NewPcapHandler(pcapManager *PcapManager) *PcapHandler {
+	return &PcapHandler{pcapManager: pcapManager}
+}
+
+// StorageUsage is the response of the storage endpoint
+type StorageUsage struct {
+	Usage int64 `json:"usage"`
+	Limit int64 `json:"limit"`
+	Files int   `json:"files"`
+}
+
+// retentionRequest is the body of a retention creation request
+type retentionRequest struct {
+	Name      string `json:"name"`
+	Seconds   int64  `json:"seconds"`
+	Script    string `json:"script"`
+	Namespace string `json:"namespace"`
+}
+
+// ServeHTTP routes a request to its endpoint
+func (h *PcapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resource, name, _ := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case resource == "pcaps" && name == "" && r.Method == http.MethodGet:
+		h.listPcaps(w, r)
+	case resource == "pcaps" && name != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.getPcap(w, r, name)
+	case resource == "storage" && name == "" && r.Method == http.MethodGet:
+		h.getStorage(w)
+	case resource == "storage" && name == "breakdown" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, h.pcapManager.GetStorageBreakdown())
+	case resource == "metrics" && name == "" && r.Method == http.MethodGet:
+		h.getMetrics(w)
+	case resource == "retentions" && name == "" && r.Method == http.MethodGet:
+		h.listRetentions(w)
+	case resource == "retentions" && name == "" && r.Method == http.MethodPost:
+		h.createRetention(w, r)
+	case resource == "retentions" && name != "" && r.Method == http.MethodDelete:
+		h.releaseRetention(w, name)
+	case resource == "pcaps" || resource == "storage" || resource == "retentions" || resource == "metrics":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// pcapFilter selects catalog entries by the query parameters of a list request:
+// stream (stream ID prefix), since and until (RFC3339, matched against the packet
+// times), retained and cold (true or false), and limit (maximum number of entries).
+type pcapFilter struct {
+	stream   string
+	since    time.Time
+	until    time.Time
+	retained *bool
+	cold     *bool
+	limit    int
+}
+
+// parsePcapFilter reads a pcapFilter from the query of a request
+func parsePcapFilter(r *http.Request) (pcapFilter, error) {
+	query := r.URL.Query()
+	filter := pcapFilter{stream: query.Get("stream")}
+
+	var err error
+	if filter.since, err = parseTimeParam(query.Get("since")); err != nil {
+		return filter, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.until, err = parseTimeParam(query.Get("until")); err != nil {
+		return filter, fmt.Errorf("invalid until: %w", err)
+	}
+	if filter.retained, err = parseBoolParam(query.Get("retained")); err != nil {
+		return filter, fmt.Errorf("invalid retained: %w", err)
+	}
+	if filter.cold, err = parseBoolParam(query.Get("cold")); err != nil {
+		return filter, fmt.Errorf("invalid cold: %w", err)
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.limit, err = strconv.Atoi(value); err != nil || filter.limit < 0 {
+			return filter, fmt.Errorf("invalid limit: %q", value)
+		}
+	}
+
+	return filter, nil
+}
+
+// match reports whether an entry passes the filter
+func (f pcapFilter) match(entry PcapEntry) bool {
+	if f.stream != "" && !strings.HasPrefix(entry.StreamID, f.stream) {
+		return false
+	}
+	if !f.since.IsZero() && entry.LastPacketTime.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && entry.FirstPacketTime.After(f.until) {
+		return false
+	}
+	if f.retained != nil && entry.Retained != *f.retained {
+		return false
+	}
+	if f.cold != nil && entry.Cold != *f.cold {
+		return false
+	}
+
+	return true
+}
+
+func (h *PcapHandler) listPcaps(w http.ResponseWriter, r *http.Request) {
+	filter, err := parsePcapFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := h.pcapManager.ListPcaps()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	matched := make([]PcapEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !filter.match(entry) {
+			continue
+		}
+		matched = append(matched, entry)
+		if filter.limit > 0 && len(matched) == filter.limit {
+			break
+		}
+	}
+
+	writeJSON(w, http.StatusOK, matched)
+}
+
+// getPcap streams a PCAP file. Warm files are served with http.ServeContent, which handles
+// Range and conditional requests. Cold files are decompressed on the fly and sent whole.
+func (h *PcapHandler) getPcap(w http.ResponseWriter, r *http.Request, streamID string) {
+	entry, exists := h.pcapManager.LookupPcap(streamID)
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Errorf("PCAP %q not found", streamID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", entry.Name))
+	}
+
+	if !entry.Cold {
+		file, err := os.Open(entry.Path)
+		if err != nil {
+			writeOpenError(w, entry.Name, err)
+			return
+		}
+		defer file.Close()
+
+		http.ServeContent(w, r, entry.Name, entry.ModTime, file)
+		return
+	}
+
+	reader, err := h.pcapManager.OpenPcap(entry.Name)
+	if err != nil {
+		writeOpenError(w, entry.Name, err)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Last-Modified", entry.ModTime.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Debug().Err(err).Str("pcapName", entry.Name).Msg("Failed to stream cold PCAP file")
+	}
+}
+
+func (h *PcapHandler) getStorage(w http.ResponseWriter) {
+	usage, err := h.pcapManager.GetStorageUsage()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StorageUsage{
+		Usage: usage,
+		Limit: h.pcapManager.storageLimit,
+		Files: h.pcapManager.catalog.Len(),
+	})
+}
+
+func (h *PcapHandler) getMetrics(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := h.pcapManager.WriteStorageMetrics(w); err != nil {
+		log.Debug().Err(err).Msg("Failed to write storage metrics")
+	}
+}
+
+func (h *PcapHandler) listRetentions(w http.ResponseWriter) {
+	listing, err := h.pcapManager.ListRetentions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+func (h *PcapHandler) createRetention(w http.ResponseWriter, r *http.Request) {
+	var req retentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid retention request: %w", err))
+		return
+	}
+	if err := validatePcapName(req.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Seconds <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("seconds must be positive, got %d", req.Seconds))
+		return
+	}
+
+	owner := RetentionOwner{Script: req.Script, Namespace: req.Namespace}
+	err := h.pcapManager.RetainPcapFor(req.Name, time.Duration(req.Seconds)*time.Second, owner)
+	if errors.Is(err, ErrRetentionQuotaExceeded) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	retention, _, err := h.pcapManager.store.Get(req.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, retention)
+}
+
+func (h *PcapHandler) releaseRetention(w http.ResponseWriter, name string) {
+	if err := h.pcapManager.ReleasePcap(name); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug().Err(err).Msg("Failed to write HTTP response")
+	}
+}
+
+// writeError writes err as a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeOpenError maps a failure to open a PCAP file to a response
+func writeOpenError(w http.ResponseWriter, pcapName string, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("PCAP %q not found", pcapName))
+		return
+	}
+
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// parseTimeParam parses an optional RFC3339 query parameter
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseBoolParam parses an optional boolean query parameter
+func parseBoolParam(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPcapHandlerListAndDownload(t *testing.T) {
+	tempDir := t.TempDir()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	writeTestPcap(t, filepath.Join(tempDir, "000000000001_tcp.pcap"), start, start.Add(time.Second))
+	writeTestPcap(t, filepath.Join(tempDir, "000000000002_udp.pcap"), start.Add(time.Hour))
+
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+	server := httptest.NewServer(NewPcapHandler(manager))
+	defer server.Close()
+
+	var entries []PcapEntry
+	getJSON(t, server.URL+"/pcaps?until=2024-01-01T12:30:00Z", &entries)
+	if len(entries) != 1 || entries[0].StreamID != "000000000001_tcp" {
+		t.Fatalf("Expected only the first stream, got %v", entries)
+	}
+
+	expected, err := os.ReadFile(filepath.Join(tempDir, "000000000001_tcp.pcap"))
+	if err != nil {
+		t.Fatalf("Failed to read test pcap: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/pcaps/000000000001?download", nil)
+	req.Header.Set("Range", "bytes=0-23")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Range request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusPartialContent, resp.StatusCode)
+	}
+	if string(body) != string(expected[:24]) {
+		t.Errorf("Range response should hold the PCAP file header")
+	}
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), "000000000001_tcp.pcap") {
+		t.Errorf("Download should set the file name, got %q", resp.Header.Get("Content-Disposition"))
+	}
+
+	resp, err = http.Get(server.URL + "/pcaps/000000000999")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown stream, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var usage StorageUsage
+	getJSON(t, server.URL+"/storage", &usage)
+	if usage.Files != 2 || usage.Limit != 1024*1024 || usage.Usage == 0 {
+		t.Errorf("Unexpected storage usage: %+v", usage)
+	}
+}
+
+func TestPcapHandlerRetentions(t *testing.T) {
+	manager := NewPcapManager(t.TempDir(), time.Hour, 1024*1024)
+	manager.SetRetentionQuotas(RetentionQuotas{PerScript: RetentionQuota{MaxCount: 1}})
+	server := httptest.NewServer(NewPcapHandler(manager))
+	defer server.Close()
+
+	post := func(body string) int {
+		resp, err := http.Post(server.URL+"/retentions", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(`{"name": "a.pcap", "seconds": 60, "script": "s"}`); status != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, status)
+	}
+	if status := post(`{"name": "b.pcap", "seconds": 60, "script": "s"}`); status != http.StatusConflict {
+		t.Errorf("Expected status %d over quota, got %d", http.StatusConflict, status)
+	}
+	if status := post(`{"name": "../c.pcap", "seconds": 60}`); status != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid name, got %d", http.StatusBadRequest, status)
+	}
+
+	var listing RetentionListing
+	getJSON(t, server.URL+"/retentions", &listing)
+	if len(listing.Retentions) != 1 || listing.ByScript["s"].Count != 1 {
+		t.Errorf("Unexpected retention listing: %+v", listing)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/retentions/a.pcap", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || manager.IsRetained("a.pcap") {
+		t.Errorf("Retention should be released, got status %d", resp.StatusCode)
+	}
+}
+
+// getJSON decodes the JSON response of a GET request into v
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Request to %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d from %s, got %d", http.StatusOK, url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode response from %s: %v", url, err)
+	}
+}
@@ -1,8 +1,7 @@
 package worker
 
 import (
-	"os"
-	"path/filepath"
+	"context"
 	"sync"
 	"time"
 
@@ -11,106 +10,194 @@ import (
 
 // PcapManager manages the PCAP files
 type PcapManager struct {
-	pcapDir       string
-	pcapTTL       time.Duration
-	storageLimit  int64
-	retainedPcaps map[string]time.Time
-	mu            sync.Mutex
+	pcapDir      string
+	pcapTTL      time.Duration
+	storageLimit int64
+	store        RetentionStore
+	coldDir      string
+	warmAfter    time.Duration
+	quotas       RetentionQuotas
+	quotaMu      sync.Mutex
+	catalog      *PcapCatalog
+	gracePeriod  time.Duration
+	trash        map[string]*trashedPcap
+	trashMu      sync.Mutex
+	classes      []RetentionClass
+	minClassTTL  time.Duration
+	registered   map[string]pcapRegistration
+	classMu      sync.Mutex
+	events       *pcapEventBus
+	snapshots    map[string]int64
+	snapshotMu   sync.Mutex
 }
 
-// NewPcapManager creates a new PCAP manager
+// NewPcapManager creates a new PCAP manager that keeps retentions in memory
 func NewPcapManager(pcapDir string, pcapTTL time.Duration, storageLimit int64) *PcapManager {
-	return &PcapManager{
-		pcapDir:       pcapDir,
-		pcapTTL:       pcapTTL,
-		storageLimit:  storageLimit,
-		retainedPcaps: make(map[string]time.Time),
+	return NewPcapManagerWithStore(pcapDir, pcapTTL, storageLimit, NewMemoryRetentionStore())
+}
+
+// NewPcapManagerWithStore creates a new PCAP manager that keeps retentions in the given store
+func NewPcapManagerWithStore(pcapDir string, pcapTTL time.Duration, storageLimit int64, store RetentionStore) *PcapManager {
+	pm := &PcapManager{
+		pcapDir:      pcapDir,
+		pcapTTL:      pcapTTL,
+		storageLimit: storageLimit,
+		store:        store,
+		catalog:      newPcapCatalog(),
+		trash:        make(map[string]*trashedPcap),
+		registered:   make(map[string]pcapRegistration),
+		events:       newPcapEventBus(),
+		snapshots:    make(map[string]int64),
+	}
+
+	if err := pm.catalog.scanDir(pcapDir, false); err != nil {
+		log.Error().Err(err).Str("dir", pcapDir).Msg("Failed to index PCAP directory")
 	}
+	pm.restoreRegistrations()
+	pm.scanSnapshots()
+	pm.catalog.onAdd = func(entry PcapEntry) {
+		pm.publishEntryEvent(PcapCreated, entry)
+	}
+
+	return pm
 }
 
-// CleanupExpiredPcaps removes expired PCAP files
-func (pm *PcapManager) CleanupExpiredPcaps() error {
-	files, err := os.ReadDir(pm.pcapDir)
-	if err != nil {
+// Rescan rebuilds the PCAP catalog from the files on disk
+func (pm *PcapManager) Rescan() error {
+	catalog := newPcapCatalog()
+	if err := catalog.scanDir(pm.pcapDir, false); err != nil {
 		return err
 	}
+	if pm.coldDir != "" {
+		if err := catalog.scanDir(pm.coldDir, true); err != nil {
+			return err
+		}
+	}
+
+	pm.catalog.replace(catalog)
+	return nil
+}
 
+// Watch keeps the PCAP catalog current with files written by the capture until ctx is done
+func (pm *PcapManager) Watch(ctx context.Context) error {
+	return pm.catalog.watch(ctx, pm.pcapDir, pm.coldDir)
+}
+
+// CleanupExpiredPcaps removes expired PCAP files and moves aged ones to the cold tier
+func (pm *PcapManager) CleanupExpiredPcaps() error {
 	now := time.Now()
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
 
-		info, err := file.Info()
-		if err != nil {
-			log.Error().Err(err).Str("file", file.Name()).Msg("Failed to get file info")
-			continue
-		}
+	expired, err := pm.store.PurgeExpired(now)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to purge expired PCAP retentions")
+	}
+	for i := range expired {
+		pm.events.publish(PcapEvent{Type: PcapRetentionExpired, Name: expired[i].Name, Retention: &expired[i]})
+	}
 
-		// Skip files that are marked for retention
-		if pm.isRetained(file.Name()) {
+	// Only files past the shortest TTL or the warm threshold need to be looked at
+	threshold := pm.minTTL()
+	if pm.coldDir != "" && pm.warmAfter < threshold {
+		threshold = pm.warmAfter
+	}
+
+	for _, entry := range pm.catalog.ModifiedBefore(now.Add(-threshold)) {
+		age := now.Sub(entry.ModTime)
+		ttl, _ := pm.ttlFor(entry.Name)
+
+		// Delete files older than the TTL of their class, unless they are marked for retention
+		if age > ttl && !pm.isRetained(entry.Name) {
+			if err := pm.deletePcap(entry); err != nil {
+				log.Error().Err(err).Str("file", entry.Path).Msg("Failed to remove expired PCAP file")
+			}
 			continue
 		}
 
-		// Delete files older than pcapTTL
-		if now.Sub(info.ModTime()) > pm.pcapTTL {
-			filePath := filepath.Join(pm.pcapDir, file.Name())
-			if err := os.Remove(filePath); err != nil {
-				log.Error().Err(err).Str("file", filePath).Msg("Failed to remove expired PCAP file")
-			} else {
-				log.Debug().Str("file", filePath).Msg("Removed expired PCAP file")
+		// Move files past the warm threshold, retained ones included, to the cold tier
+		if !entry.Cold && pm.coldDir != "" && age > pm.warmAfter {
+			if err := pm.moveToColdTier(entry.Name, entry.ModTime); err != nil {
+				log.Error().Err(err).Str("file", entry.Name).Msg("Failed to move PCAP file to the cold tier")
 			}
 		}
 	}
 
+	if err := pm.purgeTrash(now); err != nil {
+		log.Error().Err(err).Msg("Failed to purge trashed PCAP files")
+	}
+
+	pm.cleanupSnapshots()
+
 	return nil
 }
 
 // RetainPcap marks a PCAP file for retention
-func (pm *PcapManager) RetainPcap(pcapName string, duration time.Duration) {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
+func (pm *PcapManager) RetainPcap(pcapName string, duration time.Duration) error {
+	return pm.RetainPcapFor(pcapName, duration, RetentionOwner{})
+}
 
-	pm.retainedPcaps[pcapName] = time.Now().Add(duration)
-	log.Debug().Str("pcapName", pcapName).Dur("duration", duration).Msg("PCAP file marked for retention")
+// ReleasePcap removes the retention of a PCAP file, making it subject to the TTL again
+func (pm *PcapManager) ReleasePcap(pcapName string) error {
+	return pm.store.Delete(pcapName)
 }
 
 // isRetained checks if a PCAP file is marked for retention
 func (pm *PcapManager) isRetained(pcapName string) bool {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
-
-	expireTime, exists := pm.retainedPcaps[pcapName]
-	if !exists {
-		return false
+	_, retained, err := pm.store.Get(pcapName)
+	if err != nil {
+		// Keep the file when the store can't be consulted, deleting it can't be undone
+		log.Error().Err(err).Str("pcapName", pcapName).Msg("Failed to check PCAP retention")
+		return true
 	}
 
-	// If the retention period has expired, remove it from the map
-	if time.Now().After(expireTime) {
-		delete(pm.retainedPcaps, pcapName)
-		return false
+	return retained
+}
+
+// GetStorageUsage returns the current storage usage of PCAP files in both tiers, the trash and the snapshots
+func (pm *PcapManager) GetStorageUsage() (int64, error) {
+	return pm.catalog.TotalSize() + pm.trashSize() + pm.snapshotSize(), nil
+}
+
+// LookupPcap returns the catalog entry of a stream, with its current retention status
+func (pm *PcapManager) LookupPcap(streamID string) (PcapEntry, bool) {
+	entry, exists := pm.catalog.Lookup(streamID)
+	if !exists {
+		return PcapEntry{}, false
 	}
 
-	return true
+	entry.Retained = pm.isRetained(entry.Name)
+	_, entry.Class = pm.ttlFor(entry.Name)
+	return entry, true
 }
 
-// GetStorageUsage returns the current storage usage of PCAP files
-func (pm *PcapManager) GetStorageUsage() (int64, error) {
-	var totalSize int64
+// ListPcaps returns the catalog entries, oldest first, with their current retention status
+func (pm *PcapManager) ListPcaps() ([]PcapEntry, error) {
+	retentions, err := pm.store.List()
+	if err != nil {
+		return nil, err
+	}
 
-	err := filepath.Walk(pm.pcapDir, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	retained := make(map[string]bool, len(retentions))
+	for _, r := range retentions {
+		retained[r.Name] = true
+	}
 
-		if !info.IsDir() {
-			totalSize += info.Size()
-		}
+	entries := pm.catalog.List()
+	for i := range entries {
+		entries[i].Retained = retained[entries[i].Name]
+		_, entries[i].Class = pm.ttlFor(entries[i].Name)
+	}
 
-		return nil
-	})
+	return entries, nil
+}
+
+// ResolvePcapPath returns the path of the file that holds a stream
+func (pm *PcapManager) ResolvePcapPath(streamID string) (string, bool) {
+	entry, exists := pm.catalog.Lookup(streamID)
+	if !exists {
+		return "", false
+	}
 
-	return totalSize, err
+	return entry.Path, true
 }
 
 // GetPcapDir returns the PCAP directory
@@ -118,6 +205,11 @@ func (pm *PcapManager) GetPcapDir() string {
 	return pm.pcapDir
 }
 
+// RetentionStore returns the store that holds the PCAP retentions
+func (pm *PcapManager) RetentionStore() RetentionStore {
+	return pm.store
+}
+
 // IsRetained is an exported wrapper for isRetained (for testing)
 func (pm *PcapManager) IsRetained(pcapName string) bool {
 	return pm.isRetained(pcapName)
@@ -0,0 +1,205 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrRetentionQuotaExceeded is returned when a retention would exceed the quota of its script or namespace
+var ErrRetentionQuotaExceeded = errors.New("retention quota exceeded")
+
+// RetentionQuota limits the retentions held by a single owner. Zero values mean no limit.
+type RetentionQuota struct {
+	MaxBytes int64 `json:"maxBytes"`
+	MaxCount int   `json:"maxCount"`
+}
+
+// RetentionQuotas holds the quotas applied to every script and to every target namespace
+type RetentionQuotas struct {
+	PerScript    RetentionQuota `json:"perScript"`
+	PerNamespace RetentionQuota `json:"perNamespace"`
+}
+
+// RetentionOwner identifies the script and the target namespace a retention is accounted to
+type RetentionOwner struct {
+	Script    string
+	Namespace string
+}
+
+// RetentionUsage is the amount of retained PCAP data held by a single owner
+type RetentionUsage struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// RetentionListing lists the active retentions together with the usage per script and per namespace
+type RetentionListing struct {
+	Retentions  []Retention               `json:"retentions"`
+	ByScript    map[string]RetentionUsage `json:"byScript"`
+	ByNamespace map[string]RetentionUsage `json:"byNamespace"`
+	Quotas      RetentionQuotas           `json:"quotas"`
+}
+
+// SetRetentionQuotas sets the quotas enforced by RetainPcapFor
+func (pm *PcapManager) SetRetentionQuotas(quotas RetentionQuotas) {
+	pm.quotaMu.Lock()
+	defer pm.quotaMu.Unlock()
+
+	pm.quotas = quotas
+}
+
+// RetainPcapFor marks a PCAP file for retention on behalf of a script and target namespace.
+// The request is rejected with ErrRetentionQuotaExceeded if it would exceed either quota.
+// Re-retaining a file replaces its previous retention instead of being counted twice.
+// A file that expired but is still within its grace period is restored from the trash.
+func (pm *PcapManager) RetainPcapFor(pcapName string, duration time.Duration, owner RetentionOwner) error {
+	pm.quotaMu.Lock()
+	defer pm.quotaMu.Unlock()
+
+	retention := Retention{
+		Name:      pcapName,
+		Until:     time.Now().Add(duration),
+		Script:    owner.Script,
+		Namespace: owner.Namespace,
+		Size:      pm.pcapSize(pcapName),
+	}
+	// The class is stored with the retention, so it survives a restart of the worker
+	_, retention.Class = pm.ttlFor(pcapName)
+
+	// A rejected retention leaves a trashed file in the trash
+	if err := pm.checkRetentionQuotas(retention); err != nil {
+		log.Warn().Err(err).Str("pcapName", pcapName).Msg("PCAP retention rejected")
+		return err
+	}
+
+	if _, err := pm.restorePcap(pcapName); err != nil {
+		log.Error().Err(err).Str("pcapName", pcapName).Msg("Failed to restore PCAP file from the trash")
+		return err
+	}
+
+	if err := pm.store.Put(retention); err != nil {
+		log.Error().Err(err).Str("pcapName", pcapName).Msg("Failed to mark PCAP file for retention")
+		return err
+	}
+
+	pm.events.publish(PcapEvent{Type: PcapRetained, Name: pcapName, Size: retention.Size, Retention: &retention})
+
+	log.Debug().Str("pcapName", pcapName).Dur("duration", duration).Str("script", owner.Script).Msg("PCAP file marked for retention")
+	return nil
+}
+
+// ListRetentions returns the active retentions and the usage they account for
+func (pm *PcapManager) ListRetentions() (RetentionListing, error) {
+	retentions, err := pm.store.List()
+	if err != nil {
+		return RetentionListing{}, err
+	}
+
+	pm.quotaMu.Lock()
+	quotas := pm.quotas
+	pm.quotaMu.Unlock()
+
+	listing := RetentionListing{
+		Retentions:  retentions,
+		ByScript:    make(map[string]RetentionUsage),
+		ByNamespace: make(map[string]RetentionUsage),
+		Quotas:      quotas,
+	}
+
+	for _, r := range retentions {
+		r.Size = pm.retainedSize(r)
+		if r.Script != "" {
+			listing.ByScript[r.Script] = addUsage(listing.ByScript[r.Script], r)
+		}
+		if r.Namespace != "" {
+			listing.ByNamespace[r.Namespace] = addUsage(listing.ByNamespace[r.Namespace], r)
+		}
+	}
+
+	return listing, nil
+}
+
+// checkRetentionQuotas verifies that adding r keeps its script and namespace within their quotas.
+// The retained files count with their current size, see retainedSize.
+func (pm *PcapManager) checkRetentionQuotas(r Retention) error {
+	if r.Script == "" && r.Namespace == "" {
+		return nil
+	}
+
+	retentions, err := pm.store.List()
+	if err != nil {
+		return err
+	}
+
+	var scriptUsage, namespaceUsage RetentionUsage
+	for _, existing := range retentions {
+		if existing.Name == r.Name {
+			continue
+		}
+		existing.Size = pm.retainedSize(existing)
+		if r.Script != "" && existing.Script == r.Script {
+			scriptUsage = addUsage(scriptUsage, existing)
+		}
+		if r.Namespace != "" && existing.Namespace == r.Namespace {
+			namespaceUsage = addUsage(namespaceUsage, existing)
+		}
+	}
+
+	if r.Script != "" {
+		if err := checkQuota("script", r.Script, addUsage(scriptUsage, r), pm.quotas.PerScript); err != nil {
+			return err
+		}
+	}
+	if r.Namespace != "" {
+		if err := checkQuota("namespace", r.Namespace, addUsage(namespaceUsage, r), pm.quotas.PerNamespace); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkQuota returns a descriptive ErrRetentionQuotaExceeded if usage is over quota
+func checkQuota(kind string, owner string, usage RetentionUsage, quota RetentionQuota) error {
+	if quota.MaxCount > 0 && usage.Count > quota.MaxCount {
+		return fmt.Errorf("%w: %s %q would hold %d retentions, the limit is %d", ErrRetentionQuotaExceeded, kind, owner, usage.Count, quota.MaxCount)
+	}
+	if quota.MaxBytes > 0 && usage.Bytes > quota.MaxBytes {
+		return fmt.Errorf("%w: %s %q would retain %d bytes, the limit is %d", ErrRetentionQuotaExceeded, kind, owner, usage.Bytes, quota.MaxBytes)
+	}
+
+	return nil
+}
+
+// addUsage accounts r to usage
+func addUsage(usage RetentionUsage, r Retention) RetentionUsage {
+	usage.Count++
+	usage.Bytes += r.Size
+	return usage
+}
+
+// retainedSize returns the current size of a retained file, which may have grown or been
+// compressed since it was retained, or the size recorded with the retention if it is unknown
+func (pm *PcapManager) retainedSize(r Retention) int64 {
+	if size := pm.pcapSize(r.Name); size > 0 {
+		return size
+	}
+	if size, exists := pm.snapshotSizeOf(r.Name); exists {
+		return size
+	}
+
+	return r.Size
+}
+
+// pcapSize returns the size on disk of a PCAP file in either tier or the trash, or 0 if it is unknown
+func (pm *PcapManager) pcapSize(pcapName string) int64 {
+	entry, exists := pm.catalog.Get(pcapName)
+	if !exists {
+		return pm.trashedSize(pcapName)
+	}
+
+	return entry.Size
+}
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionCountQuota(t *testing.T) {
+	manager := NewPcapManager(t.TempDir(), 20*time.Second, 1024*1024)
+	manager.SetRetentionQuotas(RetentionQuotas{
+		PerScript: RetentionQuota{MaxCount: 2},
+	})
+
+	owner := RetentionOwner{Script: "DFIR", Namespace: "default"}
+	if err := manager.RetainPcapFor("a.pcap", time.Hour, owner); err != nil {
+		t.Fatalf("First retention should be accepted: %v", err)
+	}
+	if err := manager.RetainPcapFor("b.pcap", time.Hour, owner); err != nil {
+		t.Fatalf("Second retention should be accepted: %v", err)
+	}
+
+	// Re-retaining an existing file is not counted twice
+	if err := manager.RetainPcapFor("a.pcap", 2*time.Hour, owner); err != nil {
+		t.Fatalf("Re-retaining should be accepted: %v", err)
+	}
+
+	err := manager.RetainPcapFor("c.pcap", time.Hour, owner)
+	if !errors.Is(err, ErrRetentionQuotaExceeded) {
+		t.Fatalf("Expected ErrRetentionQuotaExceeded, got %v", err)
+	}
+	if manager.IsRetained("c.pcap") {
+		t.Errorf("Rejected PCAP should not be retained")
+	}
+
+	// Other scripts have their own quota
+	if err := manager.RetainPcapFor("c.pcap", time.Hour, RetentionOwner{Script: "Other"}); err != nil {
+		t.Errorf("Retention by another script should be accepted: %v", err)
+	}
+}
+
+func TestRetentionBytesQuota(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"a.pcap", "b.pcap"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), make([]byte, 600), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	manager := NewPcapManager(tempDir, 20*time.Second, 1024*1024)
+	manager.SetRetentionQuotas(RetentionQuotas{
+		PerNamespace: RetentionQuota{MaxBytes: 1000},
+	})
+
+	if err := manager.RetainPcapFor("a.pcap", time.Hour, RetentionOwner{Script: "s1", Namespace: "prod"}); err != nil {
+		t.Fatalf("First retention should be accepted: %v", err)
+	}
+
+	err := manager.RetainPcapFor("b.pcap", time.Hour, RetentionOwner{Script: "s2", Namespace: "prod"})
+	if !errors.Is(err, ErrRetentionQuotaExceeded) {
+		t.Fatalf("Expected ErrRetentionQuotaExceeded, got %v", err)
+	}
+
+	listing, err := manager.ListRetentions()
+	if err != nil {
+		t.Fatalf("ListRetentions failed: %v", err)
+	}
+	if len(listing.Retentions) != 1 {
+		t.Errorf("Expected 1 retention, got %d", len(listing.Retentions))
+	}
+	if usage := listing.ByNamespace["prod"]; usage.Count != 1 || usage.Bytes != 600 {
+		t.Errorf("Unexpected namespace usage: %+v", usage)
+	}
+	if usage := listing.ByScript["s1"]; usage.Count != 1 || usage.Bytes != 600 {
+		t.Errorf("Unexpected script usage: %+v", usage)
+	}
+
+	// A retained file that grows is charged its current size
+	path := filepath.Join(tempDir, "a.pcap")
+	if err := os.WriteFile(path, make([]byte, 900), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if _, err := manager.catalog.upsert(path, false); err != nil {
+		t.Fatalf("Failed to index test file: %v", err)
+	}
+	if listing, _ := manager.ListRetentions(); listing.ByNamespace["prod"].Bytes != 900 {
+		t.Errorf("Expected the current size to be charged, got %+v", listing.ByNamespace["prod"])
+	}
+	if err := manager.RetainPcapFor("c.pcap", time.Hour, RetentionOwner{Namespace: "prod"}); err != nil {
+		t.Errorf("Retention within the quota should be accepted: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "d.pcap"), make([]byte, 200), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if _, err := manager.catalog.upsert(filepath.Join(tempDir, "d.pcap"), false); err != nil {
+		t.Fatalf("Failed to index test file: %v", err)
+	}
+	if err := manager.RetainPcapFor("d.pcap", time.Hour, RetentionOwner{Namespace: "prod"}); !errors.Is(err, ErrRetentionQuotaExceeded) {
+		t.Errorf("Expected the grown file to leave no room, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubeshark/gopacket"
+	"github.com/kubeshark/gopacket/layers"
+	"github.com/kubeshark/gopacket/pcapgo"
+)
+
+// PacketSummary is the decoded view of a captured packet given to scripts
+type PacketSummary struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Length        int       `json:"length"`
+	CaptureLength int       `json:"captureLength"`
+	Network       string    `json:"network,omitempty"`
+	Transport     string    `json:"transport,omitempty"`
+	SrcIP         string    `json:"srcIp,omitempty"`
+	DstIP         string    `json:"dstIp,omitempty"`
+	SrcPort       uint16    `json:"srcPort,omitempty"`
+	DstPort       uint16    `json:"dstPort,omitempty"`
+	Flags         []string  `json:"flags,omitempty"`
+	PayloadLength int       `json:"payloadLength"`
+	Payload       []byte    `json:"payload,omitempty"`
+}
+
+// PcapReader reads the packets of a PCAP file as summaries
+type PcapReader struct {
+	closer     io.Closer
+	reader     *pcapgo.Reader
+	linkType   layers.LinkType
+	maxPayload int
+}
+
+// OpenPcapReader opens a PCAP file for reading. The path is a PCAP name or a path
+// inside the warm tier, the cold tier or the snapshot directory, files elsewhere are
+// refused. Up to maxPayload bytes of each packet's payload are included in the summaries.
+func (pm *PcapManager) OpenPcapReader(path string, maxPayload int) (*PcapReader, error) {
+	file, err := pm.OpenStoredPcap(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return &PcapReader{
+		closer:     file,
+		reader:     reader,
+		linkType:   reader.LinkType(),
+		maxPayload: maxPayload,
+	}, nil
+}
+
+// OpenStoredPcap opens a PCAP file by name or by a path inside the warm tier, the cold
+// tier or the snapshot directory, files elsewhere are refused
+func (pm *PcapManager) OpenStoredPcap(path string) (io.ReadCloser, error) {
+	name := filepath.Base(path)
+	if path == name {
+		file, err := pm.OpenPcap(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return os.Open(filepath.Join(pm.pcapDir, snapshotDirName, name))
+		}
+		return file, err
+	}
+
+	switch filepath.Dir(filepath.Clean(path)) {
+	case filepath.Clean(pm.pcapDir), filepath.Join(pm.pcapDir, snapshotDirName):
+		return os.Open(filepath.Clean(path))
+	case filepath.Clean(pm.coldDir):
+		if pm.coldDir != "" {
+			return pm.OpenPcap(strings.TrimSuffix(name, coldSuffix))
+		}
+	}
+
+	return nil, fmt.Errorf("%s is outside the PCAP storage", path)
+}
+
+// Next returns the summary of the next packet, or io.EOF after the last one
+func (r *PcapReader) Next() (PacketSummary, error) {
+	data, ci, err := r.reader.ReadPacketData()
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// A file that is still being written ends with a partial record
+			return PacketSummary{}, io.EOF
+		}
+		return PacketSummary{}, err
+	}
+
+	return summarizePacket(data, ci, r.linkType, r.maxPayload), nil
+}
+
+// Close closes the underlying file
+func (r *PcapReader) Close() error {
+	return r.closer.Close()
+}
+
+// summarizePacket decodes the network and transport layers of a packet
+func summarizePacket(data []byte, ci gopacket.CaptureInfo, linkType layers.LinkType, maxPayload int) PacketSummary {
+	summary := PacketSummary{
+		Timestamp:     ci.Timestamp,
+		Length:        ci.Length,
+		CaptureLength: ci.CaptureLength,
+	}
+
+	packet := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true}, 0, 0)
+
+	switch network := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		summary.Network = "IPv4"
+		summary.SrcIP, summary.DstIP = network.SrcIP.String(), network.DstIP.String()
+	case *layers.IPv6:
+		summary.Network = "IPv6"
+		summary.SrcIP, summary.DstIP = network.SrcIP.String(), network.DstIP.String()
+	}
+
+	switch transport := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		summary.Transport = "TCP"
+		summary.SrcPort, summary.DstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+		summary.Flags = tcpFlags(transport)
+	case *layers.UDP:
+		summary.Transport = "UDP"
+		summary.SrcPort, summary.DstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+	case *layers.SCTP:
+		summary.Transport = "SCTP"
+		summary.SrcPort, summary.DstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+	default:
+		if packet.Layer(layers.LayerTypeICMPv4) != nil {
+			summary.Transport = "ICMP"
+		} else if packet.Layer(layers.LayerTypeICMPv6) != nil {
+			summary.Transport = "ICMPv6"
+		}
+	}
+
+	if app := packet.ApplicationLayer(); app != nil {
+		payload := app.Payload()
+		summary.PayloadLength = len(payload)
+		if maxPayload > 0 {
+			if len(payload) > maxPayload {
+				payload = payload[:maxPayload]
+			}
+			summary.Payload = append([]byte(nil), payload...)
+		}
+	}
+
+	return summary
+}
+
+// tcpFlags returns the names of the flags set on a TCP segment
+func tcpFlags(tcp *layers.TCP) []string {
+	var flags []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{tcp.SYN, "SYN"}, {tcp.ACK, "ACK"}, {tcp.FIN, "FIN"}, {tcp.RST, "RST"},
+		{tcp.PSH, "PSH"}, {tcp.URG, "URG"}, {tcp.ECE, "ECE"}, {tcp.CWR, "CWR"},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+
+	return flags
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPcapReaderSummaries(t *testing.T) {
+	tempDir := t.TempDir()
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	writeTestStream(t, filepath.Join(tempDir, "000000000001_tcp.pcap"),
+		testPacket{ts: ts, src: "10.0.0.1", dst: "10.0.0.2", srcPort: 40000, dstPort: 80},
+		testPacket{ts: ts.Add(time.Second), src: "10.0.0.2", dst: "10.0.0.1", udp: true, srcPort: 53, dstPort: 40001},
+	)
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+
+	path, _ := manager.ResolvePcapPath("000000000001")
+	reader, err := manager.OpenPcapReader(path, 2)
+	if err != nil {
+		t.Fatalf("OpenPcapReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	first, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Failed to read first packet: %v", err)
+	}
+	if !first.Timestamp.Equal(ts) || first.Transport != "TCP" || first.SrcIP != "10.0.0.1" || first.DstPort != 80 {
+		t.Errorf("Unexpected summary of the first packet: %+v", first)
+	}
+	if len(first.Flags) != 1 || first.Flags[0] != "ACK" {
+		t.Errorf("Expected the ACK flag, got %v", first.Flags)
+	}
+	if first.PayloadLength != 4 || string(first.Payload) != "te" {
+		t.Errorf("Expected a 4 byte payload truncated to 2 bytes, got %d %q", first.PayloadLength, first.Payload)
+	}
+
+	second, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Failed to read second packet: %v", err)
+	}
+	if second.Transport != "UDP" || second.SrcPort != 53 || second.Flags != nil {
+		t.Errorf("Unexpected summary of the second packet: %+v", second)
+	}
+
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF after the last packet, got %v", err)
+	}
+
+	// Files outside the PCAP storage are refused
+	outside := filepath.Join(t.TempDir(), "other.pcap")
+	writeTestStream(t, outside)
+	for _, path := range []string{outside, filepath.Join(tempDir, "..", "other.pcap"), "/etc/passwd"} {
+		if reader, err := manager.OpenPcapReader(path, 0); err == nil {
+			reader.Close()
+			t.Errorf("Expected %s to be refused", path)
+		}
+	}
+
+	// Names are resolved in the warm tier and the snapshot directory
+	if err := os.MkdirAll(filepath.Join(tempDir, snapshotDirName), 0755); err != nil {
+		t.Fatalf("Failed to create snapshot directory: %v", err)
+	}
+	writeTestStream(t, filepath.Join(tempDir, snapshotDirName, "snap.pcap"))
+	for _, name := range []string{"000000000001_tcp.pcap", "snap.pcap"} {
+		reader, err := manager.OpenPcapReader(name, 0)
+		if err != nil {
+			t.Errorf("Failed to open %s by name: %v", name, err)
+			continue
+		}
+		reader.Close()
+	}
+}
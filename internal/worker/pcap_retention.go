@@ -1,7 +1,6 @@
 package worker
 
 import (
-	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -9,26 +8,32 @@ import (
 
 // PcapRetention manages the retention of PCAP files for scripting
 type PcapRetention struct {
-	retainedPcaps map[string]time.Time
-	mu            sync.Mutex
-	defaultTTL    time.Duration
+	store      RetentionStore
+	defaultTTL time.Duration
 }
 
-// NewPcapRetention creates a new PCAP retention manager
+// NewPcapRetention creates a new PCAP retention manager that keeps retentions in memory
 func NewPcapRetention(defaultTTL time.Duration) *PcapRetention {
+	return NewPcapRetentionWithStore(NewMemoryRetentionStore(), defaultTTL)
+}
+
+// NewPcapRetentionWithStore creates a new PCAP retention manager on top of an existing store,
+// typically the one returned by PcapManager.RetentionStore
+func NewPcapRetentionWithStore(store RetentionStore, defaultTTL time.Duration) *PcapRetention {
 	return &PcapRetention{
-		retainedPcaps: make(map[string]time.Time),
-		defaultTTL:    defaultTTL,
+		store:      store,
+		defaultTTL: defaultTTL,
 	}
 }
 
 // RetainPcap marks a PCAP file for extended retention
 func (pr *PcapRetention) RetainPcap(pcapPath string, ttl time.Duration) {
-	pr.mu.Lock()
-	defer pr.mu.Unlock()
-	
 	retentionTime := time.Now().Add(ttl)
-	pr.retainedPcaps[pcapPath] = retentionTime
+	if err := pr.store.Put(Retention{Name: pcapPath, Until: retentionTime}); err != nil {
+		log.Error().Err(err).Str("pcapPath", pcapPath).Msg("Failed to extend PCAP retention")
+		return
+	}
+
 	log.Debug().
 		Str("pcapPath", pcapPath).
 		Time("retainedUntil", retentionTime).
@@ -37,20 +42,13 @@ func (pr *PcapRetention) RetainPcap(pcapPath string, ttl time.Duration) {
 
 // ShouldRetain checks if a PCAP file should be retained
 func (pr *PcapRetention) ShouldRetain(pcapPath string) bool {
-	pr.mu.Lock()
-	defer pr.mu.Unlock()
-	
-	expiryTime, exists := pr.retainedPcaps[pcapPath]
-	if !exists {
-		return false
+	_, retained, err := pr.store.Get(pcapPath)
+	if err != nil {
+		log.Error().Err(err).Str("pcapPath", pcapPath).Msg("Failed to check PCAP retention")
+		return true
 	}
-	
-	if time.Now().After(expiryTime) {
-		delete(pr.retainedPcaps, pcapPath)
-		return false
-	}
-	
-	return true
+
+	return retained
 }
 
 // GetDefaultTTL returns the default retention TTL
@@ -58,18 +56,17 @@ func (pr *PcapRetention) GetDefaultTTL() time.Duration {
 	return pr.defaultTTL
 }
 
-// CleanupExpired removes expired entries from the retention map
+// CleanupExpired removes expired entries from the retention store
 func (pr *PcapRetention) CleanupExpired() {
-	pr.mu.Lock()
-	defer pr.mu.Unlock()
-	
-	now := time.Now()
-	for path, expiry := range pr.retainedPcaps {
-		if now.After(expiry) {
-			delete(pr.retainedPcaps, path)
-			log.Debug().
-				Str("pcapPath", path).
-				Msg("Removed expired PCAP retention entry")
-		}
+	expired, err := pr.store.PurgeExpired(time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove expired PCAP retention entries")
+		return
+	}
+
+	for _, r := range expired {
+		log.Debug().
+			Str("pcapPath", r.Name).
+			Msg("Removed expired PCAP retention entry")
 	}
 }
@@ -0,0 +1,464 @@
+package worker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubeshark/gopacket"
+	"github.com/kubeshark/gopacket/layers"
+	"github.com/kubeshark/gopacket/pcapgo"
+	"github.com/rs/zerolog/log"
+)
+
+// snapshotDirName is the directory, inside the PCAP directory, that snapshots are written to
+const snapshotDirName = "snapshots"
+
+// defaultSnapshotRetention is how long a snapshot is retained when no duration is given
+const defaultSnapshotRetention = time.Hour
+
+// maxSnapshotRange is the widest time range a snapshot without streams may select
+const maxSnapshotRange = time.Hour
+
+// snapshotBatchSize is the number of files merged at once. Larger selections are merged
+// in batches into intermediate files, which are merged in turn.
+const snapshotBatchSize = 32
+
+// ErrStorageLimitExceeded is returned when a snapshot would exceed the storage limit
+var ErrStorageLimitExceeded = errors.New("storage limit exceeded")
+
+// SnapshotOptions selects the packets copied into a snapshot. Streams are stream IDs or
+// PCAP names, all files overlapping the time range are used when none are given. Zero times
+// leave the range of streams open. Without streams, To defaults to now, From to maxSnapshotRange
+// before To, and wider ranges are refused. BPF is a filter expression, see compilePacketFilter.
+type SnapshotOptions struct {
+	Streams []string
+	From    time.Time
+	To      time.Time
+	BPF     string
+	Retain  time.Duration
+	Owner   RetentionOwner
+}
+
+// SnapshotResult describes a snapshot written by Snapshot
+type SnapshotResult struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Packets int    `json:"packets"`
+	Size    int64  `json:"size"`
+}
+
+// Snapshot builds a self-contained PCAP file from the packets of the selected streams
+// and time range, merged in timestamp order. The snapshot is written to the snapshot
+// directory and retained for opts.Retain on behalf of opts.Owner. Snapshots count toward
+// the storage usage, and one that would exceed the storage limit is refused.
+func (pm *PcapManager) Snapshot(name string, opts SnapshotOptions) (SnapshotResult, error) {
+	if !strings.HasSuffix(name, ".pcap") {
+		name += ".pcap"
+	}
+	if err := validatePcapName(name); err != nil {
+		return SnapshotResult{}, err
+	}
+
+	filter, err := compilePacketFilter(opts.BPF)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+
+	sources, opts, err := pm.snapshotSources(opts)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+
+	retain := opts.Retain
+	if retain <= 0 {
+		retain = defaultSnapshotRetention
+	}
+	retention := Retention{
+		Name:      name,
+		Script:    opts.Owner.Script,
+		Namespace: opts.Owner.Namespace,
+	}
+
+	// An owner out of quota is refused before the merge, the size is checked again once known
+	pm.quotaMu.Lock()
+	err = pm.checkRetentionQuotas(retention)
+	pm.quotaMu.Unlock()
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+
+	// The storage left under the limit bounds the snapshot, no bound without a limit
+	var budget int64
+	if pm.storageLimit > 0 {
+		usage, _ := pm.GetStorageUsage()
+		if budget = pm.storageLimit - usage; budget <= 0 {
+			return SnapshotResult{}, fmt.Errorf("%w: %d of %d bytes used", ErrStorageLimitExceeded, usage, pm.storageLimit)
+		}
+	}
+
+	dir := filepath.Join(pm.pcapDir, snapshotDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return SnapshotResult{}, fmt.Errorf("failed to create snapshot directory %s: %w", dir, err)
+	}
+
+	// Snapshots share the retention store with the captured files, so their names can't overlap
+	path := filepath.Join(dir, name)
+	if _, exists := pm.catalog.Get(name); exists {
+		return SnapshotResult{}, fmt.Errorf("snapshot name %s is used by a captured PCAP file", name)
+	}
+
+	// The temporary file reserves the name until the snapshot is renamed into place, so the
+	// snapshot can't already exist once it's created
+	tmpPath := filepath.Join(dir, "."+name+".tmp")
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return SnapshotResult{}, fmt.Errorf("snapshot %s is already being written", name)
+		}
+		return SnapshotResult{}, err
+	}
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	if _, err := os.Stat(path); err == nil {
+		return SnapshotResult{}, fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	packets, err := pm.writeSnapshot(tmpPath, sources, opts, filter, budget)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+
+	// The retention is stored before the rename, so cleanupSnapshots never sees the snapshot unretained
+	retention.Until = time.Now().Add(retain)
+	retention.Size = info.Size()
+	if err := pm.retainSnapshot(retention); err != nil {
+		return SnapshotResult{}, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		if err := pm.store.Delete(name); err != nil {
+			log.Error().Err(err).Str("snapshot", name).Msg("Failed to release the retention of a failed snapshot")
+		}
+		return SnapshotResult{}, err
+	}
+	pm.addSnapshot(name, info.Size())
+	pm.events.publish(PcapEvent{Type: PcapRetained, Name: name, Size: retention.Size, Retention: &retention})
+
+	log.Debug().Str("snapshot", path).Int("packets", packets).Int("sources", len(sources)).Msg("Created PCAP snapshot")
+	return SnapshotResult{Name: name, Path: path, Packets: packets, Size: info.Size()}, nil
+}
+
+// retainSnapshot stores the retention of a new snapshot, within the quotas of its owner
+func (pm *PcapManager) retainSnapshot(retention Retention) error {
+	pm.quotaMu.Lock()
+	defer pm.quotaMu.Unlock()
+
+	if err := pm.checkRetentionQuotas(retention); err != nil {
+		return err
+	}
+	if err := pm.store.Put(retention); err != nil {
+		return fmt.Errorf("failed to retain snapshot %s: %w", retention.Name, err)
+	}
+
+	return nil
+}
+
+// snapshotSources returns the catalog entries a snapshot reads from, and the options
+// with the time range of a snapshot without streams filled in
+func (pm *PcapManager) snapshotSources(opts SnapshotOptions) ([]PcapEntry, SnapshotOptions, error) {
+	if len(opts.Streams) > 0 {
+		sources := make([]PcapEntry, 0, len(opts.Streams))
+		for _, stream := range opts.Streams {
+			entry, exists := pm.catalog.Lookup(stream)
+			if !exists {
+				return nil, opts, fmt.Errorf("stream %q not found", stream)
+			}
+			sources = append(sources, entry)
+		}
+		return sources, opts, nil
+	}
+
+	if opts.From.IsZero() && opts.To.IsZero() {
+		return nil, opts, errors.New("a snapshot needs streams or a time range")
+	}
+	if opts.To.IsZero() {
+		opts.To = time.Now()
+	}
+	if opts.From.IsZero() {
+		opts.From = opts.To.Add(-maxSnapshotRange)
+	}
+	if opts.To.Sub(opts.From) > maxSnapshotRange {
+		return nil, opts, fmt.Errorf("snapshot time range %s exceeds %s", opts.To.Sub(opts.From), maxSnapshotRange)
+	}
+
+	var sources []PcapEntry
+	for _, entry := range pm.catalog.List() {
+		if entry.LastPacketTime.Before(opts.From) && !entry.LastPacketTime.IsZero() {
+			continue
+		}
+		if entry.FirstPacketTime.After(opts.To) {
+			continue
+		}
+		sources = append(sources, entry)
+	}
+
+	return sources, opts, nil
+}
+
+// snapshotInput is a file merged into a snapshot
+type snapshotInput struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// snapshotSource is an open source file and its next packet
+type snapshotSource struct {
+	name   string
+	closer io.Closer
+	reader *pcapgo.Reader
+	data   []byte
+	ci     gopacket.CaptureInfo
+	done   bool
+}
+
+// advance reads the next packet of the source
+func (s *snapshotSource) advance() {
+	data, ci, err := s.reader.ReadPacketData()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			log.Debug().Err(err).Str("pcapName", s.name).Msg("Stopped reading snapshot source")
+		}
+		s.done = true
+		return
+	}
+
+	s.data, s.ci = data, ci
+}
+
+// writeSnapshot merges the matching packets of the sources into path and returns their count.
+// At most snapshotBatchSize files are open at once, larger selections are merged in rounds
+// through intermediate files next to path. Each round writes at most budget bytes, if positive.
+func (pm *PcapManager) writeSnapshot(path string, entries []PcapEntry, opts SnapshotOptions, filter packetFilter, budget int64) (int, error) {
+	inputs := make([]snapshotInput, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name
+		inputs = append(inputs, snapshotInput{name: name, open: func() (io.ReadCloser, error) {
+			return pm.OpenPcap(name)
+		}})
+	}
+
+	keep := func(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType) bool {
+		ts := ci.Timestamp
+		inRange := (opts.From.IsZero() || !ts.Before(opts.From)) && (opts.To.IsZero() || !ts.After(opts.To))
+		return inRange && filter(gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true}, 0, 0))
+	}
+
+	var parts []string
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+
+	for round := 0; len(inputs) > snapshotBatchSize; round++ {
+		var merged []snapshotInput
+		var roundParts []string
+		for i := 0; i < len(inputs); i += snapshotBatchSize {
+			part := fmt.Sprintf("%s.%d.%d", path, round, len(roundParts))
+			roundParts = append(roundParts, part)
+			parts = append(parts, part)
+			if _, err := mergeSnapshotInputs(part, inputs[i:min(i+snapshotBatchSize, len(inputs))], keep, budget); err != nil {
+				return 0, err
+			}
+			merged = append(merged, snapshotInput{name: filepath.Base(part), open: func() (io.ReadCloser, error) {
+				return os.Open(part)
+			}})
+		}
+
+		// The intermediate files of the previous round are merged, and their packets already selected
+		for _, part := range parts[:len(parts)-len(roundParts)] {
+			os.Remove(part)
+		}
+		parts = roundParts
+		inputs = merged
+		keep = nil
+	}
+
+	return mergeSnapshotInputs(path, inputs, keep, budget)
+}
+
+// mergeSnapshotInputs merges the packets of inputs kept by keep, or all of them if keep
+// is nil, into path in timestamp order and returns their count
+func mergeSnapshotInputs(path string, inputs []snapshotInput, keep func(gopacket.CaptureInfo, []byte, layers.LinkType) bool, budget int64) (int, error) {
+	var sources []*snapshotSource
+	defer func() {
+		for _, source := range sources {
+			source.closer.Close()
+		}
+	}()
+
+	var linkType layers.LinkType
+	var snaplen uint32
+	for _, input := range inputs {
+		file, err := input.open()
+		if err != nil {
+			return 0, fmt.Errorf("failed to open %s: %w", input.name, err)
+		}
+
+		reader, err := pcapgo.NewReader(file)
+		if err != nil {
+			file.Close()
+			return 0, fmt.Errorf("failed to read %s: %w", input.name, err)
+		}
+
+		if len(sources) == 0 {
+			linkType = reader.LinkType()
+		} else if reader.LinkType() != linkType {
+			file.Close()
+			return 0, fmt.Errorf("%s has link type %s, the snapshot has %s", input.name, reader.LinkType(), linkType)
+		}
+		if reader.Snaplen() > snaplen {
+			snaplen = reader.Snaplen()
+		}
+
+		source := &snapshotSource{name: input.name, closer: file, reader: reader}
+		sources = append(sources, source)
+		source.advance()
+	}
+	if snaplen == 0 {
+		snaplen = 262144
+	}
+	if linkType == 0 {
+		linkType = layers.LinkTypeEthernet
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	buffer := bufio.NewWriter(file)
+	writer := pcapgo.NewWriter(buffer)
+	if err := writer.WriteFileHeader(snaplen, linkType); err != nil {
+		return 0, err
+	}
+
+	packets := 0
+	size := int64(pcapGlobalHeaderLen)
+	for {
+		// Pick the source holding the earliest packet
+		var next *snapshotSource
+		for _, source := range sources {
+			if !source.done && (next == nil || source.ci.Timestamp.Before(next.ci.Timestamp)) {
+				next = source
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		if keep == nil || keep(next.ci, next.data, linkType) {
+			size += pcapRecordHeaderLen + int64(len(next.data))
+			if budget > 0 && size > budget {
+				return packets, fmt.Errorf("%w: the snapshot needs more than the %d bytes left", ErrStorageLimitExceeded, budget)
+			}
+			if err := writer.WritePacket(next.ci, next.data); err != nil {
+				return packets, err
+			}
+			packets++
+		}
+
+		next.advance()
+	}
+
+	return packets, flushAndSync(file, buffer)
+}
+
+// scanSnapshots indexes the sizes of the snapshots left by a previous run, and removes the
+// temporary files of the snapshots it didn't finish
+func (pm *PcapManager) scanSnapshots() {
+	dir := filepath.Join(pm.pcapDir, snapshotDirName)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(file.Name(), ".") {
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		if info, err := file.Info(); err == nil {
+			pm.addSnapshot(file.Name(), info.Size())
+		}
+	}
+}
+
+// addSnapshot accounts the size of a snapshot
+func (pm *PcapManager) addSnapshot(name string, size int64) {
+	pm.snapshotMu.Lock()
+	defer pm.snapshotMu.Unlock()
+
+	pm.snapshots[name] = size
+}
+
+// snapshotSizeOf returns the size of a snapshot, and whether it exists
+func (pm *PcapManager) snapshotSizeOf(name string) (int64, bool) {
+	pm.snapshotMu.Lock()
+	defer pm.snapshotMu.Unlock()
+
+	size, exists := pm.snapshots[name]
+	return size, exists
+}
+
+// snapshotSize returns the total size of the snapshots
+func (pm *PcapManager) snapshotSize() int64 {
+	pm.snapshotMu.Lock()
+	defer pm.snapshotMu.Unlock()
+
+	var size int64
+	for _, s := range pm.snapshots {
+		size += s
+	}
+
+	return size
+}
+
+// cleanupSnapshots removes the snapshots whose retention ended
+func (pm *PcapManager) cleanupSnapshots() {
+	dir := filepath.Join(pm.pcapDir, snapshotDirName)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || pm.isRetained(file.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Str("snapshot", path).Msg("Failed to remove expired snapshot")
+			continue
+		}
+		pm.snapshotMu.Lock()
+		delete(pm.snapshots, file.Name())
+		pm.snapshotMu.Unlock()
+		log.Debug().Str("snapshot", path).Msg("Removed expired snapshot")
+	}
+}
@@ -0,0 +1,336 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/gopacket"
+	"github.com/kubeshark/gopacket/layers"
+	"github.com/kubeshark/gopacket/pcapgo"
+)
+
+// testPacket describes an Ethernet/IPv4 packet written by writeTestStream
+type testPacket struct {
+	ts      time.Time
+	src     string
+	dst     string
+	udp     bool
+	srcPort uint16
+	dstPort uint16
+}
+
+// writeTestStream writes a PCAP file holding real IPv4 TCP or UDP packets
+func writeTestStream(t *testing.T, path string, packets ...testPacket) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create test pcap: %v", err)
+	}
+	defer file.Close()
+
+	writer := pcapgo.NewWriter(file)
+	if err := writer.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Failed to write pcap header: %v", err)
+	}
+
+	for _, p := range packets {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := &layers.IPv4{Version: 4, TTL: 64, SrcIP: net.ParseIP(p.src), DstIP: net.ParseIP(p.dst), Protocol: layers.IPProtocolTCP}
+
+		var transport gopacket.SerializableLayer
+		if p.udp {
+			ip.Protocol = layers.IPProtocolUDP
+			udp := &layers.UDP{SrcPort: layers.UDPPort(p.srcPort), DstPort: layers.UDPPort(p.dstPort)}
+			udp.SetNetworkLayerForChecksum(ip)
+			transport = udp
+		} else {
+			tcp := &layers.TCP{SrcPort: layers.TCPPort(p.srcPort), DstPort: layers.TCPPort(p.dstPort), ACK: true, Window: 1024}
+			tcp.SetNetworkLayerForChecksum(ip)
+			transport = tcp
+		}
+
+		buffer := gopacket.NewSerializeBuffer()
+		options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buffer, options, eth, ip, transport, gopacket.Payload("test")); err != nil {
+			t.Fatalf("Failed to serialize packet: %v", err)
+		}
+
+		data := buffer.Bytes()
+		ci := gopacket.CaptureInfo{Timestamp: p.ts, CaptureLength: len(data), Length: len(data)}
+		if err := writer.WritePacket(ci, data); err != nil {
+			t.Fatalf("Failed to write packet: %v", err)
+		}
+	}
+}
+
+func TestPacketFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.pcap")
+	writeTestStream(t, path,
+		testPacket{src: "10.0.0.1", dst: "10.0.0.2", srcPort: 40000, dstPort: 80},
+		testPacket{src: "10.1.0.1", dst: "10.0.0.2", udp: true, srcPort: 40001, dstPort: 53},
+	)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open test pcap: %v", err)
+	}
+	defer file.Close()
+
+	source := gopacket.NewPacketSource(mustReader(t, file), layers.LinkTypeEthernet)
+	var tcpPacket, udpPacket gopacket.Packet
+	for packet := range source.Packets() {
+		if packet.Layer(layers.LayerTypeTCP) != nil {
+			tcpPacket = packet
+		} else {
+			udpPacket = packet
+		}
+	}
+	if tcpPacket == nil || udpPacket == nil {
+		t.Fatalf("Expected a TCP and a UDP packet")
+	}
+
+	tests := []struct {
+		expr string
+		tcp  bool
+		udp  bool
+	}{
+		{"", true, true},
+		{"tcp", true, false},
+		{"udp port 53", false, true},
+		{"tcp port 53", false, false},
+		{"dst port 80", true, false},
+		{"src port 80", false, false},
+		{"host 10.0.0.2", true, true},
+		{"src net 10.1.0.0/16", false, true},
+		{"not src host 10.0.0.1", false, true},
+		{"tcp or (udp and dst port 53)", true, true},
+		{"ip && !udp", true, false},
+	}
+	for _, test := range tests {
+		filter, err := compilePacketFilter(test.expr)
+		if err != nil {
+			t.Errorf("Failed to compile %q: %v", test.expr, err)
+			continue
+		}
+		if filter(tcpPacket) != test.tcp || filter(udpPacket) != test.udp {
+			t.Errorf("Filter %q: expected tcp=%v udp=%v, got tcp=%v udp=%v", test.expr, test.tcp, test.udp, filter(tcpPacket), filter(udpPacket))
+		}
+	}
+
+	for _, expr := range []string{"host", "port http", "host 10.0.0.1 or", "(tcp", "vlan 10"} {
+		if _, err := compilePacketFilter(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestSnapshotMergesStreams(t *testing.T) {
+	tempDir := t.TempDir()
+	base := time.Now().Add(-time.Minute).Truncate(time.Second)
+	writeTestStream(t, filepath.Join(tempDir, "000000000001_tcp.pcap"),
+		testPacket{ts: base, src: "10.0.0.1", dst: "10.0.0.2", srcPort: 40000, dstPort: 80},
+		testPacket{ts: base.Add(2 * time.Second), src: "10.0.0.2", dst: "10.0.0.1", srcPort: 80, dstPort: 40000},
+		testPacket{ts: base.Add(4 * time.Second), src: "10.0.0.1", dst: "10.0.0.2", srcPort: 40000, dstPort: 80},
+	)
+	writeTestStream(t, filepath.Join(tempDir, "000000000002_udp.pcap"),
+		testPacket{ts: base.Add(time.Second), src: "10.0.0.3", dst: "10.0.0.4", udp: true, srcPort: 40001, dstPort: 53},
+		testPacket{ts: base.Add(3 * time.Second), src: "10.0.0.4", dst: "10.0.0.3", udp: true, srcPort: 53, dstPort: 40001},
+	)
+
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+	owner := RetentionOwner{Script: "DFIR"}
+
+	result, err := manager.Snapshot("incident", SnapshotOptions{
+		Streams: []string{"000000000001", "000000000002"},
+		To:      base.Add(3 * time.Second),
+		Owner:   owner,
+	})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if result.Packets != 4 || result.Name != "incident.pcap" {
+		t.Fatalf("Unexpected snapshot result: %+v", result)
+	}
+	if result.Path != filepath.Join(tempDir, snapshotDirName, "incident.pcap") {
+		t.Errorf("Snapshot should be written to the snapshot directory, got %s", result.Path)
+	}
+	if !manager.IsRetained("incident.pcap") {
+		t.Errorf("Snapshot should be retained")
+	}
+
+	// The packets of both streams are interleaved in timestamp order
+	file, err := os.Open(result.Path)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer file.Close()
+	reader := mustReader(t, file)
+	for i := 0; i < 4; i++ {
+		_, ci, err := reader.ReadPacketData()
+		if err != nil {
+			t.Fatalf("Failed to read packet %d: %v", i, err)
+		}
+		if expected := base.Add(time.Duration(i) * time.Second); !ci.Timestamp.Equal(expected) {
+			t.Errorf("Packet %d: expected timestamp %v, got %v", i, expected, ci.Timestamp)
+		}
+	}
+
+	// Without streams, the time range selects the files and BPF filters the packets
+	filtered, err := manager.Snapshot("dns.pcap", SnapshotOptions{From: base, BPF: "udp port 53", Owner: owner})
+	if err != nil {
+		t.Fatalf("Filtered snapshot failed: %v", err)
+	}
+	if filtered.Packets != 2 {
+		t.Errorf("Expected 2 DNS packets, got %d", filtered.Packets)
+	}
+
+	if _, err := manager.Snapshot("incident", SnapshotOptions{Streams: []string{"000000000001"}}); err == nil {
+		t.Errorf("Existing snapshots should not be overwritten")
+	}
+	if _, err := manager.Snapshot("000000000001_tcp", SnapshotOptions{Streams: []string{"000000000001"}}); err == nil {
+		t.Errorf("Snapshots should not reuse the name of a captured file")
+	}
+	if _, err := manager.Snapshot("missing", SnapshotOptions{Streams: []string{"000000000009"}}); err == nil {
+		t.Errorf("Unknown streams should be rejected")
+	}
+
+	// A snapshot being written reserves its name, until a restart removes its temporary file
+	pending := filepath.Join(tempDir, snapshotDirName, ".pending.pcap.tmp")
+	if err := os.WriteFile(pending, nil, 0644); err != nil {
+		t.Fatalf("Failed to write temporary file: %v", err)
+	}
+	if _, err := manager.Snapshot("pending", SnapshotOptions{Streams: []string{"000000000001"}}); err == nil {
+		t.Errorf("Snapshots being written should not be written concurrently")
+	}
+	NewPcapManager(tempDir, time.Hour, 1024*1024)
+	if _, err := os.Stat(pending); !os.IsNotExist(err) {
+		t.Errorf("Temporary snapshot files should be removed on restart, stat returned %v", err)
+	}
+
+	// Released snapshots are removed by the next cleanup
+	manager.ReleasePcap("incident.pcap")
+	manager.CleanupExpiredPcaps()
+	if _, err := os.Stat(result.Path); !os.IsNotExist(err) {
+		t.Errorf("Released snapshot should be removed, stat returned %v", err)
+	}
+	if _, err := os.Stat(filtered.Path); err != nil {
+		t.Errorf("Retained snapshot should be kept: %v", err)
+	}
+}
+
+func TestSnapshotMergesInBatches(t *testing.T) {
+	tempDir := t.TempDir()
+	base := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	// More streams than are merged at once, with interleaved packets
+	count := snapshotBatchSize*2 + 5
+	var streams []string
+	for i := 0; i < count; i++ {
+		streamID := fmt.Sprintf("%012d_tcp", i+1)
+		streams = append(streams, streamID)
+		writeTestStream(t, filepath.Join(tempDir, streamID+".pcap"),
+			testPacket{ts: base.Add(time.Duration(i) * time.Millisecond), src: "10.0.0.1", dst: "10.0.0.2", srcPort: 40000, dstPort: 80},
+			testPacket{ts: base.Add(time.Duration(count+i) * time.Millisecond), src: "10.0.0.2", dst: "10.0.0.1", srcPort: 80, dstPort: 40000},
+		)
+	}
+
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+	result, err := manager.Snapshot("batches", SnapshotOptions{Streams: streams})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if result.Packets != count*2 {
+		t.Fatalf("Expected %d packets, got %d", count*2, result.Packets)
+	}
+
+	file, err := os.Open(result.Path)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer file.Close()
+	reader := mustReader(t, file)
+	for i := 0; i < count*2; i++ {
+		_, ci, err := reader.ReadPacketData()
+		if err != nil {
+			t.Fatalf("Failed to read packet %d: %v", i, err)
+		}
+		if expected := base.Add(time.Duration(i) * time.Millisecond); !ci.Timestamp.Equal(expected) {
+			t.Fatalf("Packet %d: expected timestamp %v, got %v", i, expected, ci.Timestamp)
+		}
+	}
+
+	// The intermediate files are removed
+	files, _ := os.ReadDir(filepath.Join(tempDir, snapshotDirName))
+	if len(files) != 1 {
+		t.Errorf("Expected only the snapshot in the snapshot directory, got %d files", len(files))
+	}
+}
+
+func TestSnapshotLimits(t *testing.T) {
+	tempDir := t.TempDir()
+	base := time.Now().Add(-time.Minute).Truncate(time.Second)
+	writeTestStream(t, filepath.Join(tempDir, "000000000001_tcp.pcap"),
+		testPacket{ts: base, src: "10.0.0.1", dst: "10.0.0.2", srcPort: 40000, dstPort: 80},
+		testPacket{ts: base.Add(time.Second), src: "10.0.0.2", dst: "10.0.0.1", srcPort: 80, dstPort: 40000},
+	)
+	info, err := os.Stat(filepath.Join(tempDir, "000000000001_tcp.pcap"))
+	if err != nil {
+		t.Fatalf("Failed to stat test pcap: %v", err)
+	}
+
+	manager := NewPcapManager(tempDir, time.Hour, info.Size()*5/2)
+
+	// Time ranges are capped, an unset start selects up to maxSnapshotRange before the end
+	if _, err := manager.Snapshot("wide", SnapshotOptions{From: base.Add(-2 * maxSnapshotRange), To: base}); err == nil {
+		t.Errorf("Time ranges wider than %s should be refused", maxSnapshotRange)
+	}
+	result, err := manager.Snapshot("recent", SnapshotOptions{To: base.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if result.Packets != 2 {
+		t.Errorf("Expected 2 packets, got %d", result.Packets)
+	}
+
+	// Snapshots count toward the storage usage, and survive a restart
+	usage, _ := manager.GetStorageUsage()
+	if usage != info.Size()+result.Size {
+		t.Errorf("Expected storage usage %d, got %d", info.Size()+result.Size, usage)
+	}
+	if breakdown := manager.GetStorageBreakdown(); breakdown.Snapshots.Files != 1 || breakdown.Total != usage {
+		t.Errorf("Expected the snapshot in the breakdown, got %+v", breakdown)
+	}
+	restarted := NewPcapManager(tempDir, time.Hour, info.Size()*5/2)
+	if restartUsage, _ := restarted.GetStorageUsage(); restartUsage != usage {
+		t.Errorf("Expected storage usage %d after a restart, got %d", usage, restartUsage)
+	}
+
+	// A snapshot that doesn't fit under the storage limit is refused and removed
+	if _, err := manager.Snapshot("full", SnapshotOptions{Streams: []string{"000000000001"}}); !errors.Is(err, ErrStorageLimitExceeded) {
+		t.Errorf("Expected ErrStorageLimitExceeded, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, snapshotDirName, "full.pcap")); !os.IsNotExist(err) {
+		t.Errorf("Refused snapshot should not be kept, stat returned %v", err)
+	}
+}
+
+func mustReader(t *testing.T, file *os.File) *pcapgo.Reader {
+	t.Helper()
+
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to read pcap header: %v", err)
+	}
+
+	return reader
+}
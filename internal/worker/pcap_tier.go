@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// coldSuffix is appended to the names of compressed PCAP files in the cold tier
+const coldSuffix = ".gz"
+
+// SetColdTier enables the compressed cold tier. During cleanup, PCAP files older than
+// warmAfter are compressed into coldDir, which may live on a different volume
+// (e.g. the persistent storage claim). Retained files are moved as well.
+func (pm *PcapManager) SetColdTier(coldDir string, warmAfter time.Duration) error {
+	if err := os.MkdirAll(coldDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cold tier directory %s: %w", coldDir, err)
+	}
+
+	pm.coldDir = coldDir
+	pm.warmAfter = warmAfter
+	return pm.catalog.scanDir(coldDir, true)
+}
+
+// GetColdDir returns the cold tier directory, or an empty string if the cold tier is disabled
+func (pm *PcapManager) GetColdDir() string {
+	return pm.coldDir
+}
+
+// OpenPcap opens a PCAP file by name, transparently decompressing it if it was moved to the cold tier
+func (pm *PcapManager) OpenPcap(pcapName string) (io.ReadCloser, error) {
+	if err := validatePcapName(pcapName); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(pm.pcapDir, pcapName))
+	if err == nil || !errors.Is(err, os.ErrNotExist) || pm.coldDir == "" {
+		return file, err
+	}
+
+	coldFile, err := os.Open(filepath.Join(pm.coldDir, pcapName+coldSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(coldFile)
+	if err != nil {
+		coldFile.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %w", pcapName, err)
+	}
+
+	return &coldPcapReader{Reader: reader, file: coldFile}, nil
+}
+
+// coldPcapReader closes both the decompressor and the underlying file
+type coldPcapReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *coldPcapReader) Close() error {
+	return errors.Join(r.Reader.Close(), r.file.Close())
+}
+
+// moveToColdTier compresses a PCAP file into the cold tier and removes the original.
+// The modification time is preserved, so the TTL keeps counting from the capture time.
+func (pm *PcapManager) moveToColdTier(pcapName string, modTime time.Time) error {
+	srcPath := filepath.Join(pm.pcapDir, pcapName)
+	dstPath := filepath.Join(pm.coldDir, pcapName+coldSuffix)
+	tmpPath := dstPath + ".tmp"
+
+	if err := compressFile(srcPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Chtimes(tmpPath, modTime, modTime); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Remove(srcPath); err != nil {
+		return err
+	}
+	pm.catalog.move(srcPath, dstPath, true)
+
+	log.Debug().Str("file", srcPath).Str("cold", dstPath).Msg("Moved PCAP file to the cold tier")
+	return nil
+}
+
+// compressFile gzips src into dst and syncs dst to disk
+func compressFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+
+	return out.Sync()
+}
+
+// validatePcapName rejects names that would escape the PCAP directories
+func validatePcapName(pcapName string) error {
+	if pcapName == "" || pcapName != filepath.Base(pcapName) || pcapName == "." || pcapName == ".." {
+		return fmt.Errorf("invalid PCAP name %q", pcapName)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestColdTierMovesAgedPcaps(t *testing.T) {
+	pcapDir := t.TempDir()
+	coldDir := filepath.Join(t.TempDir(), "cold")
+
+	// Create an aged and a fresh PCAP file
+	aged := filepath.Join(pcapDir, "aged.pcap")
+	fresh := filepath.Join(pcapDir, "fresh.pcap")
+	if err := os.WriteFile(aged, []byte("aged data"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(fresh, []byte("fresh data"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	pastTime := time.Now().Add(-30 * time.Second)
+	if err := os.Chtimes(aged, pastTime, pastTime); err != nil {
+		t.Fatalf("Failed to set file time: %v", err)
+	}
+
+	// Create manager with 1h TTL and a 10s warm threshold
+	manager := NewPcapManager(pcapDir, time.Hour, 1024*1024)
+	if err := manager.SetColdTier(coldDir, 10*time.Second); err != nil {
+		t.Fatalf("SetColdTier failed: %v", err)
+	}
+
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+
+	if _, err := os.Stat(aged); !os.IsNotExist(err) {
+		t.Fatalf("Aged PCAP was not moved out of the warm tier")
+	}
+	if _, err := os.Stat(filepath.Join(coldDir, "aged.pcap.gz")); err != nil {
+		t.Fatalf("Aged PCAP was not compressed into the cold tier: %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("Fresh PCAP should stay in the warm tier: %v", err)
+	}
+
+	// Reads through the manager decompress transparently
+	reader, err := manager.OpenPcap("aged.pcap")
+	if err != nil {
+		t.Fatalf("OpenPcap failed: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read cold PCAP: %v", err)
+	}
+	if string(data) != "aged data" {
+		t.Errorf("Expected %q, got %q", "aged data", string(data))
+	}
+}
+
+func TestColdTierRetainedPcaps(t *testing.T) {
+	pcapDir := t.TempDir()
+	coldDir := filepath.Join(pcapDir, "cold")
+
+	retained := filepath.Join(pcapDir, "retained.pcap")
+	expired := filepath.Join(pcapDir, "expired.pcap")
+	for _, path := range []string{retained, expired} {
+		if err := os.WriteFile(path, []byte("test data"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	manager := NewPcapManager(pcapDir, time.Minute, 1024*1024)
+	if err := manager.SetColdTier(coldDir, 10*time.Second); err != nil {
+		t.Fatalf("SetColdTier failed: %v", err)
+	}
+	_ = manager.RetainPcap("retained.pcap", time.Hour)
+
+	// Age both files into the cold tier
+	pastTime := time.Now().Add(-30 * time.Second)
+	for _, path := range []string{retained, expired} {
+		if err := os.Chtimes(path, pastTime, pastTime); err != nil {
+			t.Fatalf("Failed to set file time: %v", err)
+		}
+	}
+	if err := manager.Rescan(); err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+
+	// Age the cold files past the TTL
+	pastTime = time.Now().Add(-2 * time.Minute)
+	for _, name := range []string{"retained.pcap.gz", "expired.pcap.gz"} {
+		if err := os.Chtimes(filepath.Join(coldDir, name), pastTime, pastTime); err != nil {
+			t.Fatalf("Failed to set file time: %v", err)
+		}
+	}
+	if err := manager.Rescan(); err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(coldDir, "retained.pcap.gz")); err != nil {
+		t.Fatalf("Retained cold PCAP was incorrectly removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(coldDir, "expired.pcap.gz")); !os.IsNotExist(err) {
+		t.Fatalf("Expired cold PCAP was not removed")
+	}
+}
+
+func TestOpenPcapRejectsTraversal(t *testing.T) {
+	manager := NewPcapManager(t.TempDir(), time.Minute, 1024*1024)
+
+	if _, err := manager.OpenPcap("../etc/passwd"); err == nil {
+		t.Errorf("OpenPcap should reject names outside the PCAP directory")
+	}
+}
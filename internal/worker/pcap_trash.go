@@ -0,0 +1,249 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// trashDirName is the directory, inside each tier, that expired PCAP files are moved to
+const trashDirName = ".trash"
+
+// trashedPcap is an expired PCAP file waiting in the trash for its grace period to end
+type trashedPcap struct {
+	name      string
+	path      string
+	cold      bool
+	size      int64
+	trashedAt time.Time
+}
+
+// SetGracePeriod enables soft deletion. Expired PCAP files are moved to a trash directory
+// inside their tier and only removed after the grace period, so a retention that arrives
+// late restores the file instead of missing it. Files already in the trash, e.g. after a
+// restart, get a full grace period. It must be called after SetColdTier.
+func (pm *PcapManager) SetGracePeriod(gracePeriod time.Duration) error {
+	pm.trashMu.Lock()
+	defer pm.trashMu.Unlock()
+
+	pm.gracePeriod = gracePeriod
+
+	now := time.Now()
+	for _, tier := range pm.tierDirs() {
+		dir := filepath.Join(tier.dir, trashDirName)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create trash directory %s: %w", dir, err)
+		}
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			info, err := file.Info()
+			if err != nil || file.IsDir() || !isCatalogFile(file.Name(), tier.cold) {
+				continue
+			}
+			name := file.Name()
+			if tier.cold {
+				name = name[:len(name)-len(coldSuffix)]
+			}
+			pm.trash[name] = &trashedPcap{
+				name:      name,
+				path:      filepath.Join(dir, file.Name()),
+				cold:      tier.cold,
+				size:      info.Size(),
+				trashedAt: now,
+			}
+		}
+	}
+
+	return nil
+}
+
+// tier is a PCAP directory and whether it holds compressed files
+type tier struct {
+	dir  string
+	cold bool
+}
+
+// tierDirs returns the enabled tiers
+func (pm *PcapManager) tierDirs() []tier {
+	tiers := []tier{{dir: pm.pcapDir}}
+	if pm.coldDir != "" {
+		tiers = append(tiers, tier{dir: pm.coldDir, cold: true})
+	}
+
+	return tiers
+}
+
+// deletePcap removes an expired PCAP file, or moves it to the trash if a grace period is set
+func (pm *PcapManager) deletePcap(entry PcapEntry) error {
+	pm.trashMu.Lock()
+	defer pm.trashMu.Unlock()
+
+	if pm.gracePeriod <= 0 {
+		if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		pm.catalog.remove(entry.Path)
+		pm.publishEntryEvent(PcapEvicted, entry)
+		pm.publishEntryEvent(PcapDeleted, entry)
+		pm.forgetRegistration(entry.Name)
+		log.Debug().Str("file", entry.Path).Msg("Removed expired PCAP file")
+		return nil
+	}
+
+	trashDir := filepath.Join(filepath.Dir(entry.Path), trashDirName)
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return fmt.Errorf("failed to create trash directory %s: %w", trashDir, err)
+	}
+
+	trashPath := filepath.Join(trashDir, filepath.Base(entry.Path))
+	if err := os.Rename(entry.Path, trashPath); err != nil {
+		if os.IsNotExist(err) {
+			pm.catalog.remove(entry.Path)
+			return nil
+		}
+		return err
+	}
+	pm.catalog.remove(entry.Path)
+	pm.publishEntryEvent(PcapEvicted, entry)
+
+	// A file that is trashed again replaces its previous copy
+	pm.trash[entry.Name] = &trashedPcap{
+		name:      entry.Name,
+		path:      trashPath,
+		cold:      entry.Cold,
+		size:      entry.Size,
+		trashedAt: time.Now(),
+	}
+
+	log.Debug().Str("file", entry.Path).Dur("gracePeriod", pm.gracePeriod).Msg("Moved expired PCAP file to the trash")
+	return nil
+}
+
+// trashedSize returns the size of a trashed PCAP file, 0 if it isn't in the trash
+func (pm *PcapManager) trashedSize(pcapName string) int64 {
+	pm.trashMu.Lock()
+	defer pm.trashMu.Unlock()
+
+	trashed, exists := pm.trash[pcapName]
+	if !exists {
+		return 0
+	}
+
+	return trashed.size
+}
+
+// restorePcap moves a trashed PCAP file back into its tier. It reports whether the file was in the trash.
+func (pm *PcapManager) restorePcap(pcapName string) (bool, error) {
+	pm.trashMu.Lock()
+	defer pm.trashMu.Unlock()
+
+	return pm.restoreLocked(pcapName)
+}
+
+// restoreLocked restores a trashed PCAP file, the caller must hold trashMu
+func (pm *PcapManager) restoreLocked(pcapName string) (bool, error) {
+	trashed, exists := pm.trash[pcapName]
+	if !exists {
+		return false, nil
+	}
+
+	path := filepath.Join(filepath.Dir(filepath.Dir(trashed.path)), filepath.Base(trashed.path))
+	if err := os.Rename(trashed.path, path); err != nil {
+		if os.IsNotExist(err) {
+			delete(pm.trash, pcapName)
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to restore %s from the trash: %w", pcapName, err)
+	}
+	delete(pm.trash, pcapName)
+
+	if _, err := pm.catalog.upsert(path, trashed.cold); err != nil {
+		return true, err
+	}
+
+	log.Debug().Str("file", path).Msg("Restored PCAP file from the trash")
+	return true, nil
+}
+
+// purgeTrash removes the trashed files whose grace period ended. While the PCAP files
+// exceed the storage limit, the oldest trashed files are removed early. Files retained
+// while they were being trashed are restored instead.
+func (pm *PcapManager) purgeTrash(now time.Time) error {
+	pm.trashMu.Lock()
+	trashed := make([]*trashedPcap, 0, len(pm.trash))
+	var trashSize int64
+	for _, t := range pm.trash {
+		trashed = append(trashed, t)
+		trashSize += t.size
+	}
+	pm.trashMu.Unlock()
+
+	sort.Slice(trashed, func(i, j int) bool {
+		return trashed[i].trashedAt.Before(trashed[j].trashedAt)
+	})
+
+	// The retention store may be slow, it is asked without holding the trash
+	retained := make(map[*trashedPcap]bool, len(trashed))
+	for _, t := range trashed {
+		retained[t] = pm.isRetained(t.name)
+	}
+
+	pm.trashMu.Lock()
+	defer pm.trashMu.Unlock()
+
+	usage := pm.catalog.TotalSize() + trashSize + pm.snapshotSize()
+
+	var errs []error
+	for _, t := range trashed {
+		// Files restored or trashed again in the meantime are left to the next purge
+		if pm.trash[t.name] != t {
+			continue
+		}
+
+		if retained[t] {
+			if _, err := pm.restoreLocked(t.name); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		overLimit := pm.storageLimit > 0 && usage > pm.storageLimit
+		if !overLimit && now.Sub(t.trashedAt) < pm.gracePeriod {
+			continue
+		}
+
+		if err := os.Remove(t.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			continue
+		}
+		delete(pm.trash, t.name)
+		pm.publishEntryEvent(PcapDeleted, PcapEntry{Name: t.name, Path: t.path, Size: t.size})
+		pm.forgetRegistration(t.name)
+		usage -= t.size
+		log.Debug().Str("file", t.path).Bool("overLimit", overLimit).Msg("Purged PCAP file from the trash")
+	}
+
+	return errors.Join(errs...)
+}
+
+// trashSize returns the total size of the trashed files
+func (pm *PcapManager) trashSize() int64 {
+	pm.trashMu.Lock()
+	defer pm.trashMu.Unlock()
+
+	var size int64
+	for _, t := range pm.trash {
+		size += t.size
+	}
+
+	return size
+}
@@ -0,0 +1,178 @@
+package worker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGracePeriodRestoresLateRetention(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "late.pcap")
+	if err := os.WriteFile(path, []byte("test data"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	pastTime := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(path, pastTime, pastTime); err != nil {
+		t.Fatalf("Failed to set file time: %v", err)
+	}
+
+	manager := NewPcapManager(tempDir, time.Minute, 1024*1024)
+	if err := manager.SetGracePeriod(time.Hour); err != nil {
+		t.Fatalf("SetGracePeriod failed: %v", err)
+	}
+
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expired PCAP should be moved to the trash")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, trashDirName, "late.pcap")); err != nil {
+		t.Fatalf("Expired PCAP should be kept during the grace period: %v", err)
+	}
+
+	// A retention arriving within the grace period restores the file
+	if err := manager.RetainPcap("late.pcap", time.Hour); err != nil {
+		t.Fatalf("RetainPcap failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Retained PCAP should be restored from the trash: %v", err)
+	}
+	if _, ok := manager.LookupPcap("late"); !ok {
+		t.Errorf("Restored PCAP should be back in the catalog")
+	}
+
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Restored PCAP is retained and should not be trashed again: %v", err)
+	}
+}
+
+func TestRejectedRetentionLeavesFileInTrash(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "big.pcap")
+	if err := os.WriteFile(path, make([]byte, 600), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	pastTime := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(path, pastTime, pastTime); err != nil {
+		t.Fatalf("Failed to set file time: %v", err)
+	}
+
+	manager := NewPcapManager(tempDir, time.Minute, 1024*1024)
+	if err := manager.SetGracePeriod(time.Hour); err != nil {
+		t.Fatalf("SetGracePeriod failed: %v", err)
+	}
+	manager.SetRetentionQuotas(RetentionQuotas{PerScript: RetentionQuota{MaxBytes: 500}})
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+
+	// The trashed size counts, so the retention is rejected before the file is restored
+	err := manager.RetainPcapFor("big.pcap", time.Hour, RetentionOwner{Script: "s1"})
+	if !errors.Is(err, ErrRetentionQuotaExceeded) {
+		t.Fatalf("Expected ErrRetentionQuotaExceeded, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("A rejected retention should not restore the file")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, trashDirName, "big.pcap")); err != nil {
+		t.Errorf("A rejected retention should leave the file in the trash: %v", err)
+	}
+}
+
+func TestTrashPurgeRespectsStorageLimit(t *testing.T) {
+	tempDir := t.TempDir()
+	pastTime := time.Now().Add(-2 * time.Minute)
+	for _, name := range []string{"a.pcap", "b.pcap"} {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		if err := os.Chtimes(path, pastTime, pastTime); err != nil {
+			t.Fatalf("Failed to set file time: %v", err)
+		}
+	}
+
+	// The limit holds a single file, so one trashed file is purged before its grace period ends
+	manager := NewPcapManager(tempDir, time.Minute, 150)
+	if err := manager.SetGracePeriod(time.Hour); err != nil {
+		t.Fatalf("SetGracePeriod failed: %v", err)
+	}
+	if err := manager.CleanupExpiredPcaps(); err != nil {
+		t.Fatalf("CleanupExpiredPcaps failed: %v", err)
+	}
+
+	files, err := os.ReadDir(filepath.Join(tempDir, trashDirName))
+	if err != nil {
+		t.Fatalf("Failed to read trash: %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected 1 file left in the trash, got %d", len(files))
+	}
+
+	usage, _ := manager.GetStorageUsage()
+	if usage != 100 {
+		t.Errorf("Expected storage usage of 100 bytes, got %d", usage)
+	}
+}
+
+// blockingStore is a retention store whose Get waits for unblock
+type blockingStore struct {
+	*MemoryRetentionStore
+	calls   chan struct{}
+	unblock chan struct{}
+}
+
+func (s *blockingStore) Get(name string) (Retention, bool, error) {
+	select {
+	case s.calls <- struct{}{}:
+	default:
+	}
+	<-s.unblock
+	return s.MemoryRetentionStore.Get(name)
+}
+
+func TestTrashPurgeDoesNotHoldTrashOnStore(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "slow.pcap")
+	if err := os.WriteFile(path, []byte("test data"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	store := &blockingStore{MemoryRetentionStore: NewMemoryRetentionStore(), calls: make(chan struct{}, 1), unblock: make(chan struct{})}
+	manager := NewPcapManagerWithStore(tempDir, time.Minute, 1024*1024, store)
+	if err := manager.SetGracePeriod(time.Hour); err != nil {
+		t.Fatalf("SetGracePeriod failed: %v", err)
+	}
+	entry, _ := manager.catalog.Get("slow.pcap")
+	if err := manager.deletePcap(entry); err != nil {
+		t.Fatalf("Failed to trash the file: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- manager.purgeTrash(time.Now()) }()
+	<-store.calls
+
+	// The trash stays usable while the store answers
+	sized := make(chan int64, 1)
+	go func() { sized <- manager.trashedSize("slow.pcap") }()
+	select {
+	case size := <-sized:
+		if size != 9 {
+			t.Errorf("Expected the trashed size of 9 bytes, got %d", size)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the trash not to be locked during the store call")
+	}
+
+	close(store.unblock)
+	if err := <-done; err != nil {
+		t.Errorf("purgeTrash failed: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PcapUsage is the amount of PCAP data attributed to a namespace, pod or protocol
+type PcapUsage struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// add accounts a file of the given size to usage
+func (u PcapUsage) add(size int64) PcapUsage {
+	u.Files++
+	u.Bytes += size
+	return u
+}
+
+// StorageBreakdown attributes the PCAP storage, both tiers and the trash, to the namespaces,
+// pods and protocols of the registered streams. Pods are keyed by namespace/pod.
+// Files that were never registered are counted as unattributed, and snapshots apart.
+type StorageBreakdown struct {
+	Total        int64                `json:"total"`
+	Limit        int64                `json:"limit"`
+	ByNamespace  map[string]PcapUsage `json:"byNamespace"`
+	ByPod        map[string]PcapUsage `json:"byPod"`
+	ByProtocol   map[string]PcapUsage `json:"byProtocol"`
+	Unattributed PcapUsage            `json:"unattributed"`
+	Snapshots    PcapUsage            `json:"snapshots"`
+}
+
+// GetStorageBreakdown returns the storage usage broken down by namespace, pod and protocol
+func (pm *PcapManager) GetStorageBreakdown() StorageBreakdown {
+	breakdown := StorageBreakdown{
+		Limit:       pm.storageLimit,
+		ByNamespace: make(map[string]PcapUsage),
+		ByPod:       make(map[string]PcapUsage),
+		ByProtocol:  make(map[string]PcapUsage),
+	}
+
+	sizes := make(map[string]int64)
+	for _, entry := range pm.catalog.List() {
+		sizes[entry.Name] += entry.Size
+	}
+	pm.trashMu.Lock()
+	for name, t := range pm.trash {
+		sizes[name] += t.size
+	}
+	pm.trashMu.Unlock()
+
+	pm.snapshotMu.Lock()
+	for _, size := range pm.snapshots {
+		breakdown.Snapshots = breakdown.Snapshots.add(size)
+	}
+	pm.snapshotMu.Unlock()
+	breakdown.Total += breakdown.Snapshots.Bytes
+
+	pm.classMu.Lock()
+	defer pm.classMu.Unlock()
+
+	for name, size := range sizes {
+		breakdown.Total += size
+
+		registration, exists := pm.registered[name]
+		if !exists {
+			breakdown.Unattributed = breakdown.Unattributed.add(size)
+			continue
+		}
+
+		meta := registration.meta
+		if meta.Namespace != "" {
+			breakdown.ByNamespace[meta.Namespace] = breakdown.ByNamespace[meta.Namespace].add(size)
+			if meta.Pod != "" {
+				pod := meta.Namespace + "/" + meta.Pod
+				breakdown.ByPod[pod] = breakdown.ByPod[pod].add(size)
+			}
+		}
+		if meta.Protocol != "" {
+			protocol := strings.ToLower(meta.Protocol)
+			breakdown.ByProtocol[protocol] = breakdown.ByProtocol[protocol].add(size)
+		}
+	}
+
+	return breakdown
+}
+
+// WriteStorageMetrics writes the storage breakdown in the Prometheus text exposition format
+func (pm *PcapManager) WriteStorageMetrics(w io.Writer) error {
+	breakdown := pm.GetStorageBreakdown()
+	out := bufio.NewWriter(w)
+
+	writeMetricHeader(out, "kubeshark_pcap_storage_bytes", "Bytes used by PCAP files, trash and snapshots included.")
+	fmt.Fprintf(out, "kubeshark_pcap_storage_bytes %d\n", breakdown.Total)
+	writeMetricHeader(out, "kubeshark_pcap_storage_limit_bytes", "Storage limit for PCAP files.")
+	fmt.Fprintf(out, "kubeshark_pcap_storage_limit_bytes %d\n", breakdown.Limit)
+	writeMetricHeader(out, "kubeshark_pcap_unattributed_bytes", "Bytes used by PCAP files of unregistered streams.")
+	fmt.Fprintf(out, "kubeshark_pcap_unattributed_bytes %d\n", breakdown.Unattributed.Bytes)
+	writeMetricHeader(out, "kubeshark_pcap_snapshot_bytes", "Bytes used by PCAP snapshots.")
+	fmt.Fprintf(out, "kubeshark_pcap_snapshot_bytes %d\n", breakdown.Snapshots.Bytes)
+
+	writeMetricHeader(out, "kubeshark_pcap_namespace_bytes", "Bytes used by PCAP files per namespace.")
+	for _, namespace := range sortedKeys(breakdown.ByNamespace) {
+		fmt.Fprintf(out, "kubeshark_pcap_namespace_bytes{namespace=\"%s\"} %d\n", escapeLabel(namespace), breakdown.ByNamespace[namespace].Bytes)
+	}
+
+	writeMetricHeader(out, "kubeshark_pcap_pod_bytes", "Bytes used by PCAP files per pod.")
+	for _, key := range sortedKeys(breakdown.ByPod) {
+		namespace, pod, _ := strings.Cut(key, "/")
+		fmt.Fprintf(out, "kubeshark_pcap_pod_bytes{namespace=\"%s\",pod=\"%s\"} %d\n", escapeLabel(namespace), escapeLabel(pod), breakdown.ByPod[key].Bytes)
+	}
+
+	writeMetricHeader(out, "kubeshark_pcap_protocol_bytes", "Bytes used by PCAP files per protocol.")
+	for _, protocol := range sortedKeys(breakdown.ByProtocol) {
+		fmt.Fprintf(out, "kubeshark_pcap_protocol_bytes{protocol=\"%s\"} %d\n", escapeLabel(protocol), breakdown.ByProtocol[protocol].Bytes)
+	}
+
+	return out.Flush()
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a gauge
+func writeMetricHeader(w io.Writer, name string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// sortedKeys returns the keys of a usage map in order, for a stable output
+func sortedKeys(usage map[string]PcapUsage) []string {
+	keys := make([]string, 0, len(usage))
+	for key := range usage {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
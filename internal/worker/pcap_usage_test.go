@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorageBreakdown(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]int{"a.pcap": 100, "b.pcap": 200, "c.pcap": 400, "d.pcap": 800}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+	manager.RegisterPcap("a.pcap", PcapMeta{Namespace: "shop", Pod: "cart-1", Protocol: "HTTP"})
+	manager.RegisterPcap("b.pcap", PcapMeta{Namespace: "shop", Pod: "cart-2", Protocol: "http"})
+	manager.RegisterPcap("c.pcap", PcapMeta{Namespace: "kube-system", Pod: "coredns", Protocol: "dns"})
+
+	breakdown := manager.GetStorageBreakdown()
+	if breakdown.Total != 1500 {
+		t.Errorf("Expected total of 1500 bytes, got %d", breakdown.Total)
+	}
+	if usage := breakdown.ByNamespace["shop"]; usage.Bytes != 300 || usage.Files != 2 {
+		t.Errorf("Unexpected usage for namespace shop: %+v", usage)
+	}
+	if usage := breakdown.ByPod["shop/cart-2"]; usage.Bytes != 200 {
+		t.Errorf("Unexpected usage for pod shop/cart-2: %+v", usage)
+	}
+	if usage := breakdown.ByProtocol["http"]; usage.Bytes != 300 {
+		t.Errorf("Protocols should be grouped case-insensitively, got %+v", usage)
+	}
+	if breakdown.Unattributed.Bytes != 800 {
+		t.Errorf("Expected 800 unattributed bytes, got %d", breakdown.Unattributed.Bytes)
+	}
+
+	var metrics strings.Builder
+	if err := manager.WriteStorageMetrics(&metrics); err != nil {
+		t.Fatalf("WriteStorageMetrics failed: %v", err)
+	}
+	for _, line := range []string{
+		"kubeshark_pcap_storage_bytes 1500",
+		`kubeshark_pcap_namespace_bytes{namespace="kube-system"} 400`,
+		`kubeshark_pcap_pod_bytes{namespace="shop",pod="cart-1"} 100`,
+	} {
+		if !strings.Contains(metrics.String(), line+"\n") {
+			t.Errorf("Metrics should contain %q", line)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// quarantineDirName is the directory, inside the PCAP directory, that unrepairable files are moved to
+const quarantineDirName = ".quarantine"
+
+// ErrPcapUnrepairable is returned when a PCAP file has no valid header and can't be repaired
+var ErrPcapUnrepairable = errors.New("pcap file can't be repaired")
+
+// PcapVerification is the outcome of verifying a single PCAP file
+type PcapVerification struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	ValidSize int64  `json:"validSize"`
+	Records   int    `json:"records"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Intact reports whether the whole file consists of complete records
+func (v PcapVerification) Intact() bool {
+	return v.ValidSize == v.Size
+}
+
+// PcapIntegrityReport lists the PCAP files repaired or quarantined by VerifyPcaps
+type PcapIntegrityReport struct {
+	Checked     int                `json:"checked"`
+	Repaired    []PcapVerification `json:"repaired"`
+	Quarantined []PcapVerification `json:"quarantined"`
+}
+
+// VerifyPcap validates the header and record boundaries of a classic PCAP file without
+// modifying it. ValidSize is the offset right after the last complete record. A file
+// without a valid header is reported with ErrPcapUnrepairable.
+func VerifyPcap(path string) (PcapVerification, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return PcapVerification{Path: path}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return PcapVerification{Path: path}, err
+	}
+
+	v := PcapVerification{Path: path, Size: info.Size()}
+
+	reader := bufio.NewReader(file)
+	header, err := readPcapHeader(reader)
+	if err != nil {
+		v.Reason = err.Error()
+		return v, fmt.Errorf("%w: %s: %v", ErrPcapUnrepairable, path, err)
+	}
+	v.ValidSize = pcapGlobalHeaderLen
+
+	buf := make([]byte, pcapRecordHeaderLen)
+	for {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				v.Reason = fmt.Sprintf("truncated record header at offset %d", v.ValidSize)
+			} else if !errors.Is(err, io.EOF) {
+				return v, err
+			}
+			return v, nil
+		}
+
+		record := header.parseRecord(buf)
+		if !header.validRecord(record) {
+			v.Reason = fmt.Sprintf("invalid record at offset %d", v.ValidSize)
+			return v, nil
+		}
+
+		if _, err := reader.Discard(int(record.inclLen)); err != nil {
+			if errors.Is(err, io.EOF) {
+				v.Reason = fmt.Sprintf("truncated record data at offset %d", v.ValidSize)
+				return v, nil
+			}
+			return v, err
+		}
+
+		v.ValidSize += pcapRecordHeaderLen + int64(record.inclLen)
+		v.Records++
+	}
+}
+
+// RepairPcap verifies a PCAP file and truncates it at the last complete record.
+// Files without a valid header are left untouched and reported with ErrPcapUnrepairable.
+func RepairPcap(path string) (PcapVerification, error) {
+	v, err := VerifyPcap(path)
+	if err != nil || v.Intact() {
+		return v, err
+	}
+
+	if err := os.Truncate(path, v.ValidSize); err != nil {
+		return v, fmt.Errorf("failed to truncate %s: %w", path, err)
+	}
+
+	return v, nil
+}
+
+// VerifyPcaps repairs the warm PCAP files left truncated by a crash, and moves the
+// ones that can't be repaired to a quarantine directory inside the PCAP directory.
+// It must run before the capture resumes, as files being written look truncated too.
+func (pm *PcapManager) VerifyPcaps() (PcapIntegrityReport, error) {
+	var report PcapIntegrityReport
+	var errs []error
+
+	for _, entry := range pm.catalog.List() {
+		if entry.Cold {
+			continue
+		}
+		report.Checked++
+
+		v, err := RepairPcap(entry.Path)
+		if errors.Is(err, ErrPcapUnrepairable) {
+			if err := pm.quarantinePcap(entry); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			report.Quarantined = append(report.Quarantined, v)
+			log.Warn().Str("file", entry.Path).Str("reason", v.Reason).Msg("Quarantined unrepairable PCAP file")
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if v.Intact() {
+			continue
+		}
+
+		if _, err := pm.catalog.upsert(entry.Path, false); err != nil {
+			errs = append(errs, err)
+		}
+		report.Repaired = append(report.Repaired, v)
+		log.Warn().Str("file", entry.Path).Str("reason", v.Reason).Int64("truncatedBytes", v.Size-v.ValidSize).Msg("Repaired truncated PCAP file")
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// quarantinePcap moves a PCAP file out of the catalog into the quarantine directory
+func (pm *PcapManager) quarantinePcap(entry PcapEntry) error {
+	quarantineDir := filepath.Join(pm.pcapDir, quarantineDirName)
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory %s: %w", quarantineDir, err)
+	}
+
+	if err := os.Rename(entry.Path, filepath.Join(quarantineDir, entry.Name)); err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", entry.Path, err)
+	}
+
+	pm.catalog.remove(entry.Path)
+	return nil
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRepairPcapTruncatesPartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "partial.pcap")
+	now := time.Now()
+	writeTestPcap(t, path, now, now.Add(time.Second))
+
+	info, _ := os.Stat(path)
+	complete := info.Size()
+
+	// Simulate a crash in the middle of writing a third record
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open test pcap: %v", err)
+	}
+	file.Write(make([]byte, 10))
+	file.Close()
+
+	v, err := RepairPcap(path)
+	if err != nil {
+		t.Fatalf("RepairPcap failed: %v", err)
+	}
+	if v.Records != 2 || v.ValidSize != complete || v.Size != complete+10 {
+		t.Errorf("Unexpected verification: %+v", v)
+	}
+
+	info, _ = os.Stat(path)
+	if info.Size() != complete {
+		t.Errorf("Expected the file to be truncated to %d bytes, got %d", complete, info.Size())
+	}
+
+	v, err = VerifyPcap(path)
+	if err != nil || !v.Intact() {
+		t.Errorf("Repaired file should be intact, got %+v, %v", v, err)
+	}
+}
+
+func TestVerifyPcapsQuarantinesBrokenHeaders(t *testing.T) {
+	tempDir := t.TempDir()
+	now := time.Now()
+	writeTestPcap(t, filepath.Join(tempDir, "good.pcap"), now)
+	writeTestPcap(t, filepath.Join(tempDir, "partial.pcap"), now)
+	if err := os.WriteFile(filepath.Join(tempDir, "broken.pcap"), []byte("not a pcap"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	file, _ := os.OpenFile(filepath.Join(tempDir, "partial.pcap"), os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write(make([]byte, pcapRecordHeaderLen+5))
+	file.Close()
+
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+	report, err := manager.VerifyPcaps()
+	if err != nil {
+		t.Fatalf("VerifyPcaps failed: %v", err)
+	}
+
+	if report.Checked != 3 || len(report.Repaired) != 1 || len(report.Quarantined) != 1 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, quarantineDirName, "broken.pcap")); err != nil {
+		t.Errorf("Broken PCAP should be quarantined: %v", err)
+	}
+	if _, ok := manager.LookupPcap("broken"); ok {
+		t.Errorf("Quarantined PCAP should be dropped from the catalog")
+	}
+
+	if _, err := VerifyPcap(filepath.Join(tempDir, quarantineDirName, "broken.pcap")); !errors.Is(err, ErrPcapUnrepairable) {
+		t.Errorf("Expected ErrPcapUnrepairable, got %v", err)
+	}
+}
@@ -0,0 +1,429 @@
+package worker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubeshark/gopacket"
+	"github.com/kubeshark/gopacket/layers"
+	"github.com/kubeshark/gopacket/pcapgo"
+	"github.com/rs/zerolog/log"
+)
+
+// segmentTimeFormat is the UTC timestamp in segment names, <prefix>-YYYYMMDD-HHMMSS.pcap,
+// which is what the CLI's pcapdump command parses to filter files by time
+const segmentTimeFormat = "20060102-150405"
+
+// RotatingWriterConfig configures a RotatingWriter. Zero limits disable the matching rotation or cleanup.
+type RotatingWriterConfig struct {
+	Dir      string
+	Prefix   string
+	Interval time.Duration
+	MaxTime  time.Duration
+	MaxSize  int64
+	Snaplen  uint32
+	LinkType layers.LinkType
+	Meta     PcapMeta
+}
+
+// ParseRotatingWriterConfig builds a config from the pcapdump timeInterval, maxTime and maxSize settings
+func ParseRotatingWriterConfig(timeInterval string, maxTime string, maxSize string) (RotatingWriterConfig, error) {
+	var config RotatingWriterConfig
+	var err error
+
+	if config.Interval, err = time.ParseDuration(timeInterval); err != nil {
+		return config, fmt.Errorf("invalid pcapdump time interval %q: %w", timeInterval, err)
+	}
+	if config.MaxTime, err = time.ParseDuration(maxTime); err != nil {
+		return config, fmt.Errorf("invalid pcapdump max time %q: %w", maxTime, err)
+	}
+	if config.MaxSize, err = ParseSize(maxSize); err != nil {
+		return config, fmt.Errorf("invalid pcapdump max size: %w", err)
+	}
+
+	return config, nil
+}
+
+// RotatingWriter writes packets into PCAP segments. Each segment is written under a
+// hidden temporary name, then synced and atomically renamed when it is completed, so
+// readers never see a partial segment. Completed segments are registered with the
+// PCAP manager, and the oldest ones are deleted once MaxTime or MaxSize is exceeded.
+// A segment is completed once its interval passed, even if no more packets arrive.
+type RotatingWriter struct {
+	config      RotatingWriterConfig
+	pcapManager *PcapManager
+
+	file         *os.File
+	buffer       *bufio.Writer
+	writer       *pcapgo.Writer
+	segmentName  string
+	segmentStart time.Time
+	// timer completes the current segment at the end of its interval
+	timer    *time.Timer
+	segments []segment
+	mu       sync.Mutex
+}
+
+// segment is a completed segment written by the RotatingWriter
+type segment struct {
+	name  string
+	start time.Time
+	size  int64
+}
+
+// NewRotatingWriter creates a rotating writer. Segments left unfinished by a crash
+// are repaired and completed, and existing segments count toward the limits.
+func NewRotatingWriter(config RotatingWriterConfig, pcapManager *PcapManager) (*RotatingWriter, error) {
+	if config.Dir == "" {
+		config.Dir = pcapManager.GetPcapDir()
+	}
+	if config.Prefix == "" {
+		config.Prefix = "kubeshark"
+	}
+	if config.Snaplen == 0 {
+		config.Snaplen = 262144
+	}
+	if config.LinkType == 0 {
+		config.LinkType = layers.LinkTypeEthernet
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create segment directory %s: %w", config.Dir, err)
+	}
+
+	w := &RotatingWriter{config: config, pcapManager: pcapManager}
+	if err := w.recoverSegments(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// WritePacket writes a packet to the current segment, rotating it first if its interval has passed
+func (w *RotatingWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if w.file != nil && w.config.Interval > 0 && now.Sub(w.segmentStart) >= w.config.Interval {
+		if err := w.finishSegment(); err != nil {
+			return err
+		}
+	}
+
+	if w.file == nil {
+		if err := w.startSegment(now); err != nil {
+			return err
+		}
+	}
+
+	return w.writer.WritePacket(ci, data)
+}
+
+// Rotate completes the current segment, if any. The next packet starts a new one.
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	return w.finishSegment()
+}
+
+// Close completes the current segment and stops its timer
+func (w *RotatingWriter) Close() error {
+	return w.Rotate()
+}
+
+// startSegment opens a new segment under its temporary name
+func (w *RotatingWriter) startSegment(now time.Time) error {
+	name := w.segmentFileName(now)
+
+	file, err := os.OpenFile(w.tmpPath(name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create segment %s: %w", name, err)
+	}
+
+	buffer := bufio.NewWriterSize(file, 1024*1024)
+	writer := pcapgo.NewWriter(buffer)
+	if err := writer.WriteFileHeader(w.config.Snaplen, w.config.LinkType); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to write segment header: %w", err)
+	}
+
+	w.file = file
+	w.buffer = buffer
+	w.writer = writer
+	w.segmentName = name
+	w.segmentStart = now
+	if w.config.Interval > 0 {
+		w.timer = time.AfterFunc(w.config.Interval, func() { w.expireSegment(file) })
+	}
+	return nil
+}
+
+// expireSegment completes the segment written to file at the end of its interval, unless
+// it was completed already
+func (w *RotatingWriter) expireSegment(file *os.File) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != file {
+		return
+	}
+	if err := w.finishSegment(); err != nil {
+		log.Error().Err(err).Str("segment", w.segmentName).Msg("Failed to complete expired PCAP segment")
+	}
+}
+
+// finishSegment flushes, syncs and renames the current segment, then enforces the limits
+func (w *RotatingWriter) finishSegment() error {
+	file, buffer := w.file, w.buffer
+	w.file, w.buffer, w.writer = nil, nil, nil
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+
+	err := errors.Join(flushAndSync(file, buffer), file.Close())
+	if err != nil {
+		return fmt.Errorf("failed to complete segment %s: %w", w.segmentName, err)
+	}
+
+	if err := w.completeSegment(w.segmentName, w.segmentStart); err != nil {
+		return err
+	}
+
+	w.enforceLimits(time.Now())
+	return nil
+}
+
+// flushAndSync writes the buffered packets of a segment to disk
+func flushAndSync(file *os.File, buffer *bufio.Writer) error {
+	if err := buffer.Flush(); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// completeSegment renames a synced temporary segment to its final name and registers it
+func (w *RotatingWriter) completeSegment(name string, start time.Time) error {
+	// The name may be one the manager freed, the segment that held it is dropped before it's reused
+	w.refreshSegments()
+
+	path := filepath.Join(w.config.Dir, name)
+	if err := os.Rename(w.tmpPath(name), path); err != nil {
+		return fmt.Errorf("failed to complete segment %s: %w", name, err)
+	}
+	syncDir(w.config.Dir)
+
+	entry, err := w.pcapManager.catalog.upsert(path, false)
+	if err != nil {
+		return fmt.Errorf("failed to index segment %s: %w", name, err)
+	}
+	w.pcapManager.RegisterPcap(name, w.config.Meta)
+
+	w.segments = append(w.segments, segment{name: name, start: start, size: entry.Size})
+	log.Debug().Str("segment", path).Int64("size", entry.Size).Msg("Completed PCAP segment")
+	return nil
+}
+
+// enforceLimits deletes the oldest segments past MaxTime, or while the segments exceed MaxSize.
+// Retained segments are kept.
+func (w *RotatingWriter) enforceLimits(now time.Time) {
+	w.refreshSegments()
+
+	var total int64
+	for _, s := range w.segments {
+		total += s.size
+	}
+
+	kept := w.segments[:0]
+	for _, s := range w.segments {
+		tooOld := w.config.MaxTime > 0 && now.Sub(s.start) > w.config.MaxTime
+		tooBig := w.config.MaxSize > 0 && total > w.config.MaxSize
+		if (!tooOld && !tooBig) || w.pcapManager.isRetained(s.name) {
+			kept = append(kept, s)
+			continue
+		}
+
+		path := filepath.Join(w.config.Dir, s.name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Str("segment", path).Msg("Failed to delete PCAP segment")
+			kept = append(kept, s)
+			continue
+		}
+		w.pcapManager.catalog.remove(path)
+		w.pcapManager.publishEntryEvent(PcapDeleted, PcapEntry{Name: s.name, Path: path, Size: s.size})
+		w.pcapManager.forgetRegistration(s.name)
+		total -= s.size
+		log.Debug().Str("segment", path).Bool("maxTime", tooOld).Bool("maxSize", tooBig).Msg("Deleted PCAP segment")
+	}
+
+	w.segments = kept
+}
+
+// refreshSegments re-reads the segments from the catalog. Segments the PCAP manager deleted,
+// trashed or moved to the cold tier are dropped, they are no longer the writer's to delete.
+func (w *RotatingWriter) refreshSegments() {
+	kept := w.segments[:0]
+	for _, s := range w.segments {
+		entry, exists := w.pcapManager.catalog.entryAt(filepath.Join(w.config.Dir, s.name))
+		if !exists {
+			continue
+		}
+		s.size = entry.Size
+		kept = append(kept, s)
+	}
+
+	w.segments = kept
+}
+
+// recoverSegments completes the segments left behind by a crash and loads the existing ones
+func (w *RotatingWriter) recoverSegments() error {
+	files, err := os.ReadDir(w.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		name := file.Name()
+		if strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp") {
+			name = strings.TrimSuffix(strings.TrimPrefix(name, "."), ".tmp")
+			start, ok := w.parseSegmentName(name)
+			if !ok {
+				continue
+			}
+			if _, err := RepairPcap(w.tmpPath(name)); err != nil {
+				log.Warn().Err(err).Str("segment", name).Msg("Discarding unrecoverable PCAP segment")
+				os.Remove(w.tmpPath(name))
+				continue
+			}
+			if err := w.completeSegment(name, start); err != nil {
+				return err
+			}
+			log.Info().Str("segment", name).Msg("Recovered unfinished PCAP segment")
+			continue
+		}
+
+		start, ok := w.parseSegmentName(name)
+		if !ok {
+			continue
+		}
+		entry, err := w.pcapManager.catalog.upsert(filepath.Join(w.config.Dir, name), false)
+		if err != nil {
+			continue
+		}
+		w.segments = append(w.segments, segment{name: name, start: start, size: entry.Size})
+	}
+
+	sort.Slice(w.segments, func(i, j int) bool {
+		return w.segments[i].start.Before(w.segments[j].start)
+	})
+
+	w.enforceLimits(time.Now())
+	return nil
+}
+
+// segmentFileName returns an unused segment name for a segment started at t
+func (w *RotatingWriter) segmentFileName(t time.Time) string {
+	base := fmt.Sprintf("%s-%s", w.config.Prefix, t.UTC().Format(segmentTimeFormat))
+
+	name := base + ".pcap"
+	for i := 1; w.segmentExists(name); i++ {
+		name = fmt.Sprintf("%s_%d.pcap", base, i)
+	}
+
+	return name
+}
+
+// segmentExists reports whether a segment name is taken, completed or not
+func (w *RotatingWriter) segmentExists(name string) bool {
+	for _, path := range []string{filepath.Join(w.config.Dir, name), w.tmpPath(name)} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseSegmentName returns the start time of a segment written with this writer's prefix
+func (w *RotatingWriter) parseSegmentName(name string) (time.Time, bool) {
+	rest, found := strings.CutPrefix(name, w.config.Prefix+"-")
+	if !found || !strings.HasSuffix(rest, ".pcap") || len(rest) < len(segmentTimeFormat) {
+		return time.Time{}, false
+	}
+
+	start, err := time.Parse(segmentTimeFormat, rest[:len(segmentTimeFormat)])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return start, true
+}
+
+// tmpPath returns the hidden path a segment is written to until it is completed
+func (w *RotatingWriter) tmpPath(name string) string {
+	return filepath.Join(w.config.Dir, "."+name+".tmp")
+}
+
+// syncDir syncs a directory so a rename in it survives a crash
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		log.Debug().Err(err).Str("dir", dir).Msg("Failed to sync directory")
+	}
+}
+
+// sizeUnits maps size suffixes to their multipliers
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseSize parses a size such as 500MB or 2GiB, as used by the pcapdump maxSize setting.
+// A plain number is a number of bytes.
+func ParseSize(value string) (int64, error) {
+	number := strings.TrimSpace(value)
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(number), strings.ToUpper(unit.suffix)) {
+			number = strings.TrimSpace(number[:len(number)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	parsed, err := strconv.ParseFloat(number, 64)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	return int64(parsed * float64(multiplier)), nil
+}
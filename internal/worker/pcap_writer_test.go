@@ -0,0 +1,179 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeshark/gopacket"
+)
+
+func TestRotatingWriterSegments(t *testing.T) {
+	tempDir := t.TempDir()
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+
+	writer, err := NewRotatingWriter(RotatingWriterConfig{Prefix: "node-1", MaxSize: 250}, manager)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter failed: %v", err)
+	}
+
+	data := make([]byte, 60)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	for i := 0; i < 3; i++ {
+		if err := writer.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+
+		// The segment in progress is hidden from the directory listing
+		if _, ok := manager.LookupPcap(writer.segmentName); ok {
+			t.Errorf("Unfinished segment should not be registered")
+		}
+		if err := writer.Rotate(); err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+	}
+
+	// Each segment is 24+16+60 bytes, so MaxSize keeps the two newest
+	entries, _ := manager.ListPcaps()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 segments, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name, "node-1-") {
+			t.Errorf("Unexpected segment name %s", entry.Name)
+		}
+		if v, err := VerifyPcap(entry.Path); err != nil || !v.Intact() || v.Records != 1 {
+			t.Errorf("Segment %s should hold one complete packet: %+v, %v", entry.Name, v, err)
+		}
+
+		// Segment names carry the UTC start time the CLI filters on
+		parts := strings.Split(entry.Name, "-")
+		if _, err := time.Parse("20060102150405", parts[len(parts)-2]+parts[len(parts)-1][:6]); err != nil {
+			t.Errorf("Segment name %s is not parseable by the CLI: %v", entry.Name, err)
+		}
+	}
+}
+
+func TestRotatingWriterCompletesIdleSegment(t *testing.T) {
+	tempDir := t.TempDir()
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+
+	writer, err := NewRotatingWriter(RotatingWriterConfig{Prefix: "idle", Interval: 50 * time.Millisecond}, manager)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter failed: %v", err)
+	}
+	defer writer.Close()
+
+	data := make([]byte, 60)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	if err := writer.WritePacket(ci, data); err != nil {
+		t.Fatalf("WritePacket failed: %v", err)
+	}
+
+	// The segment is completed at the end of its interval without another packet
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if entries, _ := manager.ListPcaps(); len(entries) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the idle segment to be completed after its interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRotatingWriterRecoversCrashedSegment(t *testing.T) {
+	tempDir := t.TempDir()
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+
+	// Leave a segment with a partially written packet behind, as a crash would
+	name := "node-1-20240101-120000.pcap"
+	tmpPath := filepath.Join(tempDir, "."+name+".tmp")
+	writeTestPcap(t, tmpPath, time.Now())
+	file, _ := os.OpenFile(tmpPath, os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write(make([]byte, 7))
+	file.Close()
+
+	if _, err := NewRotatingWriter(RotatingWriterConfig{Prefix: "node-1"}, manager); err != nil {
+		t.Fatalf("NewRotatingWriter failed: %v", err)
+	}
+
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("Temporary segment should be completed")
+	}
+	entry, ok := manager.LookupPcap(strings.TrimSuffix(name, ".pcap"))
+	if !ok {
+		t.Fatalf("Recovered segment should be registered")
+	}
+	if v, err := VerifyPcap(entry.Path); err != nil || !v.Intact() {
+		t.Errorf("Recovered segment should be repaired: %+v, %v", v, err)
+	}
+}
+
+func TestRotatingWriterForgetsRemovedSegments(t *testing.T) {
+	tempDir := t.TempDir()
+	manager := NewPcapManager(tempDir, time.Hour, 1024*1024)
+
+	writer, err := NewRotatingWriter(RotatingWriterConfig{Prefix: "node-1", MaxSize: 250}, manager)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter failed: %v", err)
+	}
+	sub := manager.Subscribe(0)
+	defer sub.Close()
+
+	data := make([]byte, 60)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	write := func() {
+		t.Helper()
+		if err := writer.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+		if err := writer.Rotate(); err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+	}
+	write()
+	write()
+
+	// The manager deletes the oldest segment, as cleanup would
+	entries, _ := manager.ListPcaps()
+	if err := manager.deletePcap(entries[0]); err != nil {
+		t.Fatalf("deletePcap failed: %v", err)
+	}
+
+	// The deleted segment neither counts toward MaxSize nor is deleted again
+	write()
+	if remaining, _ := manager.ListPcaps(); len(remaining) != 2 {
+		t.Errorf("Expected the 2 newest segments to be kept, got %d", len(remaining))
+	}
+	deleted := 0
+	for len(sub.C) > 0 {
+		if event := <-sub.C; event.Type == PcapDeleted {
+			deleted++
+		}
+	}
+	if deleted != 1 {
+		t.Errorf("Expected a single PcapDeleted event, got %d", deleted)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"500MB": 500 * 1000 * 1000, "2GiB": 2 << 30, "1024": 1024, "10kb": 10000}
+	for value, expected := range tests {
+		if size, err := ParseSize(value); err != nil || size != expected {
+			t.Errorf("ParseSize(%q) = %d, %v, expected %d", value, size, err, expected)
+		}
+	}
+
+	if _, err := ParseSize("lots"); err == nil {
+		t.Errorf("Expected an error for an invalid size")
+	}
+
+	config, err := ParseRotatingWriterConfig("1m", "1h", "500MB")
+	if err != nil || config.Interval != time.Minute || config.MaxTime != time.Hour || config.MaxSize != 500*1000*1000 {
+		t.Errorf("Unexpected config from the pcapdump defaults: %+v, %v", config, err)
+	}
+}
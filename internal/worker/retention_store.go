@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Retention describes a PCAP file that must be kept beyond its TTL
+type Retention struct {
+	Name      string    `json:"name"`
+	Until     time.Time `json:"until"`
+	Script    string    `json:"script,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Class     string    `json:"class,omitempty"`
+}
+
+// Active reports whether the retention is still in effect at the given time
+func (r Retention) Active(now time.Time) bool {
+	return now.Before(r.Until)
+}
+
+// RetentionStore is the single source of truth for PCAP retentions.
+// PcapManager, PcapRetention and the scripting helpers all delegate to it,
+// so they can never disagree about whether a file is retained.
+type RetentionStore interface {
+	// Put creates or replaces the retention for r.Name
+	Put(r Retention) error
+
+	// Get returns the retention for name if it is still active
+	Get(name string) (Retention, bool, error)
+
+	// Delete releases the retention for name, if any
+	Delete(name string) error
+
+	// List returns all active retentions ordered by name
+	List() ([]Retention, error)
+
+	// PurgeExpired removes and returns the retentions that are no longer active at now
+	PurgeExpired(now time.Time) ([]Retention, error)
+}
+
+// retentionEntries is the in-memory representation shared by all RetentionStore implementations
+type retentionEntries map[string]Retention
+
+// active returns the active entries ordered by name
+func (e retentionEntries) active(now time.Time) []Retention {
+	retentions := make([]Retention, 0, len(e))
+	for _, r := range e {
+		if r.Active(now) {
+			retentions = append(retentions, r)
+		}
+	}
+
+	sort.Slice(retentions, func(i, j int) bool {
+		return retentions[i].Name < retentions[j].Name
+	})
+
+	return retentions
+}
+
+// purge removes the inactive entries and returns them ordered by name
+func (e retentionEntries) purge(now time.Time) []Retention {
+	var expired []Retention
+	for name, r := range e {
+		if !r.Active(now) {
+			expired = append(expired, r)
+			delete(e, name)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].Name < expired[j].Name
+	})
+
+	return expired
+}
+
+// MemoryRetentionStore keeps retentions in process memory
+type MemoryRetentionStore struct {
+	entries retentionEntries
+	mu      sync.Mutex
+}
+
+// NewMemoryRetentionStore creates an empty in-memory retention store
+func NewMemoryRetentionStore() *MemoryRetentionStore {
+	return &MemoryRetentionStore{
+		entries: make(retentionEntries),
+	}
+}
+
+// Put creates or replaces the retention for r.Name
+func (s *MemoryRetentionStore) Put(r Retention) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[r.Name] = r
+	return nil
+}
+
+// Get returns the retention for name if it is still active
+func (s *MemoryRetentionStore) Get(name string) (Retention, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired entries are left for PurgeExpired to report
+	r, exists := s.entries[name]
+	if !exists || !r.Active(time.Now()) {
+		return Retention{}, false, nil
+	}
+
+	return r, true, nil
+}
+
+// Delete releases the retention for name, if any
+func (s *MemoryRetentionStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, name)
+	return nil
+}
+
+// List returns all active retentions ordered by name
+func (s *MemoryRetentionStore) List() ([]Retention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.entries.active(time.Now()), nil
+}
+
+// PurgeExpired removes and returns the retentions that are no longer active at now
+func (s *MemoryRetentionStore) PurgeExpired(now time.Time) ([]Retention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.entries.purge(now), nil
+}
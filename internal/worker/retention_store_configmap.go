@@ -0,0 +1,211 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CONFIG_PCAP_RETENTIONS is the ConfigMap key that holds the JSON encoded retentions
+	CONFIG_PCAP_RETENTIONS = "PCAP_RETENTIONS"
+
+	configMapRetentionMaxRetries = 5
+
+	// configMapRetentionCacheTTL is how long reads are served from the last loaded or written retentions
+	configMapRetentionCacheTTL = 2 * time.Second
+)
+
+// ConfigMapRetentionStore keeps retentions in a Kubernetes ConfigMap,
+// so they survive pod restarts and can be inspected with kubectl.
+// Reads are cached for a short time, and writes go through the cache.
+type ConfigMapRetentionStore struct {
+	clientSet kubernetes.Interface
+	namespace string
+	name      string
+	key       string
+
+	cacheTTL time.Duration
+	cached   retentionEntries
+	cachedAt time.Time
+	cacheMu  sync.Mutex
+}
+
+// NewConfigMapRetentionStore creates a retention store backed by the given ConfigMap.
+// The ConfigMap is created on the first write if it does not exist.
+func NewConfigMapRetentionStore(clientSet kubernetes.Interface, namespace string, name string) *ConfigMapRetentionStore {
+	return &ConfigMapRetentionStore{
+		clientSet: clientSet,
+		namespace: namespace,
+		name:      name,
+		key:       CONFIG_PCAP_RETENTIONS,
+		cacheTTL:  configMapRetentionCacheTTL,
+	}
+}
+
+// Put creates or replaces the retention for r.Name
+func (s *ConfigMapRetentionStore) Put(r Retention) error {
+	return s.update(func(entries retentionEntries) bool {
+		entries[r.Name] = r
+		return true
+	})
+}
+
+// Get returns the retention for name if it is still active
+func (s *ConfigMapRetentionStore) Get(name string) (Retention, bool, error) {
+	entries, err := s.read()
+	if err != nil {
+		return Retention{}, false, err
+	}
+
+	r, exists := entries[name]
+	if !exists || !r.Active(time.Now()) {
+		return Retention{}, false, nil
+	}
+
+	return r, true, nil
+}
+
+// Delete releases the retention for name, if any
+func (s *ConfigMapRetentionStore) Delete(name string) error {
+	return s.update(func(entries retentionEntries) bool {
+		if _, exists := entries[name]; !exists {
+			return false
+		}
+		delete(entries, name)
+		return true
+	})
+}
+
+// List returns all active retentions ordered by name
+func (s *ConfigMapRetentionStore) List() ([]Retention, error) {
+	entries, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return entries.active(time.Now()), nil
+}
+
+// PurgeExpired removes and returns the retentions that are no longer active at now
+func (s *ConfigMapRetentionStore) PurgeExpired(now time.Time) ([]Retention, error) {
+	var expired []Retention
+	err := s.update(func(entries retentionEntries) bool {
+		expired = entries.purge(now)
+		return len(expired) > 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+// read returns the cached entries, or loads them if the cache expired. The entries must not be modified.
+func (s *ConfigMapRetentionStore) read() (retentionEntries, error) {
+	s.cacheMu.Lock()
+	if s.cached != nil && time.Since(s.cachedAt) < s.cacheTTL {
+		defer s.cacheMu.Unlock()
+		return s.cached, nil
+	}
+	s.cacheMu.Unlock()
+
+	_, entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.cache(entries)
+
+	return entries, nil
+}
+
+// cache replaces the cached entries
+func (s *ConfigMapRetentionStore) cache(entries retentionEntries) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+
+	s.cached = entries
+	s.cachedAt = time.Now()
+}
+
+// load fetches the ConfigMap and decodes its retentions. A missing ConfigMap yields no entries.
+func (s *ConfigMapRetentionStore) load() (*v1.ConfigMap, retentionEntries, error) {
+	entries := make(retentionEntries)
+
+	configMap, err := s.clientSet.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, entries, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if data := configMap.Data[s.key]; data != "" {
+		if err := json.Unmarshal([]byte(data), &entries); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s in ConfigMap %s: %w", s.key, s.name, err)
+		}
+	}
+
+	return configMap, entries, nil
+}
+
+// update applies mutate to the stored entries and writes them back, retrying on conflicts.
+// mutate reports whether it changed anything; unchanged entries are not written.
+// The entries read or written replace the cache.
+func (s *ConfigMapRetentionStore) update(mutate func(entries retentionEntries) bool) error {
+	for i := 0; i < configMapRetentionMaxRetries; i++ {
+		configMap, entries, err := s.load()
+		if err != nil {
+			return err
+		}
+
+		if !mutate(entries) {
+			s.cache(entries)
+			return nil
+		}
+
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+
+		if configMap == nil {
+			configMap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      s.name,
+					Namespace: s.namespace,
+				},
+				Data: map[string]string{s.key: string(data)},
+			}
+			_, err = s.clientSet.CoreV1().ConfigMaps(s.namespace).Create(context.TODO(), configMap, metav1.CreateOptions{})
+		} else {
+			if configMap.Data == nil {
+				configMap.Data = make(map[string]string)
+			}
+			configMap.Data[s.key] = string(data)
+			_, err = s.clientSet.CoreV1().ConfigMaps(s.namespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
+		}
+		if err == nil {
+			s.cache(entries)
+			return nil
+		}
+
+		if k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err) {
+			log.Debug().Err(err).Str("configMap", s.name).Msg("Conflict detected, retrying retention update...")
+			continue
+		}
+
+		return err
+	}
+
+	return errors.New("max retries reached due to conflicts while updating retentions")
+}
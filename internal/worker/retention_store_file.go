@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileRetentionStore persists retentions as JSON in a local file, so they survive worker restarts
+type FileRetentionStore struct {
+	path    string
+	entries retentionEntries
+	mu      sync.Mutex
+}
+
+// NewFileRetentionStore creates a retention store backed by the file at path, loading any existing entries
+func NewFileRetentionStore(path string) (*FileRetentionStore, error) {
+	s := &FileRetentionStore{
+		path:    path,
+		entries: make(retentionEntries),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention file %s: %w", path, err)
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.entries); err != nil {
+			return nil, fmt.Errorf("failed to parse retention file %s: %w", path, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Put creates or replaces the retention for r.Name
+func (s *FileRetentionStore) Put(r Retention) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.entries[r.Name]
+	s.entries[r.Name] = r
+	if err := s.save(); err != nil {
+		if existed {
+			s.entries[r.Name] = previous
+		} else {
+			delete(s.entries, r.Name)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the retention for name if it is still active
+func (s *FileRetentionStore) Get(name string) (Retention, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, exists := s.entries[name]
+	if !exists || !r.Active(time.Now()) {
+		return Retention{}, false, nil
+	}
+
+	return r, true, nil
+}
+
+// Delete releases the retention for name, if any
+func (s *FileRetentionStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, exists := s.entries[name]
+	if !exists {
+		return nil
+	}
+
+	delete(s.entries, name)
+	if err := s.save(); err != nil {
+		s.entries[name] = previous
+		return err
+	}
+
+	return nil
+}
+
+// List returns all active retentions ordered by name
+func (s *FileRetentionStore) List() ([]Retention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.entries.active(time.Now()), nil
+}
+
+// PurgeExpired removes and returns the retentions that are no longer active at now
+func (s *FileRetentionStore) PurgeExpired(now time.Time) ([]Retention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := s.entries.purge(now)
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	if err := s.save(); err != nil {
+		for _, r := range expired {
+			s.entries[r.Name] = r
+		}
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+// save atomically replaces the backing file with the current entries
+func (s *FileRetentionStore) save() error {
+	data, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create retention directory: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write retention file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace retention file: %w", err)
+	}
+
+	return nil
+}
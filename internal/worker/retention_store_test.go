@@ -0,0 +1,229 @@
+package worker
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testRetentionStoreConformance checks the behaviour every RetentionStore implementation must share
+func testRetentionStoreConformance(t *testing.T, newStore func(t *testing.T) RetentionStore) {
+	t.Run("PutGet", func(t *testing.T) {
+		store := newStore(t)
+
+		if err := store.Put(Retention{Name: "a.pcap", Until: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		r, ok, err := store.Get("a.pcap")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if !ok || r.Name != "a.pcap" {
+			t.Errorf("Expected a.pcap to be retained, got %+v (ok=%v)", r, ok)
+		}
+
+		if _, ok, _ := store.Get("b.pcap"); ok {
+			t.Errorf("Unknown PCAP should not be retained")
+		}
+	})
+
+	t.Run("PutReplaces", func(t *testing.T) {
+		store := newStore(t)
+
+		until := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+		_ = store.Put(Retention{Name: "a.pcap", Until: time.Now().Add(time.Hour)})
+		if err := store.Put(Retention{Name: "a.pcap", Until: until}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		r, _, _ := store.Get("a.pcap")
+		if !r.Until.Equal(until) {
+			t.Errorf("Expected retention until %v, got %v", until, r.Until)
+		}
+
+		retentions, _ := store.List()
+		if len(retentions) != 1 {
+			t.Errorf("Expected 1 retention, got %d", len(retentions))
+		}
+	})
+
+	t.Run("ExpiredIsNotRetained", func(t *testing.T) {
+		store := newStore(t)
+
+		_ = store.Put(Retention{Name: "a.pcap", Until: time.Now().Add(-time.Second)})
+
+		if _, ok, _ := store.Get("a.pcap"); ok {
+			t.Errorf("Expired retention should not be reported")
+		}
+
+		retentions, _ := store.List()
+		if len(retentions) != 0 {
+			t.Errorf("Expired retention should not be listed, got %v", retentions)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+
+		_ = store.Put(Retention{Name: "a.pcap", Until: time.Now().Add(time.Hour)})
+		if err := store.Delete("a.pcap"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, ok, _ := store.Get("a.pcap"); ok {
+			t.Errorf("Deleted retention should not be reported")
+		}
+
+		if err := store.Delete("missing.pcap"); err != nil {
+			t.Errorf("Deleting an unknown retention should not fail: %v", err)
+		}
+	})
+
+	t.Run("ListOrdered", func(t *testing.T) {
+		store := newStore(t)
+
+		_ = store.Put(Retention{Name: "c.pcap", Until: time.Now().Add(time.Hour)})
+		_ = store.Put(Retention{Name: "a.pcap", Until: time.Now().Add(time.Hour)})
+		_ = store.Put(Retention{Name: "b.pcap", Until: time.Now().Add(time.Hour)})
+
+		retentions, err := store.List()
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(retentions) != 3 {
+			t.Fatalf("Expected 3 retentions, got %d", len(retentions))
+		}
+		for i, name := range []string{"a.pcap", "b.pcap", "c.pcap"} {
+			if retentions[i].Name != name {
+				t.Errorf("Expected retention %d to be %s, got %s", i, name, retentions[i].Name)
+			}
+		}
+	})
+
+	t.Run("PurgeExpired", func(t *testing.T) {
+		store := newStore(t)
+
+		now := time.Now()
+		_ = store.Put(Retention{Name: "old.pcap", Until: now.Add(time.Minute)})
+		_ = store.Put(Retention{Name: "new.pcap", Until: now.Add(time.Hour)})
+
+		expired, err := store.PurgeExpired(now.Add(2 * time.Minute))
+		if err != nil {
+			t.Fatalf("PurgeExpired failed: %v", err)
+		}
+		if len(expired) != 1 || expired[0].Name != "old.pcap" {
+			t.Errorf("Expected only old.pcap to be purged, got %v", expired)
+		}
+
+		if _, ok, _ := store.Get("old.pcap"); ok {
+			t.Errorf("Purged retention should not be reported")
+		}
+		if _, ok, _ := store.Get("new.pcap"); !ok {
+			t.Errorf("Active retention should survive the purge")
+		}
+
+		expired, _ = store.PurgeExpired(now.Add(2 * time.Minute))
+		if len(expired) != 0 {
+			t.Errorf("Second purge should be a no-op, got %v", expired)
+		}
+	})
+}
+
+func TestMemoryRetentionStore(t *testing.T) {
+	testRetentionStoreConformance(t, func(t *testing.T) RetentionStore {
+		return NewMemoryRetentionStore()
+	})
+}
+
+func TestFileRetentionStore(t *testing.T) {
+	testRetentionStoreConformance(t, func(t *testing.T) RetentionStore {
+		store, err := NewFileRetentionStore(filepath.Join(t.TempDir(), "retentions.json"))
+		if err != nil {
+			t.Fatalf("Failed to create file retention store: %v", err)
+		}
+		return store
+	})
+}
+
+func TestConfigMapRetentionStore(t *testing.T) {
+	testRetentionStoreConformance(t, func(t *testing.T) RetentionStore {
+		return NewConfigMapRetentionStore(fake.NewSimpleClientset(), "default", "kubeshark-pcap-retentions")
+	})
+}
+
+func TestConfigMapRetentionStoreCachesReads(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	store := NewConfigMapRetentionStore(clientSet, "default", "kubeshark-pcap-retentions")
+	gets := func() int {
+		count := 0
+		for _, action := range clientSet.Actions() {
+			if action.GetVerb() == "get" {
+				count++
+			}
+		}
+		return count
+	}
+
+	if err := store.Put(Retention{Name: "a.pcap", Until: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	before := gets()
+
+	// Writes go through the cache, so reads don't fetch the ConfigMap again
+	for i := 0; i < 10; i++ {
+		if _, ok, _ := store.Get("a.pcap"); !ok {
+			t.Fatalf("Retention should be found")
+		}
+	}
+	if err := store.Delete("a.pcap"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok, _ := store.Get("a.pcap"); ok {
+		t.Errorf("Deleted retention should not be found")
+	}
+	if after := gets(); after != before+1 {
+		t.Errorf("Expected only the Delete to fetch the ConfigMap, got %d gets", after-before)
+	}
+
+	// Once the cache expires, the ConfigMap is fetched again
+	store.cacheTTL = 0
+	_, _, _ = store.Get("a.pcap")
+	if after := gets(); after != before+2 {
+		t.Errorf("Expected an expired cache to fetch the ConfigMap, got %d gets", after-before)
+	}
+}
+
+func TestFileRetentionStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retentions.json")
+
+	store, err := NewFileRetentionStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create file retention store: %v", err)
+	}
+	_ = store.Put(Retention{Name: "a.pcap", Until: time.Now().Add(time.Hour)})
+
+	reopened, err := NewFileRetentionStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen file retention store: %v", err)
+	}
+	if _, ok, _ := reopened.Get("a.pcap"); !ok {
+		t.Errorf("Retention should survive reopening the store")
+	}
+}
+
+func TestPcapManagerAndRetentionShareStore(t *testing.T) {
+	manager := NewPcapManager(t.TempDir(), 20*time.Second, 1024*1024)
+	retention := NewPcapRetentionWithStore(manager.RetentionStore(), 20*time.Second)
+
+	retention.RetainPcap("test1.pcap", time.Hour)
+	if !manager.IsRetained("test1.pcap") {
+		t.Errorf("Retention made through PcapRetention should be visible to PcapManager")
+	}
+
+	_ = manager.ReleasePcap("test1.pcap")
+	if retention.ShouldRetain("test1.pcap") {
+		t.Errorf("Release through PcapManager should be visible to PcapRetention")
+	}
+}
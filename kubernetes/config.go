@@ -11,6 +11,7 @@ import (
 	"github.com/rs/zerolog/log"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
@@ -29,6 +30,9 @@ const (
 	CONFIG_AUTH_SAML_IDP_METADATA_URL = "AUTH_SAML_IDP_METADATA_URL"
 	CONFIG_SCRIPTING_SCRIPTS          = "SCRIPTING_SCRIPTS"
 	CONFIG_SCRIPTING_ACTIVE_SCRIPTS   = "SCRIPTING_ACTIVE_SCRIPTS"
+	CONFIG_SCRIPTING_LIBRARIES        = "SCRIPTING_LIBRARIES"
+	CONFIG_SCRIPTING_HISTORY          = "SCRIPTING_HISTORY"
+	CONFIG_SCRIPTING_OPTIONS          = "SCRIPTING_OPTIONS"
 	CONFIG_PCAP_DUMP_ENABLE           = "PCAP_DUMP_ENABLE"
 	CONFIG_TIME_INTERVAL              = "TIME_INTERVAL"
 	CONFIG_MAX_TIME                   = "MAX_TIME"
@@ -69,6 +73,29 @@ func GetConfig(provider *Provider, key string) (value string, err error) {
 	return
 }
 
+// UpdateConfig replaces the value of key with the one update derives from it. The ConfigMap is
+// read again and update called again when it was changed concurrently.
+func UpdateConfig(provider *Provider, key string, update func(value string) (string, error)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := provider.clientSet.CoreV1().ConfigMaps(config.Config.Tap.Release.Namespace).Get(context.TODO(), SELF_RESOURCES_PREFIX+SUFFIX_CONFIG_MAP, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		value, err := update(configMap.Data[key])
+		if err != nil || value == configMap.Data[key] {
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[key] = value
+		_, err = provider.clientSet.CoreV1().ConfigMaps(config.Config.Tap.Release.Namespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
 func SetConfig(provider *Provider, key string, value string) (updated bool, err error) {
 	var configMap *v1.ConfigMap
 	configMap, err = provider.clientSet.CoreV1().ConfigMaps(config.Config.Tap.Release.Namespace).Get(context.TODO(), SELF_RESOURCES_PREFIX+SUFFIX_CONFIG_MAP, metav1.GetOptions{})